@@ -0,0 +1,195 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ZT/kafka-connector/server"
+	"git.zabbix.com/ZT/kafka-connector/watch"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+const (
+	masked = "******"
+	// spilledMetric counts the records written to the spool.
+	spilledMetric = "spool.spilled"
+)
+
+var _ server.Admin = &admin{}
+
+// secretOptions are the configuration options masked in the admin API, in any section.
+var secretOptions = map[string]bool{"Password": true, "BearerToken": true}
+
+// admin exposes the configuration and the producers to the admin API and reloads the watched files on request.
+// It is filled in before the server starts, so it needs no locking.
+type admin struct {
+	config    *configuration
+	producers []*kafka.DefaultProducer
+	reloads   []reload
+}
+
+// spoolStatus is the state of the spool for the admin API.
+type spoolStatus struct {
+	Dir     string `json:"dir"`
+	Size    int64  `json:"size"`
+	Spilled uint64 `json:"spilled"`
+}
+
+type reload struct {
+	name string
+	fn   func() error
+}
+
+// watchFile watches the files like the package level watchFile and registers the reload for the admin API.
+func (a *admin) watchFile(files []string, interval int, name string, fn func() error) *watch.Watcher {
+	a.reloads = append(a.reloads, reload{name: name, fn: fn})
+
+	return watchFile(files, interval, name, fn)
+}
+
+// Config returns the loaded configuration with secrets masked.
+func (a *admin) Config() any {
+	out, err := maskSecrets(a.config)
+	if err != nil {
+		log.Errf("failed to mask configuration, %s", err.Error())
+
+		return map[string]any{}
+	}
+
+	return out
+}
+
+// Status returns the status of every Kafka producer by cluster name.
+func (a *admin) Status() any {
+	out := make(map[string]kafka.Status, len(a.producers))
+
+	for _, p := range a.producers {
+		name := p.Name()
+		if name == "" {
+			name = "kafka"
+		}
+
+		out[name] = p.Status()
+	}
+
+	return out
+}
+
+// Spool returns the directory of the spool, the size of its files and the number of records written to it,
+// nil if no spool directory is configured.
+func (a *admin) Spool() any {
+	dir := a.config.Connector.SpoolDir
+	if dir == "" {
+		return nil
+	}
+
+	size, err := dirSize(dir)
+	if err != nil {
+		log.Debugf("failed to get the spool size, %s", err.Error())
+	}
+
+	return spoolStatus{Dir: dir, Size: size, Spilled: metrics.GetCounter(spilledMetric).Value()}
+}
+
+// ReloadFiles reloads all watched files, a failed reload keeps the previous contents.
+func (a *admin) ReloadFiles() error {
+	var errList []error
+
+	for _, r := range a.reloads {
+		err := r.fn()
+		if err != nil {
+			log.Errf("failed to reload %s, keeping previous %s, %s", r.name, r.name, err.Error())
+			errList = append(errList, errs.Wrapf(err, "failed to reload %s", r.name))
+
+			continue
+		}
+
+		log.Infof("reloaded %s", r.name)
+	}
+
+	return errors.Join(errList...)
+}
+
+// dirSize returns the total size of the regular files in dir, zero if it does not exist.
+func dirSize(dir string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, errs.Wrapf(err, "failed to read %s", dir)
+	}
+
+	var size int64
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// maskSecrets returns the configuration as generic JSON values with the secret options masked.
+func maskSecrets(c *configuration) (any, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to marshal configuration")
+	}
+
+	var out any
+
+	err = json.Unmarshal(b, &out)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to unmarshal configuration")
+	}
+
+	mask(out)
+
+	return out, nil
+}
+
+func mask(v any) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+
+	for k, value := range m {
+		if s, ok := value.(string); ok && secretOptions[k] && s != "" {
+			m[k] = masked
+
+			continue
+		}
+
+		mask(value)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"os"
+	"strings"
+	"sync"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Permissions granted to clients.
+const (
+	PermissionItems  = "items"
+	PermissionEvents = "events"
+	PermissionAdmin  = "admin"
+)
+
+type clientKey struct{}
+
+// Client is an authenticated client with its permissions.
+type Client struct {
+	Name        string
+	permissions map[string]bool
+}
+
+// Tokens maps bearer tokens to clients, loaded from a token file.
+type Tokens struct {
+	mu      sync.RWMutex
+	file    string
+	entries []entry
+}
+
+type entry struct {
+	token  []byte
+	client *Client
+}
+
+// NewClient creates a client with the provided permissions.
+func NewClient(name string, permissions ...string) *Client {
+	c := &Client{Name: name, permissions: map[string]bool{}}
+
+	for _, p := range permissions {
+		c.permissions[p] = true
+	}
+
+	return c
+}
+
+// Allowed returns true if the client has the permission.
+func (c *Client) Allowed(permission string) bool {
+	return c.permissions[permission]
+}
+
+// WithClient returns a copy of the context carrying the client.
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// FromContext returns the client stored in the context, nil if there is none.
+func FromContext(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey{}).(*Client) //nolint:errcheck // nil client is handled by callers
+
+	return c
+}
+
+// NewTokens loads the token file.
+func NewTokens(file string) (*Tokens, error) {
+	t := &Tokens{file: file}
+
+	err := t.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Reload reads the token file again, the current tokens are kept if the file is invalid.
+func (t *Tokens) Reload() error {
+	entries, err := load(t.file)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.entries = entries
+	t.mu.Unlock()
+
+	log.Infof("loaded %d client tokens from %s", len(entries), t.file)
+
+	return nil
+}
+
+// Lookup returns the client of the token. Every token is compared in constant time,
+// so the lookup time does not depend on which token matched or how much of it.
+func (t *Tokens) Lookup(token string) (*Client, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var found *Client
+
+	for _, e := range t.entries {
+		if subtle.ConstantTimeCompare(e.token, []byte(token)) == 1 {
+			found = e.client
+		}
+	}
+
+	return found, found != nil
+}
+
+// load parses a token file. Every non empty line, not starting with #, holds a client name,
+// its token and a comma-separated list of permissions, separated by whitespace.
+func load(file string) ([]entry, error) {
+	var (
+		entries []entry
+		names   = map[string]bool{}
+		tokens  = map[string]bool{}
+	)
+
+	err := readLines(file, func(name, token string, perms []string) error {
+		if names[name] {
+			return errs.Errorf("duplicate client %s", name)
+		}
+
+		if tokens[token] {
+			return errs.New("duplicate token")
+		}
+
+		names[name] = true
+		tokens[token] = true
+		entries = append(entries, entry{[]byte(token), NewClient(name, perms...)})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read token file")
+	}
+
+	return entries, nil
+}
+
+// readLines calls fn for every non empty line of the file, not starting with #, that holds a client name,
+// a credential and a comma-separated list of permissions, separated by whitespace.
+func readLines(file string, fn func(name, credential string, perms []string) error) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return errs.Wrap(err, "failed to read file")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+
+	var lineNum int
+
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return errs.Errorf("invalid line %d: expected client name, credential and permissions", lineNum)
+		}
+
+		perms, err := parsePermissions(fields[2])
+		if err != nil {
+			return errs.Wrapf(err, "invalid line %d", lineNum)
+		}
+
+		err = fn(fields[0], fields[1], perms)
+		if err != nil {
+			return errs.Wrapf(err, "invalid line %d", lineNum)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func parsePermissions(s string) ([]string, error) {
+	perms := strings.Split(s, ",")
+	for _, p := range perms {
+		switch p {
+		case PermissionItems, PermissionEvents, PermissionAdmin:
+		default:
+			return nil, errs.Errorf("unknown permission %q", p)
+		}
+	}
+
+	return perms, nil
+}
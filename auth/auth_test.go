@@ -0,0 +1,206 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTokens_Lookup(t *testing.T) {
+	t.Parallel()
+
+	file := writeTokens(t, `
+# client  token  permissions
+zabbix-eu   eutoken   items,events
+zabbix-us   ustoken   items
+admin       admintoken admin
+`)
+
+	tokens, err := NewTokens(file)
+	if err != nil {
+		t.Fatalf("NewTokens() unexpected error: %s", err.Error())
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantName  string
+		wantPerms map[string]bool
+		wantOk    bool
+	}{
+		{
+			"+eu",
+			"eutoken",
+			"zabbix-eu",
+			map[string]bool{PermissionItems: true, PermissionEvents: true, PermissionAdmin: false},
+			true,
+		},
+		{
+			"+us",
+			"ustoken",
+			"zabbix-us",
+			map[string]bool{PermissionItems: true, PermissionEvents: false, PermissionAdmin: false},
+			true,
+		},
+		{
+			"+admin",
+			"admintoken",
+			"admin",
+			map[string]bool{PermissionItems: false, PermissionEvents: false, PermissionAdmin: true},
+			true,
+		},
+		{"-unknown", "foobar", "", nil, false},
+		{"-prefix", "eutoke", "", nil, false},
+		{"-empty", "", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := tokens.Lookup(tt.token)
+			if ok != tt.wantOk {
+				t.Fatalf("Tokens.Lookup() expected ok: %t, but got: %t", tt.wantOk, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if got.Name != tt.wantName {
+				t.Fatalf("Tokens.Lookup() expected client: %s, but got: %s", tt.wantName, got.Name)
+			}
+
+			perms := map[string]bool{}
+			for p := range tt.wantPerms {
+				perms[p] = got.Allowed(p)
+			}
+
+			if diff := cmp.Diff(tt.wantPerms, perms); diff != "" {
+				t.Fatalf("Tokens.Lookup() permissions = %s", diff)
+			}
+		})
+	}
+}
+
+func TestTokens_Reload(t *testing.T) {
+	t.Parallel()
+
+	file := writeTokens(t, "zabbix old items\n")
+
+	tokens, err := NewTokens(file)
+	if err != nil {
+		t.Fatalf("NewTokens() unexpected error: %s", err.Error())
+	}
+
+	err = os.WriteFile(file, []byte("zabbix new items\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to rewrite token file: %s", err.Error())
+	}
+
+	err = tokens.Reload()
+	if err != nil {
+		t.Fatalf("Tokens.Reload() unexpected error: %s", err.Error())
+	}
+
+	if _, ok := tokens.Lookup("old"); ok {
+		t.Fatalf("Tokens.Reload() expected rotated token to be rejected")
+	}
+
+	if _, ok := tokens.Lookup("new"); !ok {
+		t.Fatalf("Tokens.Reload() expected new token to be accepted")
+	}
+
+	err = os.WriteFile(file, []byte("zabbix broken\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to rewrite token file: %s", err.Error())
+	}
+
+	if tokens.Reload() == nil {
+		t.Fatalf("Tokens.Reload() expected error for invalid file")
+	}
+
+	if _, ok := tokens.Lookup("new"); !ok {
+		t.Fatalf("Tokens.Reload() expected tokens to be kept after failed reload")
+	}
+}
+
+func Test_load(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		content     string
+		wantClients int
+		wantErr     bool
+	}{
+		{"+valid", "a ta items\nb tb events,admin\n", 2, false},
+		{"+commentsAndEmptyLines", "# comment\n\n  a ta items  \n", 1, false},
+		{"+empty", "", 0, false},
+		{"-missingPermissions", "a ta\n", 0, true},
+		{"-unknownPermission", "a ta items,write\n", 0, true},
+		{"-duplicateClient", "a ta items\na tb items\n", 0, true},
+		{"-duplicateToken", "a ta items\nb ta items\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := load(writeTokens(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != tt.wantClients {
+				t.Fatalf("load() expected %d clients, but got: %d", tt.wantClients, len(got))
+			}
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	if FromContext(context.Background()) != nil {
+		t.Fatalf("FromContext() expected nil client for empty context")
+	}
+
+	c := NewClient("foo", PermissionItems)
+
+	if FromContext(WithClient(context.Background(), c)) != c {
+		t.Fatalf("FromContext() expected the stored client")
+	}
+}
+
+func writeTokens(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "tokens")
+
+	err := os.WriteFile(file, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write token file: %s", err.Error())
+	}
+
+	return file
+}
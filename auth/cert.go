@@ -0,0 +1,171 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package auth
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+	"sync"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Certificate identity prefixes, matched against the subject common name and subject alternative names.
+const (
+	identityCN    = "CN="
+	identityDNS   = "DNS:"
+	identityIP    = "IP:"
+	identityURI   = "URI:"
+	identityEmail = "EMAIL:"
+)
+
+// Certificates maps verified client certificate identities to clients, loaded from an allow-list file.
+type Certificates struct {
+	mu         sync.RWMutex
+	file       string
+	identities map[string]*Client
+}
+
+// NewCertificates loads the certificate allow-list file.
+func NewCertificates(file string) (*Certificates, error) {
+	c := &Certificates{file: file}
+
+	err := c.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the allow-list file again, the current identities are kept if the file is invalid.
+func (c *Certificates) Reload() error {
+	identities, err := loadIdentities(c.file)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.identities = identities
+	c.mu.Unlock()
+
+	log.Infof("loaded %d client certificate identities from %s", len(identities), c.file)
+
+	return nil
+}
+
+// Lookup returns the client of the first certificate identity found in the allow-list.
+// The subject common name is checked first, followed by DNS, IP, URI and email subject alternative names.
+func (c *Certificates) Lookup(cert *x509.Certificate) (*Client, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, id := range identities(cert) {
+		if client, ok := c.identities[id]; ok {
+			return client, true
+		}
+	}
+
+	return nil, false
+}
+
+// identities returns all identities of a certificate in the allow-list format.
+func identities(cert *x509.Certificate) []string {
+	var out []string
+
+	if cert.Subject.CommonName != "" {
+		out = append(out, identityCN+cert.Subject.CommonName)
+	}
+
+	for _, n := range cert.DNSNames {
+		out = append(out, identityDNS+strings.ToLower(n))
+	}
+
+	for _, ip := range cert.IPAddresses {
+		out = append(out, identityIP+ip.String())
+	}
+
+	for _, u := range cert.URIs {
+		out = append(out, identityURI+u.String())
+	}
+
+	for _, e := range cert.EmailAddresses {
+		out = append(out, identityEmail+e)
+	}
+
+	return out
+}
+
+// loadIdentities parses a certificate allow-list file. Every non empty line, not starting with #, holds
+// a client name, a certificate identity and a comma-separated list of permissions, separated by whitespace.
+// A client can have multiple identities, each on its own line.
+func loadIdentities(file string) (map[string]*Client, error) {
+	var (
+		out     = map[string]*Client{}
+		clients = map[string]*Client{}
+	)
+
+	err := readLines(file, func(name, identity string, perms []string) error {
+		id, err := normalizeIdentity(identity)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := out[id]; ok {
+			return errs.Errorf("duplicate identity %s", identity)
+		}
+
+		client, ok := clients[name]
+		if !ok {
+			client = NewClient(name)
+			clients[name] = client
+		}
+
+		for _, p := range perms {
+			client.permissions[p] = true
+		}
+
+		out[id] = client
+
+		return nil
+	})
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read client certificate file")
+	}
+
+	return out, nil
+}
+
+func normalizeIdentity(identity string) (string, error) {
+	switch {
+	case strings.HasPrefix(identity, identityCN):
+		return identity, nil
+	case strings.HasPrefix(identity, identityDNS):
+		return identityDNS + strings.ToLower(strings.TrimPrefix(identity, identityDNS)), nil
+	case strings.HasPrefix(identity, identityIP):
+		ip := net.ParseIP(strings.TrimPrefix(identity, identityIP))
+		if ip == nil {
+			return "", errs.Errorf("invalid ip address in identity %s", identity)
+		}
+
+		return identityIP + ip.String(), nil
+	case strings.HasPrefix(identity, identityURI), strings.HasPrefix(identity, identityEmail):
+		return identity, nil
+	}
+
+	return "", errs.Errorf("unsupported identity %s, expected CN=, DNS:, IP:, URI: or EMAIL: prefix", identity)
+}
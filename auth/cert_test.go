@@ -0,0 +1,140 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestCertificates_Lookup(t *testing.T) {
+	t.Parallel()
+
+	file := writeTokens(t, `
+# client     identity                     permissions
+zabbix-eu    CN=zabbix-eu                 items
+zabbix-eu    DNS:Zabbix.EU.example.com    events
+zabbix-us    IP:10.0.0.1                  items,events
+zabbix-ap    URI:spiffe://example.com/ap  items
+ops          EMAIL:ops@example.com        admin
+`)
+
+	certs, err := NewCertificates(file)
+	if err != nil {
+		t.Fatalf("NewCertificates() unexpected error: %s", err.Error())
+	}
+
+	spiffe, err := url.Parse("spiffe://example.com/ap")
+	if err != nil {
+		t.Fatalf("failed to parse uri: %s", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantClient string
+		wantPerm   string
+		wantOk     bool
+	}{
+		{
+			"+commonName",
+			&x509.Certificate{Subject: pkix.Name{CommonName: "zabbix-eu"}},
+			"zabbix-eu",
+			PermissionEvents,
+			true,
+		},
+		{
+			"+dnsCaseInsensitive",
+			&x509.Certificate{DNSNames: []string{"other.example.com", "zabbix.eu.example.com"}},
+			"zabbix-eu",
+			PermissionItems,
+			true,
+		},
+		{
+			"+ip",
+			&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			"zabbix-us",
+			PermissionEvents,
+			true,
+		},
+		{"+uri", &x509.Certificate{URIs: []*url.URL{spiffe}}, "zabbix-ap", PermissionItems, true},
+		{"+email", &x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, "ops", PermissionAdmin, true},
+		{"-unknownCommonName", &x509.Certificate{Subject: pkix.Name{CommonName: "zabbix"}}, "", "", false},
+		{"-commonNameAsDNS", &x509.Certificate{DNSNames: []string{"zabbix-eu"}}, "", "", false},
+		{"-noIdentities", &x509.Certificate{}, "", "", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := certs.Lookup(tt.cert)
+			if ok != tt.wantOk {
+				t.Fatalf("Certificates.Lookup() expected ok: %t, but got: %t", tt.wantOk, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			if got.Name != tt.wantClient {
+				t.Fatalf("Certificates.Lookup() expected client: %s, but got: %s", tt.wantClient, got.Name)
+			}
+
+			if !got.Allowed(tt.wantPerm) {
+				t.Fatalf("Certificates.Lookup() expected client to have permission: %s", tt.wantPerm)
+			}
+		})
+	}
+}
+
+func Test_loadIdentities(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		content        string
+		wantIdentities int
+		wantErr        bool
+	}{
+		{"+valid", "a CN=a items\nb DNS:b.example.com events\n", 2, false},
+		{"+multipleIdentities", "a CN=a items\na IP:::1 events\n", 2, false},
+		{"-duplicateIdentity", "a CN=a items\nb CN=a items\n", 0, true},
+		{"-duplicateDNSIdentity", "a DNS:A.example.com items\nb DNS:a.example.com items\n", 0, true},
+		{"-unsupportedIdentity", "a O=Zabbix items\n", 0, true},
+		{"-invalidIP", "a IP:foo items\n", 0, true},
+		{"-unknownPermission", "a CN=a write\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := loadIdentities(writeTokens(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadIdentities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != tt.wantIdentities {
+				t.Fatalf("loadIdentities() expected %d identities, but got: %d", tt.wantIdentities, len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+// Package certs provides TLS certificates which can be reloaded from disk at runtime.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Pool is a CA certificate pool loaded from a file.
+type Pool struct {
+	mu   sync.RWMutex
+	file string
+	pool *x509.CertPool
+}
+
+// KeyPair is a certificate and key loaded from files, served to new TLS connections.
+type KeyPair struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+// NewKeyPair loads the certificate and key files.
+func NewKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errs.New("both tls certificate and key file paths must be set")
+	}
+
+	k := &KeyPair{certFile: certFile, keyFile: keyFile}
+
+	err := k.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload reads the certificate and key files again, the current key pair is kept if they are invalid,
+// for example while only one of them has been replaced.
+func (k *KeyPair) Reload() error {
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return errs.Wrap(err, "failed to load tls key pair")
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return errs.Wrap(err, "failed to parse tls certificate")
+	}
+
+	k.mu.Lock()
+	k.cert = &cert
+	k.mu.Unlock()
+
+	log.Infof(
+		"loaded tls certificate %s, subject: %s, valid until: %s",
+		k.certFile, cert.Leaf.Subject.String(), cert.Leaf.NotAfter.String(),
+	)
+
+	return nil
+}
+
+// Certificate returns the current key pair.
+func (k *KeyPair) Certificate() *tls.Certificate {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.cert
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return k.Certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (k *KeyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return k.Certificate(), nil
+}
+
+// Files returns the certificate and key file paths.
+func (k *KeyPair) Files() []string {
+	return []string{k.certFile, k.keyFile}
+}
+
+// NewPool loads the CA certificate file.
+func NewPool(file string) (*Pool, error) {
+	if file == "" {
+		return nil, errs.New("tls CA file path must be set")
+	}
+
+	p := &Pool{file: file}
+
+	err := p.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Reload reads the CA certificate file again, the current pool is kept if the file is invalid.
+func (p *Pool) Reload() error {
+	b, err := os.ReadFile(p.file)
+	if err != nil {
+		return errs.Wrap(err, "failed to read tls CA file")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return errs.Errorf("no certificates found in tls CA file %s", p.file)
+	}
+
+	p.mu.Lock()
+	p.pool = pool
+	p.mu.Unlock()
+
+	log.Infof("loaded tls CA file %s", p.file)
+
+	return nil
+}
+
+// Pool returns the current CA certificate pool.
+func (p *Pool) Pool() *x509.CertPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.pool
+}
+
+// File returns the CA certificate file path.
+func (p *Pool) File() string {
+	return p.file
+}
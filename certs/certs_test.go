@@ -0,0 +1,185 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewKeyPair(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeKeyPair(t, cert, key, "foo")
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{"+valid", cert, key, false},
+		{"-emptyCert", "", key, true},
+		{"-emptyKey", cert, "", true},
+		{"-missingFiles", filepath.Join(dir, "foo"), filepath.Join(dir, "bar"), true},
+		{"-swapped", key, cert, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewKeyPair(tt.cert, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKeyPair() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyPair_Reload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeKeyPair(t, cert, key, "old")
+
+	k, err := NewKeyPair(cert, key)
+	if err != nil {
+		t.Fatalf("NewKeyPair() unexpected error: %s", err.Error())
+	}
+
+	writeKeyPair(t, cert, key, "new")
+
+	err = k.Reload()
+	if err != nil {
+		t.Fatalf("KeyPair.Reload() unexpected error: %s", err.Error())
+	}
+
+	got, err := k.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("KeyPair.GetCertificate() unexpected error: %s", err.Error())
+	}
+
+	if got.Leaf.Subject.CommonName != "new" {
+		t.Fatalf("KeyPair.GetCertificate() expected certificate: new, but got: %s", got.Leaf.Subject.CommonName)
+	}
+
+	// only the certificate is replaced, the key no longer matches.
+	writeKeyPair(t, cert, filepath.Join(dir, "other.pem"), "newer")
+
+	if k.Reload() == nil {
+		t.Fatalf("KeyPair.Reload() expected error for mismatched key pair")
+	}
+
+	if k.Certificate().Leaf.Subject.CommonName != "new" {
+		t.Fatalf("KeyPair.Reload() expected previous certificate to be kept")
+	}
+}
+
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err.Error())
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err.Error())
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %s", err.Error())
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %s", err.Error())
+	}
+}
+
+func TestPool_Reload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	writeKeyPair(t, ca, filepath.Join(dir, "key.pem"), "old")
+
+	p, err := NewPool(ca)
+	if err != nil {
+		t.Fatalf("NewPool() unexpected error: %s", err.Error())
+	}
+
+	old := p.Pool()
+
+	writeKeyPair(t, ca, filepath.Join(dir, "key.pem"), "new")
+
+	err = p.Reload()
+	if err != nil {
+		t.Fatalf("Pool.Reload() unexpected error: %s", err.Error())
+	}
+
+	if p.Pool().Equal(old) {
+		t.Fatalf("Pool.Reload() expected pool to be replaced")
+	}
+
+	reloaded := p.Pool()
+
+	err = os.WriteFile(ca, []byte("foobar"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write CA file: %s", err.Error())
+	}
+
+	if p.Reload() == nil {
+		t.Fatalf("Pool.Reload() expected error for invalid file")
+	}
+
+	if !p.Pool().Equal(reloaded) {
+		t.Fatalf("Pool.Reload() expected previous pool to be kept")
+	}
+
+	_, err = NewPool("")
+	if err == nil {
+		t.Fatalf("NewPool() expected error for empty path")
+	}
+}
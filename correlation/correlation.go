@@ -0,0 +1,410 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package correlation
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+const (
+	eventValueProblem  = 1
+	eventValueRecovery = 0
+)
+
+// Configuration holds event correlation configuration tags based on Zabbix configuration package from plugin support.
+type Configuration struct {
+	Enable          bool   `conf:"default=false"`
+	StateFile       string `conf:"optional"`
+	MaxOpenProblems int    `conf:"range=0:10000000,default=100000"`
+	SaveInterval    int    `conf:"range=1:3600,default=10"`
+}
+
+// Correlator keeps track of open problems and enriches recovery events with their problem data.
+type Correlator struct {
+	mu       sync.Mutex
+	problems map[uint64]problem
+	file     string
+	dirty    bool
+
+	// order holds the IDs of open problems, oldest first, elems their elements by ID.
+	order     *list.List
+	elems     map[uint64]*list.Element
+	max       int
+	evictions *metrics.Counter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Batch correlates the events of one request in order, events see the changes of the events before them
+// in the batch, although the changes are applied only once the events are produced.
+type Batch struct {
+	c       *Correlator
+	pending map[uint64]*Update
+}
+
+// Update is a change of the current problems state, keyed by problem event ID.
+// Empty Data means the problem is resolved and a tombstone must be produced.
+type Update struct {
+	Key  string
+	Data string
+
+	id      uint64
+	problem *problem
+}
+
+type problem struct {
+	Clock int64           `json:"clock"`
+	NS    int64           `json:"ns"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type event struct {
+	EventID  uint64 `json:"eventid"`
+	PEventID uint64 `json:"p_eventid"` //nolint:tagliatelle // Zabbix export field name
+	Clock    int64  `json:"clock"`
+	NS       int64  `json:"ns"`
+	Value    int    `json:"value"`
+}
+
+// New creates a correlator, loading open problems from the state file if one is configured.
+// Once more than MaxOpenProblems problems are open, the oldest ones are forgotten, zero means no limit.
+func New(c *Configuration) (*Correlator, error) {
+	cr := &Correlator{
+		problems:  map[uint64]problem{},
+		file:      c.StateFile,
+		max:       c.MaxOpenProblems,
+		evictions: metrics.GetCounter("correlation.evictions"),
+	}
+
+	if cr.file == "" {
+		return cr, nil
+	}
+
+	b, err := os.ReadFile(cr.file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cr, nil
+		}
+
+		return nil, errs.Wrap(err, "failed to read correlation state file")
+	}
+
+	err = json.Unmarshal(b, &cr.problems)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to parse correlation state file")
+	}
+
+	ids := make([]uint64, 0, len(cr.problems))
+	for id := range cr.problems {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		pi, pj := cr.problems[ids[i]], cr.problems[ids[j]]
+
+		return pi.Clock < pj.Clock || (pi.Clock == pj.Clock && pi.NS < pj.NS)
+	})
+
+	for _, id := range ids {
+		cr.track(id)
+	}
+
+	cr.evict()
+
+	log.Infof("loaded %d open problems from %s", len(cr.problems), cr.file)
+
+	return cr, nil
+}
+
+// NewBatch returns a batch correlating the events of one request.
+func (c *Correlator) NewBatch() *Batch {
+	return &Batch{c: c, pending: map[uint64]*Update{}}
+}
+
+// Correlate returns the change a problem or recovery event makes to the open problems, like Correlator.Correlate,
+// but recovery events also match problems of the events correlated before them in the batch.
+func (b *Batch) Correlate(data string) (string, *Update, error) {
+	out, update, err := b.c.correlate(data, b.pending)
+	if err != nil || update == nil {
+		return out, update, err
+	}
+
+	b.pending[update.id] = update
+
+	return out, update, nil
+}
+
+// Correlate returns the change a problem or recovery event makes to the open problems.
+// Recovery events are returned with the problem_duration (in seconds) and problem fields added,
+// if the matching problem is known. The open problems are not changed until the returned update is applied
+// with Apply, so an event that fails to be produced is correlated the same way when it is retried.
+func (c *Correlator) Correlate(data string) (string, *Update, error) {
+	return c.correlate(data, nil)
+}
+
+// correlate correlates the event, pending updates not applied yet take precedence over the open problems.
+func (c *Correlator) correlate(data string, pending map[uint64]*Update) (string, *Update, error) {
+	var e event
+
+	err := json.Unmarshal([]byte(data), &e)
+	if err != nil {
+		return "", nil, errs.Wrap(err, "failed to unmarshal event for correlation")
+	}
+
+	switch {
+	case e.Value == eventValueProblem:
+		return data, &Update{
+			Key:     strconv.FormatUint(e.EventID, 10),
+			Data:    data,
+			id:      e.EventID,
+			problem: &problem{e.Clock, e.NS, json.RawMessage(data)},
+		}, nil
+	case e.Value == eventValueRecovery && e.PEventID != 0:
+		p, ok := c.lookup(e.PEventID, pending)
+
+		update := &Update{Key: strconv.FormatUint(e.PEventID, 10), id: e.PEventID}
+
+		if !ok {
+			log.Debugf("no open problem %d found for recovery event %d", e.PEventID, e.EventID)
+
+			return data, update, nil
+		}
+
+		out, err := enrich(data, &e, &p)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return out, update, nil
+	}
+
+	return data, nil, nil
+}
+
+// Apply registers the problem or resolves the open problem of an update returned by Correlate,
+// it is called once the event and the update are produced.
+func (c *Correlator) Apply(u *Update) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if u.problem != nil {
+		c.problems[u.id] = *u.problem
+		c.dirty = true
+
+		c.track(u.id)
+		c.evict()
+
+		return
+	}
+
+	if _, ok := c.problems[u.id]; ok {
+		delete(c.problems, u.id)
+		c.dirty = true
+
+		c.untrack(u.id)
+	}
+}
+
+// StartSaving saves the open problems to the state file at the interval, instead of on every change,
+// until Close. It does nothing if no state file is configured.
+func (c *Correlator) StartSaving(interval time.Duration) {
+	if c.file == "" {
+		return
+	}
+
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				err := c.Save()
+				if err != nil {
+					log.Errf("failed to save open problems, %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Close stops saving at the interval and saves the remaining changes.
+func (c *Correlator) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+
+		c.stop = nil
+	}
+
+	return c.Save()
+}
+
+// Open returns the amount of currently open problems.
+func (c *Correlator) Open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.problems)
+}
+
+// Save writes open problems to the state file, if it is configured and problems changed since the last save.
+func (c *Correlator) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == "" || !c.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(c.problems)
+	if err != nil {
+		return errs.Wrap(err, "failed to marshal open problems")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".*")
+	if err != nil {
+		return errs.Wrap(err, "failed to create temporary correlation state file")
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck // removed only if rename failed
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close() //nolint:errcheck,gosec // write error is returned
+
+		return errs.Wrap(err, "failed to write correlation state file")
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errs.Wrap(err, "failed to close correlation state file")
+	}
+
+	err = os.Rename(tmp.Name(), c.file)
+	if err != nil {
+		return errs.Wrap(err, "failed to replace correlation state file")
+	}
+
+	c.dirty = false
+
+	return nil
+}
+
+// lookup returns the open problem, a pending update registering or resolving it takes precedence.
+func (c *Correlator) lookup(id uint64, pending map[uint64]*Update) (problem, bool) {
+	if u, ok := pending[id]; ok {
+		if u.problem == nil {
+			return problem{}, false
+		}
+
+		return *u.problem, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.problems[id]
+
+	return p, ok
+}
+
+// track registers the problem as the newest open problem, the mutex must be held.
+func (c *Correlator) track(id uint64) {
+	if c.order == nil {
+		c.order = list.New()
+		c.elems = map[uint64]*list.Element{}
+	}
+
+	if el, ok := c.elems[id]; ok {
+		c.order.MoveToBack(el)
+
+		return
+	}
+
+	c.elems[id] = c.order.PushBack(id)
+}
+
+// untrack removes the resolved problem from the order, the mutex must be held.
+func (c *Correlator) untrack(id uint64) {
+	if el, ok := c.elems[id]; ok {
+		c.order.Remove(el)
+		delete(c.elems, id)
+	}
+}
+
+// evict forgets the oldest open problems over the limit, their recovery events are not enriched,
+// the mutex must be held.
+func (c *Correlator) evict() {
+	if c.max <= 0 || c.order == nil {
+		return
+	}
+
+	for len(c.problems) > c.max && c.order.Len() > 0 {
+		el := c.order.Front()
+		id := el.Value.(uint64) //nolint:forcetypeassert // only problem IDs are stored
+
+		c.order.Remove(el)
+		delete(c.elems, id)
+		delete(c.problems, id)
+
+		c.evictions.Inc()
+		c.dirty = true
+
+		log.Debugf("forgetting open problem %d, more than %d problems are open", id, c.max)
+	}
+}
+
+func enrich(data string, e *event, p *problem) (string, error) {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(data), &fields)
+	if err != nil {
+		return "", errs.Wrap(err, "failed to unmarshal recovery event")
+	}
+
+	duration := float64(e.Clock-p.Clock) + float64(e.NS-p.NS)/1e9
+
+	fields["problem_duration"] = json.RawMessage(strconv.FormatFloat(duration, 'f', -1, 64))
+	fields["problem"] = p.Data
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return "", errs.Wrap(err, "failed to marshal recovery event")
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,335 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package correlation
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCorrelator_Correlate(t *testing.T) {
+	t.Parallel()
+
+	type fields struct {
+		problems map[uint64]problem
+	}
+
+	type args struct {
+		data string
+	}
+
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		want       map[string]any
+		wantUpdate *Update
+		wantOpen   int
+		wantErr    bool
+	}{
+		{
+			"+problem",
+			fields{map[uint64]problem{}},
+			args{`{"eventid":10,"value":1,"clock":100,"ns":0,"name":"High CPU"}`},
+			map[string]any{"eventid": 10.0, "value": 1.0, "clock": 100.0, "ns": 0.0, "name": "High CPU"},
+			&Update{Key: "10", Data: `{"eventid":10,"value":1,"clock":100,"ns":0,"name":"High CPU"}`},
+			1,
+			false,
+		},
+		{
+			"+recovery",
+			fields{
+				map[uint64]problem{
+					10: {100, 0, json.RawMessage(`{"eventid":10,"name":"High CPU"}`)},
+				},
+			},
+			args{`{"eventid":11,"p_eventid":10,"value":0,"clock":160,"ns":500000000}`},
+			map[string]any{
+				"eventid":          11.0,
+				"p_eventid":        10.0,
+				"value":            0.0,
+				"clock":            160.0,
+				"ns":               500000000.0,
+				"problem_duration": 60.5,
+				"problem":          map[string]any{"eventid": 10.0, "name": "High CPU"},
+			},
+			&Update{Key: "10"},
+			0,
+			false,
+		},
+		{
+			"+unknownProblem",
+			fields{map[uint64]problem{}},
+			args{`{"eventid":11,"p_eventid":10,"value":0,"clock":160,"ns":0}`},
+			map[string]any{"eventid": 11.0, "p_eventid": 10.0, "value": 0.0, "clock": 160.0, "ns": 0.0},
+			&Update{Key: "10"},
+			0,
+			false,
+		},
+		{
+			"+notCorrelated",
+			fields{map[uint64]problem{}},
+			args{`{"eventid":11,"value":0}`},
+			map[string]any{"eventid": 11.0, "value": 0.0},
+			nil,
+			0,
+			false,
+		},
+		{
+			"-malformed",
+			fields{map[uint64]problem{}},
+			args{`{"eventid":"abc"}`},
+			nil,
+			nil,
+			0,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &Correlator{problems: tt.fields.problems}
+
+			got, update, err := c.Correlate(tt.args.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Correlator.Correlate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if open := len(tt.fields.problems); c.Open() != open {
+				t.Fatalf("Correlator.Correlate() expected open problems unchanged before Apply, but got: %d", c.Open())
+			}
+
+			if update != nil {
+				c.Apply(update)
+			}
+
+			var gotFields map[string]any
+
+			err = json.Unmarshal([]byte(got), &gotFields)
+			if err != nil {
+				t.Fatalf("failed to unmarshal correlated event: %s", err.Error())
+			}
+
+			if diff := cmp.Diff(tt.want, gotFields); diff != "" {
+				t.Fatalf("Correlator.Correlate() = %s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantUpdate, update, cmpopts.IgnoreUnexported(Update{})); diff != "" {
+				t.Fatalf("Correlator.Correlate() update = %s", diff)
+			}
+
+			if c.Open() != tt.wantOpen {
+				t.Fatalf("Correlator.Correlate() expected %d open problems, but got: %d", tt.wantOpen, c.Open())
+			}
+		})
+	}
+}
+
+func TestCorrelator_Save(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "problems.json")
+
+	c, err := New(&Configuration{Enable: true, StateFile: file})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	_, update, err := c.Correlate(`{"eventid":10,"value":1,"clock":100,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Correlator.Correlate() unexpected error: %s", err.Error())
+	}
+
+	c.Apply(update)
+
+	err = c.Save()
+	if err != nil {
+		t.Fatalf("Correlator.Save() unexpected error: %s", err.Error())
+	}
+
+	loaded, err := New(&Configuration{Enable: true, StateFile: file})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	if loaded.Open() != 1 {
+		t.Fatalf("New() expected 1 open problem after reload, but got: %d", loaded.Open())
+	}
+
+	err = os.WriteFile(file, []byte("{malformed"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to prepare malformed state file: %s", err.Error())
+	}
+
+	_, err = New(&Configuration{Enable: true, StateFile: file})
+	if err == nil {
+		t.Fatalf("New() expected error for malformed state file")
+	}
+}
+
+func TestBatch_Correlate(t *testing.T) {
+	t.Parallel()
+
+	c, err := New(&Configuration{Enable: true})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	b := c.NewBatch()
+
+	_, problem, err := b.Correlate(`{"eventid":10,"value":1,"clock":100,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Batch.Correlate() unexpected error: %s", err.Error())
+	}
+
+	got, recovery, err := b.Correlate(`{"eventid":11,"p_eventid":10,"value":0,"clock":130,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Batch.Correlate() unexpected error: %s", err.Error())
+	}
+
+	var fields map[string]any
+
+	err = json.Unmarshal([]byte(got), &fields)
+	if err != nil {
+		t.Fatalf("failed to unmarshal correlated event: %s", err.Error())
+	}
+
+	if fields["problem_duration"] != 30.0 {
+		t.Fatalf("Batch.Correlate() expected recovery matching the problem of the batch, but got: %s", got)
+	}
+
+	_, _, err = b.Correlate(`{"eventid":12,"p_eventid":10,"value":0,"clock":140,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Batch.Correlate() unexpected error: %s", err.Error())
+	}
+
+	if c.Open() != 0 {
+		t.Fatalf("Batch.Correlate() expected open problems unchanged before Apply, but got: %d", c.Open())
+	}
+
+	c.Apply(problem)
+	c.Apply(recovery)
+
+	if c.Open() != 0 {
+		t.Fatalf("Correlator.Apply() expected problem resolved, but got: %d open problems", c.Open())
+	}
+}
+
+func TestCorrelator_evict(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "problems.json")
+
+	c, err := New(&Configuration{Enable: true, StateFile: file, MaxOpenProblems: 2})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	for _, data := range []string{
+		`{"eventid":1,"value":1,"clock":10,"ns":0}`,
+		`{"eventid":2,"value":1,"clock":20,"ns":0}`,
+		`{"eventid":3,"value":1,"clock":30,"ns":0}`,
+	} {
+		_, update, err := c.Correlate(data)
+		if err != nil {
+			t.Fatalf("Correlator.Correlate() unexpected error: %s", err.Error())
+		}
+
+		c.Apply(update)
+	}
+
+	if c.Open() != 2 {
+		t.Fatalf("Correlator.Apply() expected 2 open problems, but got: %d", c.Open())
+	}
+
+	got, _, err := c.Correlate(`{"eventid":4,"p_eventid":1,"value":0,"clock":40,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Correlator.Correlate() unexpected error: %s", err.Error())
+	}
+
+	if got != `{"eventid":4,"p_eventid":1,"value":0,"clock":40,"ns":0}` {
+		t.Fatalf("Correlator.Correlate() expected the oldest problem forgotten, but got: %s", got)
+	}
+
+	err = c.Save()
+	if err != nil {
+		t.Fatalf("Correlator.Save() unexpected error: %s", err.Error())
+	}
+
+	loaded, err := New(&Configuration{Enable: true, StateFile: file, MaxOpenProblems: 1})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	got, _, err = loaded.Correlate(`{"eventid":5,"p_eventid":3,"value":0,"clock":40,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Correlator.Correlate() unexpected error: %s", err.Error())
+	}
+
+	if loaded.Open() != 1 || got == `{"eventid":5,"p_eventid":3,"value":0,"clock":40,"ns":0}` {
+		t.Fatalf("New() expected the newest problem kept over the limit, but got: %s", got)
+	}
+}
+
+func TestCorrelator_StartSaving(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "problems.json")
+
+	c, err := New(&Configuration{Enable: true, StateFile: file})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	c.StartSaving(time.Hour)
+
+	_, update, err := c.Correlate(`{"eventid":10,"value":1,"clock":100,"ns":0}`)
+	if err != nil {
+		t.Fatalf("Correlator.Correlate() unexpected error: %s", err.Error())
+	}
+
+	c.Apply(update)
+
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("Correlator.Apply() expected state file saved only at the interval, but got: %v", err)
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatalf("Correlator.Close() unexpected error: %s", err.Error())
+	}
+
+	loaded, err := New(&Configuration{Enable: true, StateFile: file})
+	if err != nil {
+		t.Fatalf("New() unexpected error: %s", err.Error())
+	}
+
+	if loaded.Open() != 1 {
+		t.Fatalf("Correlator.Close() expected open problems saved, but got: %d", loaded.Open())
+	}
+}
@@ -6,6 +6,7 @@ require (
 	git.zabbix.com/ap/plugin-support v1.2.2-0.20240227101402-ef513f9a06bf
 	github.com/IBM/sarama v1.42.1
 	github.com/google/go-cmp v0.6.0
+	github.com/klauspost/compress v1.16.7
 )
 
 require (
@@ -22,7 +23,6 @@ require (
 	github.com/jcmturner/gofork v1.7.6 // indirect
 	github.com/jcmturner/gokrb5/v8 v8.4.4 // indirect
 	github.com/jcmturner/rpc/v2 v2.0.3 // indirect
-	github.com/klauspost/compress v1.16.7 // indirect
 	github.com/pierrec/lz4/v4 v4.1.18 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
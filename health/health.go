@@ -0,0 +1,97 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+// Package health collects the status of connector components for the health endpoint.
+package health
+
+import (
+	"sync"
+)
+
+// Component statuses, from the best to the worst.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+var defaultRegistry = &registry{checks: map[string]Check{}}
+
+// Check returns the current report of a component.
+type Check func() Report
+
+// Report is the status of a component with details describing it.
+type Report struct {
+	Status  string         `json:"status"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type registry struct {
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// Register registers the check of a component, replacing any previous one.
+func Register(name string, c Check) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	defaultRegistry.checks[name] = c
+}
+
+// Unregister removes the check of a component.
+func Unregister(name string) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	delete(defaultRegistry.checks, name)
+}
+
+// Snapshot returns the reports of all components and the worst of their statuses.
+func Snapshot() (string, map[string]Report) {
+	defaultRegistry.mu.Lock()
+
+	checks := make(map[string]Check, len(defaultRegistry.checks))
+	for name, c := range defaultRegistry.checks {
+		checks[name] = c
+	}
+
+	defaultRegistry.mu.Unlock()
+
+	// checks run without the lock, so they can take their own locks.
+	status := StatusOK
+	out := make(map[string]Report, len(checks))
+
+	for name, c := range checks {
+		r := c()
+		out[name] = r
+
+		if rank(r.Status) > rank(status) {
+			status = r.Status
+		}
+	}
+
+	return status, out
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusOK:
+		return 0
+	case StatusDegraded:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,60 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package health
+
+import (
+	"testing"
+)
+
+//nolint:paralleltest // uses the default registry
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     string
+	}{
+		{"+empty", nil, StatusOK},
+		{"+ok", []string{StatusOK, StatusOK}, StatusOK},
+		{"+degraded", []string{StatusOK, StatusDegraded}, StatusDegraded},
+		{"+down", []string{StatusDown, StatusDegraded, StatusOK}, StatusDown},
+		{"+unknownIsDown", []string{StatusOK, "broken"}, "broken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := []string{"a", "b", "c"}[:len(tt.statuses)]
+
+			for i, s := range tt.statuses {
+				s := s
+				Register(names[i], func() Report { return Report{Status: s} })
+			}
+
+			defer func() {
+				for _, n := range names {
+					Unregister(n)
+				}
+			}()
+
+			got, reports := Snapshot()
+			if got != tt.want {
+				t.Fatalf("Snapshot() expected status: %s, but got: %s", tt.want, got)
+			}
+
+			if len(reports) != len(tt.statuses) {
+				t.Fatalf("Snapshot() expected %d reports, but got: %d", len(tt.statuses), len(reports))
+			}
+		})
+	}
+}
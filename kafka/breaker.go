@@ -0,0 +1,213 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitOpenError is returned for records rejected while the circuit breaker of a cluster is open.
+type CircuitOpenError struct {
+	// RetryAfter is the time until the breaker lets probe records through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Kafka circuit breaker is open, retry in %s.", e.RetryAfter.Round(time.Second))
+}
+
+// breaker fails records fast while a cluster fails too many of them. It opens once the rate of failed
+// deliveries and input timeouts within a window reaches the threshold, lets a number of probe records through
+// after the open timeout and closes once all of them are acknowledged. A nil breaker lets every record through.
+type breaker struct {
+	mu          sync.Mutex
+	name        string
+	state       string
+	errorRate   int
+	minRecords  int
+	window      time.Duration
+	openTimeout time.Duration
+	probes      int
+	windowStart time.Time
+	total       int
+	failed      int
+	openedAt    time.Time
+	probesSent  int
+	probesOK    int
+	now         func() time.Time
+
+	rejected *metrics.Counter
+	opened   *metrics.Counter
+}
+
+// newBreaker returns the breaker of the cluster, nil if it is disabled by a zero error rate.
+func newBreaker(prefix string, c *Configuration) *breaker {
+	if c.BreakerErrorRate == 0 {
+		return nil
+	}
+
+	b := &breaker{
+		name:        prefix + "breaker",
+		state:       BreakerClosed,
+		errorRate:   c.BreakerErrorRate,
+		minRecords:  c.BreakerMinRecords,
+		window:      time.Duration(c.BreakerWindow) * time.Second,
+		openTimeout: time.Duration(c.BreakerOpenTimeout) * time.Second,
+		probes:      c.BreakerProbes,
+		now:         time.Now,
+		rejected:    metrics.GetCounter(prefix + "breaker.rejected"),
+		opened:      metrics.GetCounter(prefix + "breaker.opened"),
+	}
+
+	b.windowStart = b.now()
+
+	metrics.SetFunc(prefix+"breaker.state", func() any { return b.current() })
+	health.Register(b.name, b.health)
+
+	return b
+}
+
+// allow returns an error if the record must be rejected, a half-open breaker lets the probe records through.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		wait := b.openTimeout - b.now().Sub(b.openedAt)
+		if wait > 0 {
+			b.rejected.Inc()
+
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+
+		b.state = BreakerHalfOpen
+		b.probesSent, b.probesOK = 0, 0
+
+		log.Infof("%s is half-open, sending %d probe records", b.name, b.probes)
+
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probesSent >= b.probes {
+			b.rejected.Inc()
+
+			// the probes are in flight, their results decide when records are accepted again.
+			return &CircuitOpenError{RetryAfter: time.Second}
+		}
+
+		b.probesSent++
+	}
+
+	return nil
+}
+
+// record counts the outcome of a record, a failure is either a failed delivery or an input timeout.
+func (b *breaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		now := b.now()
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart, b.total, b.failed = now, 0, 0
+		}
+
+		b.total++
+
+		if !ok {
+			b.failed++
+		}
+
+		if b.total >= b.minRecords && b.failed*100 >= b.total*b.errorRate {
+			b.open(fmt.Sprintf("%d of %d records failed", b.failed, b.total))
+		}
+	case BreakerHalfOpen:
+		if !ok {
+			b.open("probe record failed")
+
+			return
+		}
+
+		b.probesOK++
+
+		if b.probesOK >= b.probes {
+			b.state = BreakerClosed
+			b.windowStart, b.total, b.failed = b.now(), 0, 0
+
+			log.Infof("%s is closed, all probe records were delivered", b.name)
+		}
+	case BreakerOpen:
+		// outcomes of records sent before the breaker opened are ignored.
+	}
+}
+
+func (b *breaker) open(reason string) {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.opened.Inc()
+
+	log.Warningf("%s is open for %s, %s", b.name, b.openTimeout, reason)
+}
+
+// current returns the current state of the breaker.
+func (b *breaker) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// close removes the breaker from the health output.
+func (b *breaker) close() {
+	if b == nil {
+		return
+	}
+
+	health.Unregister(b.name)
+}
+
+// health reports the breaker state, an open breaker degrades the connector as requests to the cluster fail fast.
+func (b *breaker) health() health.Report {
+	state := b.current()
+
+	r := health.Report{Status: health.StatusOK, Details: map[string]any{"state": state}}
+	if state != BreakerClosed {
+		r.Status = health.StatusDegraded
+	}
+
+	return r
+}
@@ -0,0 +1,184 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+)
+
+func Test_breaker(t *testing.T) {
+	t.Parallel()
+
+	const (
+		allow  = "allow"
+		reject = "reject"
+		ok     = "ok"
+		fail   = "fail"
+	)
+
+	type step struct {
+		advance   time.Duration
+		action    string
+		wantState string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			"+staysClosed",
+			[]step{
+				{0, ok, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, ok, BreakerClosed},
+				{0, ok, BreakerClosed},
+				{0, allow, BreakerClosed},
+			},
+		},
+		{
+			"+tooFewRecords",
+			[]step{
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, allow, BreakerClosed},
+			},
+		},
+		{
+			"+windowExpires",
+			[]step{
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{10 * time.Second, fail, BreakerClosed},
+				{0, allow, BreakerClosed},
+			},
+		},
+		{
+			"+opens",
+			[]step{
+				{0, ok, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, ok, BreakerClosed},
+				{0, fail, BreakerOpen},
+				{0, reject, BreakerOpen},
+				{29 * time.Second, reject, BreakerOpen},
+			},
+		},
+		{
+			"+closesAfterProbes",
+			[]step{
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerOpen},
+				{30 * time.Second, allow, BreakerHalfOpen},
+				{0, allow, BreakerHalfOpen},
+				{0, reject, BreakerHalfOpen},
+				{0, ok, BreakerHalfOpen},
+				{0, ok, BreakerClosed},
+				{0, allow, BreakerClosed},
+			},
+		},
+		{
+			"+reopensOnProbeFailure",
+			[]step{
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerOpen},
+				{30 * time.Second, allow, BreakerHalfOpen},
+				{0, fail, BreakerOpen},
+				{0, reject, BreakerOpen},
+			},
+		},
+		{
+			"+ignoresResultsWhileOpen",
+			[]step{
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerClosed},
+				{0, fail, BreakerOpen},
+				{0, ok, BreakerOpen},
+				{0, ok, BreakerOpen},
+				{0, reject, BreakerOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Unix(1700000000, 0)
+
+			b := &breaker{
+				name:        "test.breaker",
+				state:       BreakerClosed,
+				errorRate:   50,
+				minRecords:  4,
+				window:      10 * time.Second,
+				openTimeout: 30 * time.Second,
+				probes:      2,
+				windowStart: now,
+				now:         func() time.Time { return now },
+				rejected:    &metrics.Counter{},
+				opened:      &metrics.Counter{},
+			}
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+
+				switch s.action {
+				case allow, reject:
+					err := b.allow()
+
+					var openErr *CircuitOpenError
+					if (s.action == reject) != errors.As(err, &openErr) {
+						t.Fatalf("breaker.allow() step %d expected %s, but got error: %v", i, s.action, err)
+					}
+				case ok, fail:
+					b.record(s.action == ok)
+				}
+
+				if got := b.current(); got != s.wantState {
+					t.Fatalf("breaker step %d expected state: %s, but got: %s", i, s.wantState, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_breaker_nil(t *testing.T) {
+	t.Parallel()
+
+	b := newBreaker("test.", &Configuration{})
+	if b != nil {
+		t.Fatalf("newBreaker() expected no breaker for zero error rate")
+	}
+
+	b.record(false)
+	b.close()
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker.allow() unexpected error for disabled breaker: %s", err.Error())
+	}
+}
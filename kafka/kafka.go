@@ -15,12 +15,17 @@
 package kafka
 
 import (
-	"crypto/tls"
-	"time"
+	"context"
+	"errors"
+	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
 	"git.zabbix.com/ap/plugin-support/errs"
 	"git.zabbix.com/ap/plugin-support/log"
-	"git.zabbix.com/ap/plugin-support/tlsconfig"
 	"github.com/IBM/sarama"
 )
 
@@ -29,18 +34,61 @@ const (
 )
 
 // Producer defines requirements for Kafka producer.
+// Produce methods return an error if the record could not be queued before the context is done,
+// otherwise the delivery is resolved once the record is acknowledged or fails.
 type Producer interface {
-	ProduceItem(key, message string)
-	ProduceEvent(key, message string)
+	ProduceItem(ctx context.Context, r *Record) (*Delivery, error)
+	ProduceEvent(ctx context.Context, r *Record) (*Delivery, error)
+	ProduceProblem(ctx context.Context, r *Record) (*Delivery, error)
+	// QueueDepth returns the number of messages waiting to be sent to or acknowledged by Kafka.
+	QueueDepth() int
 	Close() error
 }
 
+// Counts are the numbers of messages by their outcome.
+type Counts struct {
+	Produced uint64 `json:"produced"`
+	Failed   uint64 `json:"failed"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// Header is a Kafka message header.
+type Header struct {
+	Key   string
+	Value string
+}
+
+// ErrClosed is returned when a record is produced after the producer is closed.
+var ErrClosed = errs.New("producer is closed")
+
 // DefaultProducer produces data to Kafka broker.
 type DefaultProducer struct {
-	eventsTopic string
-	itemsTopic  string
-	async       sarama.AsyncProducer
-	timeout     time.Duration
+	eventsTopic   string
+	itemsTopic    string
+	problemsTopic string
+	async         sarama.AsyncProducer
+	client        sarama.Client
+	tls           *tlsMaterial
+	breaker       *breaker
+	queued        atomic.Int64
+	name          string
+	prefix        string
+	spill         atomic.Pointer[SpillFunc]
+
+	// closeMu guards sending to the async producer against closing it.
+	closeMu sync.RWMutex
+	closed  bool
+
+	// listeners receive the results of the async producer until it is closed.
+	listeners sync.WaitGroup
+	failures  atomic.Uint64
+
+	// topicCounters holds the counters per topic, by topic name.
+	topicCounters sync.Map
+
+	produced *metrics.Counter
+	failed   *metrics.Counter
+	dropped  *metrics.Counter
 }
 
 // Configuration hold kafka configuration tags bases on Zabbix configuration package from plugin support.
@@ -48,6 +96,7 @@ type Configuration struct {
 	Brokers        string `conf:"default=localhost:9092"` // Comma-separated list
 	Events         string `conf:"default=events"`
 	Items          string `conf:"default=items"`
+	Problems       string `conf:"optional"`
 	KeepAlive      int    `conf:"range=60:300,default=300"`
 	Username       string `conf:"optional"`
 	Password       string `conf:"optional"`
@@ -58,133 +107,261 @@ type Configuration struct {
 	Timeout        int    `conf:"default=1"`
 	TLSAuth        bool   `conf:"default=false"`
 	EnableTLS      bool   `conf:"optional"`
-}
 
+	TLSServerName         string `conf:"optional"`
+	TLSInsecureSkipVerify bool   `conf:"default=false"`
+
+	// Delivery is the delivery policy of the cluster when records are sent to several clusters.
+	Delivery string `conf:"default=all"`
+
+	// BreakerErrorRate is the percentage of failed records that opens the circuit breaker, 0 disables it.
+	BreakerErrorRate   int `conf:"range=0:100,default=0"`
+	BreakerMinRecords  int `conf:"range=1:1000000,default=20"`
+	BreakerWindow      int `conf:"range=1:3600,default=10"`
+	BreakerOpenTimeout int `conf:"range=1:3600,default=30"`
+	BreakerProbes      int `conf:"range=1:1000,default=5"`
+
+	// Provision is the mode of provisioning the configured topics from their declarations.
+	Provision string `conf:"default=off"`
+
+	// TopicCheck is the mode of the startup check of the configured topics.
+	TopicCheck         string `conf:"default=warn"`
+	TopicCheckInterval int    `conf:"range=1:3600,default=10"`
+}
 
 // ProduceItem produces Kafka message to the item topic
 // in the broker provided in the async producer.
-func (p *DefaultProducer) ProduceItem(key, message string) {
-	m := &sarama.ProducerMessage{
-		Topic: p.itemsTopic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.StringEncoder(message),
-	}
-
-	p.produce(m)
+func (p *DefaultProducer) ProduceItem(ctx context.Context, r *Record) (*Delivery, error) {
+	return p.produce(ctx, newMessage(p.itemsTopic, r))
 }
 
 // ProduceEvent produces Kafka message to the event topic
 // in the broker provided in the async producer.
-func (p *DefaultProducer) ProduceEvent(key, message string) {
-	m := &sarama.ProducerMessage{
-		Topic: p.eventsTopic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.StringEncoder(message),
+func (p *DefaultProducer) ProduceEvent(ctx context.Context, r *Record) (*Delivery, error) {
+	return p.produce(ctx, newMessage(p.eventsTopic, r))
+}
+
+// ProduceProblem produces Kafka message to the current problems state topic.
+// Nothing is produced if neither the topic is configured nor the record overrides it.
+func (p *DefaultProducer) ProduceProblem(ctx context.Context, r *Record) (*Delivery, error) {
+	if p.problemsTopic == "" && r.Topic == "" {
+		return Delivered(nil), nil
 	}
 
-	p.produce(m)
+	return p.produce(ctx, newMessage(p.problemsTopic, r))
 }
 
-// Close closes the underlying async producer.
+// QueueDepth returns the number of messages waiting to be sent to or acknowledged by Kafka.
+func (p *DefaultProducer) QueueDepth() int {
+	return int(p.queued.Load())
+}
+
+// Close closes the underlying async producer and its client, records are no longer accepted once it is called.
+// Messages still buffered are sent, the ones that fail are spilled if a spill function is set.
 func (p *DefaultProducer) Close() error {
-	err := p.async.Close()
+	p.closeMu.Lock()
+	p.closed = true
+	p.closeMu.Unlock()
+
+	p.breaker.close()
+
+	failures := p.failures.Load()
+
+	// Close of the async producer drains the successes itself, so the listeners are left to receive the remaining
+	// results, resolving every delivery and lowering the queue depth, until the producer closes its channels.
+	p.async.AsyncClose()
+	p.listeners.Wait()
+
+	var errsList []error
+
+	if n := p.failures.Load() - failures; n > 0 {
+		errsList = append(errsList, errs.Errorf("failed to deliver %d messages while closing Kafka async producer", n))
+	}
+
+	if p.client != nil {
+		err := p.client.Close()
+		if err != nil {
+			errsList = append(errsList, errs.Wrap(err, "failed to close Kafka client"))
+		}
+	}
+
+	return errors.Join(errsList...)
+}
+
+// Ping refreshes the metadata of the producer topics, it fails if no broker is reachable.
+func (p *DefaultProducer) Ping() error {
+	if p.client == nil {
+		return nil
+	}
+
+	err := p.client.RefreshMetadata(p.topics()...)
 	if err != nil {
-		return errs.Wrap(err, "failed to close Kafka async producer")
+		return errs.Wrap(err, "failed to refresh metadata")
 	}
 
 	return nil
 }
 
+// topics returns the configured topics of the producer.
+func (p *DefaultProducer) topics() []string {
+	var topics []string
+
+	for _, t := range []string{p.eventsTopic, p.itemsTopic, p.problemsTopic} {
+		if t != "" {
+			topics = append(topics, t)
+		}
+	}
+
+	return topics
+}
+
+// Counts returns the number of messages acknowledged, failed and dropped since the producer metrics were created.
+func (p *DefaultProducer) Counts() Counts {
+	return Counts{Produced: p.produced.Value(), Failed: p.failed.Value(), Dropped: p.dropped.Value()}
+}
+
 // NewProducer creates Kafka producers from with provided configuration.
-func NewProducer(c *Configuration) (*DefaultProducer, error) {
+// The name of the cluster is part of the producer metric names, it is empty for the default cluster.
+// Topics are provisioned from the declarations keyed by the kind of the topic before they are verified,
+// retrying the verification stops once the context is done.
+func NewProducer(
+	ctx context.Context, name string, c *Configuration, topics map[string]TopicConfiguration,
+) (*DefaultProducer, error) {
 	brokers := strings.Split(c.Brokers, ",")
 	for i := range brokers {
 		brokers[i] = strings.TrimSpace(brokers[i])
 	}
 
-	var tlsConfig *tls.Config
-	var err error
-
-	if c.TLSAuth {
-		// Just use the first broker to generate the TLS config
-		tlsConfig, err = getTLSConf(brokers[0], c.CaFile, c.ClientCertFile, c.ClientKeyFile)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed get TLS config")
-		}
+	material, err := newTLSMaterial(c)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed get TLS config")
 	}
 
 	kconf := newConfig(
 		c.Username,
 		c.Password,
 		c.Retry,
-		c.TLSAuth,
-		c.EnableTLS,
 		time.Duration(c.Timeout)*time.Second,
 		time.Duration(c.KeepAlive)*time.Second,
-		tlsConfig,
+		material,
 	)
 
 	producer, err := newProducer(
 		kconf,
+		metricsPrefix(name),
 		brokers,
 		c.Events,
 		c.Items,
+		c.Problems,
 	)
 	if err != nil {
 		return nil, errs.Wrap(err, "failed to create new kafka producer")
 	}
 
+	err = producer.provision(c.Provision, topics)
+	if err != nil {
+		producer.Close() //nolint:errcheck // the provisioning error is returned
+
+		return nil, errs.Wrap(err, "failed to provision kafka topics")
+	}
+
+	// the check connects with a configuration of its own, as it changes the Kafka version.
+	cconf := newConfig(
+		c.Username,
+		c.Password,
+		c.Retry,
+		time.Duration(c.Timeout)*time.Second,
+		time.Duration(c.KeepAlive)*time.Second,
+		material,
+	)
+
+	err = verifyTopics(ctx, c.TopicCheck, time.Duration(c.TopicCheckInterval)*time.Second, func() error {
+		return producer.checkTopics(cconf)
+	})
+	if err != nil {
+		producer.Close() //nolint:errcheck // the verification error is returned
+
+		return nil, errs.Wrap(err, "failed to verify kafka topics")
+	}
+
+	producer.tls = material
+	producer.name = name
+	producer.breaker = newBreaker(metricsPrefix(name), c)
+
 	return producer, nil
 }
 
+// TLSFiles returns the certificate files used for broker connections, empty if TLS authentication is off.
+func (p *DefaultProducer) TLSFiles() []string {
+	if p.tls == nil {
+		return nil
+	}
+
+	return p.tls.files()
+}
 
-func getTLSConf(url, caFile, certFile, keyFile string) (*tls.Config, error) {
-	d := tlsconfig.Details{
-		RawUri:      url,
-		TlsCaFile:   caFile,
-		TlsCertFile: certFile,
-		TlsKeyFile:  keyFile,
+// ReloadTLS reads the certificate files again, new broker connections use the reloaded certificates.
+func (p *DefaultProducer) ReloadTLS() error {
+	if p.tls == nil {
+		return nil
 	}
 
-	tlsConfig, err := d.GetTLSConfig(false)
-	if err != nil {
-		return nil, errs.Wrap(err, "failed to create TLS config")
+	return p.tls.reload()
+}
+
+// metricsPrefix returns the prefix of the producer metric names of the cluster.
+func metricsPrefix(name string) string {
+	if name == "" {
+		return "kafka."
 	}
 
-	return tlsConfig, nil
+	return "kafka." + name + "."
 }
 
 // newProducer returns a new producer initialized
 // and ready to produce messages to Kafka.
-func newProducer(config *sarama.Config, brokers []string, eventsTopic, itemsTopic string) (*DefaultProducer, error) {
-	p, err := sarama.NewAsyncProducer(brokers, config)
+func newProducer(
+	config *sarama.Config, prefix string, brokers []string, eventsTopic, itemsTopic, problemsTopic string,
+) (*DefaultProducer, error) {
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, errs.Wrap(err, "client init failed")
+	}
+
+	p, err := sarama.NewAsyncProducerFromClient(client)
 	if err != nil {
+		client.Close() //nolint:errcheck // the producer error is returned
+
 		return nil, errs.Wrap(err, "async producer init failed")
 	}
 
 	prod := &DefaultProducer{
-		async:       p,
-		eventsTopic: eventsTopic,
-		itemsTopic:  itemsTopic,
-		timeout:     3 * time.Second,
+		async:         p,
+		client:        client,
+		eventsTopic:   eventsTopic,
+		itemsTopic:    itemsTopic,
+		problemsTopic: problemsTopic,
+		produced:      metrics.GetCounter(prefix + "messages.produced"),
+		failed:        metrics.GetCounter(prefix + "messages.failed"),
+		dropped:       metrics.GetCounter(prefix + "messages.dropped"),
+		prefix:        prefix,
 	}
 
-	go prod.errorListener()
+	metrics.SetFunc(prefix+"queue.depth", func() any { return prod.QueueDepth() })
+
+	prod.listen()
 
 	return prod, nil
 }
 
-
 //nolint:revive // configuration requires a lot of parameters
 func newConfig(
 	username,
 	password string,
 	retries int,
-	tlsAuth,
-	enableTLS bool,
 	timeout,
 	keepAlive time.Duration,
-	tlsConf *tls.Config,
+	material *tlsMaterial,
 ) *sarama.Config {
 	config := sarama.NewConfig()
 	config.ClientID = clientID
@@ -193,7 +370,8 @@ func newConfig(
 	config.Net.ReadTimeout = timeout
 	config.Net.WriteTimeout = timeout
 	config.Producer.Retry.Max = retries
-	config.Net.TLS.Enable = enableTLS
+	// successes are counted to track the number of messages waiting for delivery.
+	config.Producer.Return.Successes = true
 	config.Metadata.AllowAutoTopicCreation = false
 
 	if username != "" {
@@ -202,29 +380,145 @@ func newConfig(
 		config.Net.SASL.Password = password
 	}
 
-	if tlsAuth {
-		config.Net.TLS.Enable = tlsAuth
-		config.Net.TLS.Config = tlsConf
+	// TLS is done by the dialer, so Sarama's own TLS stays disabled.
+	if material != nil {
+		config.Net.Proxy.Enable = true
+		config.Net.Proxy.Dialer = &tlsDialer{
+			dialer:   &net.Dialer{Timeout: timeout, KeepAlive: keepAlive},
+			material: material,
+		}
 	}
 
 	return config
 }
 
+// newMessage creates a producer message, messages with an empty key
+// are left without one so the partitioner spreads them randomly.
+func newMessage(topic string, r *Record) *sarama.ProducerMessage {
+	m := &sarama.ProducerMessage{
+		Topic:     topic,
+		Timestamp: r.Timestamp,
+	}
+
+	if r.Topic != "" {
+		m.Topic = r.Topic
+	}
+
+	if r.Value != nil {
+		m.Value = sarama.ByteEncoder(r.Value)
+	}
+
+	if r.Key != "" {
+		m.Key = sarama.StringEncoder(r.Key)
+	}
+
+	for _, h := range r.Headers {
+		m.Headers = append(m.Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: []byte(h.Value)})
+	}
+
+	return m
+}
+
+// listen starts receiving the results of the async producer.
+func (p *DefaultProducer) listen() {
+	p.listeners.Add(2)
+
+	go func() {
+		defer p.listeners.Done()
+
+		p.errorListener()
+	}()
+
+	go func() {
+		defer p.listeners.Done()
+
+		p.successListener()
+	}()
+}
+
 func (p *DefaultProducer) errorListener() {
 	for perr := range p.async.Errors() {
-		log.Errf(
-			"kafka producer error: %s, for topic %s, with key %s", perr.Err.Error(), perr.Msg.Topic, perr.Msg.Key)
+		p.fail(perr)
+	}
+}
+
+func (p *DefaultProducer) fail(perr *sarama.ProducerError) {
+	p.failed.Inc()
+	p.failures.Add(1)
+	p.topic(perr.Msg.Topic).failed.Inc()
+	p.queued.Add(-1)
+	p.breaker.record(false)
+
+	if d, ok := perr.Msg.Metadata.(*Delivery); ok {
+		d.Resolve(perr.Err)
+	}
+
+	log.Errf(
+		"kafka producer error: %s, for topic %s, with key %s", perr.Err.Error(), perr.Msg.Topic, perr.Msg.Key)
+
+	p.spillMessage(perr.Msg)
+}
+
+func (p *DefaultProducer) successListener() {
+	for m := range p.async.Successes() {
+		p.produced.Inc()
+		p.topic(m.Topic).produced.Inc()
+		p.queued.Add(-1)
+		p.breaker.record(true)
+
+		if d, ok := m.Metadata.(*Delivery); ok {
+			d.Resolve(nil)
+		}
 	}
 }
 
-func (p *DefaultProducer) produce(m *sarama.ProducerMessage) {
-	ticker := time.NewTicker(p.timeout)
-	defer ticker.Stop()
+func (p *DefaultProducer) produce(ctx context.Context, m *sarama.ProducerMessage) (*Delivery, error) {
+	// a done context must not race with a free input channel.
+	err := ctx.Err()
+	if err != nil {
+		p.dropped.Inc()
+		p.topic(m.Topic).dropped.Inc()
+
+		return nil, errs.Wrapf(err, "message send canceled for id: %s", m.Key)
+	}
+
+	// the input channel is closed by Close, so sending is guarded until the message is taken.
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+
+	if p.closed {
+		p.dropped.Inc()
+		p.topic(m.Topic).dropped.Inc()
+
+		return nil, errs.Wrapf(ErrClosed, "message rejected for id: %s", m.Key)
+	}
+
+	err = p.breaker.allow()
+	if err != nil {
+		p.dropped.Inc()
+		p.topic(m.Topic).dropped.Inc()
+
+		return nil, errs.Wrapf(err, "message rejected for id: %s", m.Key)
+	}
+
+	d := NewDelivery()
+	m.Metadata = d
+
+	// counted before sending, so the acknowledgement can never be counted first.
+	p.queued.Add(1)
 
 	select {
 	case p.async.Input() <- m:
 		log.Debugf("new message produced with id: %s", m.Key)
-	case <-ticker.C:
-		log.Warningf("message send timeout for id: %s", m.Key)
+
+		return d, nil
+	case <-ctx.Done():
+		p.queued.Add(-1)
+		p.dropped.Inc()
+		p.topic(m.Topic).dropped.Inc()
+		// the producer did not take the message in time, which counts as a failure of the cluster.
+		p.breaker.record(false)
+
+		return nil, errs.Wrapf(ctx.Err(), "message send canceled for id: %s", m.Key)
 	}
 }
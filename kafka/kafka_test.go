@@ -15,9 +15,15 @@
 package kafka
 
 import (
-	"crypto/tls"
+	"context"
+	"errors"
 	"testing"
 	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/google/go-cmp/cmp"
 )
 
 //nolint:gocognit,gocyclo,cyclop // requires a lot of config field checks
@@ -28,11 +34,9 @@ func Test_newConfig(t *testing.T) {
 		username  string
 		password  string
 		retries   int
-		tlsAuth   bool
-		enableTLS bool
 		timeout   time.Duration
 		keepAlive time.Duration
-		tlsConf   *tls.Config
+		material  *tlsMaterial
 	}
 
 	tests := []struct {
@@ -49,8 +53,7 @@ func Test_newConfig(t *testing.T) {
 		wantSASLEnable             bool
 		wantTLSEnable              bool
 		wantAllowAutoTopicCreation bool
-		wantTlsConfNotNil          bool
-		wantTlsAuthEnable          bool
+		wantTLSDialer              bool
 	}{
 		{
 			"+valid",
@@ -58,8 +61,6 @@ func Test_newConfig(t *testing.T) {
 				"",
 				"",
 				2,
-				false,
-				false,
 				3,
 				30,
 				nil,
@@ -76,7 +77,6 @@ func Test_newConfig(t *testing.T) {
 			false,
 			false,
 			false,
-			false,
 		},
 		{
 			"+SASL",
@@ -84,8 +84,6 @@ func Test_newConfig(t *testing.T) {
 				"username",
 				"password",
 				2,
-				false,
-				false,
 				3,
 				30,
 				nil,
@@ -102,7 +100,6 @@ func Test_newConfig(t *testing.T) {
 			false,
 			false,
 			false,
-			false,
 		},
 		{
 			"+TLS",
@@ -110,11 +107,9 @@ func Test_newConfig(t *testing.T) {
 				"",
 				"",
 				2,
-				true,
-				false,
 				3,
 				30,
-				&tls.Config{ServerName: "127.0.0.1"}, //nolint:gosec // struct fields are not used
+				&tlsMaterial{serverName: "127.0.0.1"},
 			},
 			"zabbix",
 			"",
@@ -125,9 +120,8 @@ func Test_newConfig(t *testing.T) {
 			3,
 			2,
 			false,
-			true,
 			false,
-			true,
+			false,
 			true,
 		},
 		{
@@ -136,11 +130,9 @@ func Test_newConfig(t *testing.T) {
 				"foo",
 				"bar",
 				2,
-				true,
-				true,
 				3,
 				30,
-				&tls.Config{ServerName: "127.0.0.1"}, //nolint:gosec // struct fields are not used
+				&tlsMaterial{serverName: "127.0.0.1"},
 			},
 			"zabbix",
 			"foo",
@@ -151,9 +143,8 @@ func Test_newConfig(t *testing.T) {
 			3,
 			2,
 			true,
-			true,
 			false,
-			true,
+			false,
 			true,
 		},
 	}
@@ -167,11 +158,9 @@ func Test_newConfig(t *testing.T) {
 				tt.args.username,
 				tt.args.password,
 				tt.args.retries,
-				tt.args.tlsAuth,
-				tt.args.enableTLS,
 				tt.args.timeout,
 				tt.args.keepAlive,
-				tt.args.tlsConf,
+				tt.args.material,
 			)
 
 			if tt.wantClientID != got.ClientID {
@@ -190,21 +179,17 @@ func Test_newConfig(t *testing.T) {
 				)
 			}
 
-			if (tt.wantTlsConfNotNil && got.Net.TLS.Config == nil) ||
-				(!tt.wantTlsConfNotNil && got.Net.TLS.Config != nil) {
+			_, isTLSDialer := got.Net.Proxy.Dialer.(*tlsDialer)
+			if tt.wantTLSDialer != (got.Net.Proxy.Enable && isTLSDialer) {
 				t.Fatalf(
-					"newConfig() expected TLS config to be set: %t, but got: '%v'",
-					tt.wantTlsConfNotNil,
-					got.Net.TLS.Config,
+					"newConfig() expected TLS dialer to be set: %t, but got: '%v'",
+					tt.wantTLSDialer,
+					got.Net.Proxy.Dialer,
 				)
 			}
 
-			if tt.wantTlsAuthEnable != got.Net.TLS.Enable {
-				t.Fatalf(
-					"newConfig() expected TLS auth: '%t', but got: '%t'",
-					tt.wantTlsAuthEnable,
-					got.Net.TLS.Enable,
-				)
+			if got.Net.TLS.Enable || got.Net.TLS.Config != nil {
+				t.Fatalf("newConfig() expected Sarama TLS to be disabled, TLS is done by the dialer")
 			}
 
 			if tt.wantKeepAlive != got.Net.KeepAlive {
@@ -266,3 +251,314 @@ func Test_newConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_newMessage(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name          string
+		record        Record
+		wantTopic     string
+		wantKeyNil    bool
+		wantValueNil  bool
+		wantHeaders   int
+		wantTimestamp time.Time
+	}{
+		{"+withKey", Record{Key: "42", Value: []byte("message")}, "topic", false, false, 0, time.Time{}},
+		{"+emptyKey", Record{Value: []byte("message")}, "topic", true, false, 0, time.Time{}},
+		{
+			"+headers",
+			Record{Key: "42", Value: []byte("message"), Headers: []Header{{"zabbix-client", "foo"}}},
+			"topic", false, false, 1, time.Time{},
+		},
+		{"+tombstone", Record{Key: "42"}, "topic", false, true, 0, time.Time{}},
+		{
+			"+topicOverride",
+			Record{Key: "42", Value: []byte("message"), Topic: "other"},
+			"other", false, false, 0, time.Time{},
+		},
+		{"+timestamp", Record{Key: "42", Value: []byte("message"), Timestamp: ts}, "topic", false, false, 0, ts},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := newMessage("topic", &tt.record)
+			if got.Topic != tt.wantTopic {
+				t.Fatalf("newMessage() expected topic: '%s', but got: '%s'", tt.wantTopic, got.Topic)
+			}
+
+			if (got.Key == nil) != tt.wantKeyNil {
+				t.Fatalf("newMessage() expected nil key: %t, but got: '%v'", tt.wantKeyNil, got.Key)
+			}
+
+			if (got.Value == nil) != tt.wantValueNil {
+				t.Fatalf("newMessage() expected nil value: %t, but got: '%v'", tt.wantValueNil, got.Value)
+			}
+
+			if len(got.Headers) != tt.wantHeaders {
+				t.Fatalf("newMessage() expected %d headers, but got: %d", tt.wantHeaders, len(got.Headers))
+			}
+
+			if !got.Timestamp.Equal(tt.wantTimestamp) {
+				t.Fatalf("newMessage() expected timestamp: %s, but got: %s", tt.wantTimestamp, got.Timestamp)
+			}
+		})
+	}
+}
+
+func TestDefaultProducer_QueueDepth(t *testing.T) {
+	t.Parallel()
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	async := mocks.NewAsyncProducer(t, config)
+	async.ExpectInputAndSucceed()
+	async.ExpectInputAndFail(errors.New("fail"))
+
+	p := &DefaultProducer{
+		async:    async,
+		produced: metrics.GetCounter("test.queue.produced"),
+		failed:   metrics.GetCounter("test.queue.failed"),
+		dropped:  metrics.GetCounter("test.queue.dropped"),
+	}
+
+	produced, failed := p.produced.Value(), p.failed.Value()
+
+	p.listen()
+
+	var deliveries []*Delivery
+
+	for _, v := range []string{"a", "b"} {
+		d, err := p.ProduceItem(context.Background(), &Record{Value: []byte(v), Topic: "a"})
+		if err != nil {
+			t.Fatalf("DefaultProducer.ProduceItem() unexpected error: %s", err.Error())
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := deliveries[0].Wait(ctx); err != nil {
+		t.Fatalf("Delivery.Wait() expected acknowledged delivery, but got: %s", err.Error())
+	}
+
+	if err := deliveries[1].Wait(ctx); err == nil {
+		t.Fatalf("Delivery.Wait() expected failed delivery")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.QueueDepth() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("DefaultProducer.QueueDepth() expected 0 after acknowledgements, but got: %d", p.QueueDepth())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// listeners count the delivery before lowering the depth, so both counters are up to date here.
+	if p.produced.Value()-produced != 1 || p.failed.Value()-failed != 1 {
+		t.Fatalf(
+			"DefaultProducer expected 1 produced and 1 failed message, but got: %d and %d",
+			p.produced.Value()-produced, p.failed.Value()-failed,
+		)
+	}
+
+	err := async.Close()
+	if err != nil {
+		t.Fatalf("failed to close mock producer: %s", err.Error())
+	}
+}
+
+func TestDefaultProducer_produce(t *testing.T) {
+	t.Parallel()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			"-canceled",
+			func() (context.Context, context.CancelFunc) { return canceled, func() {} },
+			context.Canceled,
+		},
+		{
+			"-deadline",
+			func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &DefaultProducer{
+				async:    &blockedProducer{input: make(chan *sarama.ProducerMessage)},
+				produced: metrics.GetCounter("test.produce.produced"),
+				failed:   metrics.GetCounter("test.produce.failed"),
+				dropped:  metrics.GetCounter("test.produce.dropped"),
+			}
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			_, err := p.produce(ctx, &sarama.ProducerMessage{Topic: "a", Value: sarama.StringEncoder("a")})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DefaultProducer.produce() expected error: %v, but got: %v", tt.wantErr, err)
+			}
+
+			if p.QueueDepth() != 0 {
+				t.Fatalf("DefaultProducer.produce() expected dropped message not to be queued, but got: %d", p.QueueDepth())
+			}
+		})
+	}
+}
+
+// blockedProducer is an async producer with an input channel that is never read.
+type blockedProducer struct {
+	sarama.AsyncProducer
+	input chan *sarama.ProducerMessage
+}
+
+func (b *blockedProducer) Input() chan<- *sarama.ProducerMessage {
+	return b.input
+}
+
+func TestDefaultProducer_Close(t *testing.T) {
+	t.Parallel()
+
+	p := &DefaultProducer{
+		async:    mocks.NewAsyncProducer(t, sarama.NewConfig()),
+		produced: metrics.GetCounter("test.close.produced"),
+		failed:   metrics.GetCounter("test.close.failed"),
+		dropped:  metrics.GetCounter("test.close.dropped"),
+	}
+
+	err := p.Close()
+	if err != nil {
+		t.Fatalf("DefaultProducer.Close() unexpected error: %s", err.Error())
+	}
+
+	_, err = p.ProduceItem(context.Background(), &Record{Value: []byte("a"), Topic: "a"})
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("DefaultProducer.ProduceItem() expected error: %v, but got: %v", ErrClosed, err)
+	}
+}
+
+func TestDefaultProducer_Close_resolvesDeliveries(t *testing.T) {
+	t.Parallel()
+
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	async := mocks.NewAsyncProducer(t, config)
+	async.ExpectInputAndSucceed()
+	async.ExpectInputAndFail(errors.New("fail"))
+
+	p := &DefaultProducer{
+		async:    async,
+		produced: metrics.GetCounter("test.closeResolve.produced"),
+		failed:   metrics.GetCounter("test.closeResolve.failed"),
+		dropped:  metrics.GetCounter("test.closeResolve.dropped"),
+	}
+
+	p.listen()
+
+	var deliveries []*Delivery
+
+	for _, v := range []string{"a", "b"} {
+		d, err := p.ProduceItem(context.Background(), &Record{Value: []byte(v), Topic: "a"})
+		if err != nil {
+			t.Fatalf("DefaultProducer.ProduceItem() unexpected error: %s", err.Error())
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	err := p.Close()
+	if err == nil {
+		t.Fatalf("DefaultProducer.Close() expected error for the failed message")
+	}
+
+	for i, d := range deliveries {
+		select {
+		case <-d.Done():
+		default:
+			t.Fatalf("DefaultProducer.Close() expected delivery %d to be resolved", i)
+		}
+	}
+
+	if deliveries[0].Err() != nil || deliveries[1].Err() == nil {
+		t.Fatalf("DefaultProducer.Close() expected one acknowledged and one failed delivery")
+	}
+
+	if p.QueueDepth() != 0 {
+		t.Fatalf("DefaultProducer.Close() expected empty queue, but got: %d", p.QueueDepth())
+	}
+}
+
+func TestDefaultProducer_SetSpill(t *testing.T) {
+	t.Parallel()
+
+	async := mocks.NewAsyncProducer(t, sarama.NewConfig())
+	async.ExpectInputAndFail(errors.New("fail"))
+
+	p := &DefaultProducer{
+		itemsTopic: "zabbix-items",
+		async:      async,
+		produced:   metrics.GetCounter("test.spill.produced"),
+		failed:     metrics.GetCounter("test.spill.failed"),
+		dropped:    metrics.GetCounter("test.spill.dropped"),
+	}
+
+	type spilled struct {
+		kind   string
+		record *Record
+	}
+
+	out := make(chan spilled, 1)
+	p.SetSpill(func(kind string, r *Record) { out <- spilled{kind, r} })
+
+	p.listen()
+
+	_, err := p.ProduceItem(
+		context.Background(), &Record{Key: "1", Value: []byte(`{"itemid":1}`), Headers: []Header{{"a", "b"}}},
+	)
+	if err != nil {
+		t.Fatalf("DefaultProducer.ProduceItem() unexpected error: %s", err.Error())
+	}
+
+	select {
+	case s := <-out:
+		want := &Record{Key: "1", Value: []byte(`{"itemid":1}`), Headers: []Header{{"a", "b"}}, Topic: "zabbix-items"}
+		if s.kind != SpillItems {
+			t.Fatalf("DefaultProducer spill expected kind: %s, but got: %s", SpillItems, s.kind)
+		}
+
+		if diff := cmp.Diff(want, s.record); diff != "" {
+			t.Fatalf("DefaultProducer spill record = %s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("DefaultProducer expected failed record to be spilled")
+	}
+
+	err = async.Close()
+	if err != nil {
+		t.Fatalf("failed to close mock producer: %s", err.Error())
+	}
+}
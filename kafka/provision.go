@@ -0,0 +1,295 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+	"github.com/IBM/sarama"
+)
+
+// Topic provisioning modes.
+const (
+	ProvisionOff    = "off"
+	ProvisionCreate = "create"
+	ProvisionAlter  = "alter"
+)
+
+// TopicConfiguration declares a topic for provisioning, empty configs are left to the broker defaults.
+type TopicConfiguration struct {
+	Partitions        int    `conf:"range=1:100000,default=1"`
+	ReplicationFactor int    `conf:"range=1:32767,default=1"`
+	RetentionMs       string `conf:"optional"`
+	CleanupPolicy     string `conf:"optional"`
+	CompressionType   string `conf:"optional"`
+}
+
+// topicAdmin is the part of sarama.ClusterAdmin used for provisioning.
+type topicAdmin interface {
+	DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error
+	DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error)
+	AlterConfig(
+		resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool,
+	) error
+}
+
+// configs returns the declared topic configs.
+func (t *TopicConfiguration) configs() map[string]string {
+	out := map[string]string{}
+
+	for name, value := range map[string]string{
+		"retention.ms":     t.RetentionMs,
+		"cleanup.policy":   t.CleanupPolicy,
+		"compression.type": t.CompressionType,
+	} {
+		if value != "" {
+			out[name] = value
+		}
+	}
+
+	return out
+}
+
+// provision creates the declared topics of the producer, the declarations are keyed by the kind of the topic:
+// events, items or problems.
+func (p *DefaultProducer) provision(mode string, decls map[string]TopicConfiguration) error {
+	if mode == ProvisionOff {
+		return nil
+	}
+
+	// the admin is not closed, as closing it closes the client of the producer.
+	admin, err := sarama.NewClusterAdminFromClient(p.client)
+	if err != nil {
+		return errs.Wrap(err, "failed to create cluster admin")
+	}
+
+	return provisionTopics(
+		admin,
+		mode,
+		map[string]string{"events": p.eventsTopic, "items": p.itemsTopic, "problems": p.problemsTopic},
+		decls,
+	)
+}
+
+// provisionTopics creates missing topics and reports how existing topics differ from their declarations,
+// in the alter mode existing topics are changed to match them where Kafka allows it.
+func provisionTopics(
+	admin topicAdmin, mode string, topics map[string]string, decls map[string]TopicConfiguration,
+) error {
+	if mode != ProvisionCreate && mode != ProvisionAlter {
+		return errs.Errorf("unknown provisioning mode %q", mode)
+	}
+
+	kinds := make([]string, 0, len(decls))
+	for kind := range decls {
+		if _, ok := topics[kind]; !ok {
+			return errs.Errorf("unknown topic kind %q, expected events, items or problems", kind)
+		}
+
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		name := topics[kind]
+		if name == "" {
+			continue
+		}
+
+		decl := decls[kind]
+
+		err := provisionTopic(admin, mode == ProvisionAlter, name, &decl)
+		if err != nil {
+			return errs.Wrapf(err, "failed to provision topic %s", name)
+		}
+	}
+
+	return nil
+}
+
+func provisionTopic(admin topicAdmin, alter bool, name string, decl *TopicConfiguration) error {
+	meta, err := admin.DescribeTopics([]string{name})
+	if err != nil {
+		return errs.Wrap(err, "failed to describe topic")
+	}
+
+	if len(meta) != 1 {
+		return errs.Errorf("expected metadata of one topic, got %d", len(meta))
+	}
+
+	switch meta[0].Err {
+	case sarama.ErrNoError:
+	case sarama.ErrUnknownTopicOrPartition:
+		return createTopic(admin, name, decl)
+	default:
+		return errs.Wrap(meta[0].Err, "failed to describe topic")
+	}
+
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: name})
+	if err != nil {
+		return errs.Wrap(err, "failed to describe topic configs")
+	}
+
+	actual := map[string]string{}
+	// overrides are the configs set on the topic itself, the set is replaced as a whole when altered.
+	overrides := map[string]*string{}
+
+	for _, e := range entries {
+		e := e
+		actual[e.Name] = e.Value
+
+		if e.Source == sarama.SourceTopic {
+			overrides[e.Name] = &e.Value
+		}
+	}
+
+	partitions := int32(len(meta[0].Partitions))
+
+	var replication int
+	if partitions > 0 {
+		replication = len(meta[0].Partitions[0].Replicas)
+	}
+
+	var drift []string
+
+	if partitions != int32(decl.Partitions) {
+		drift = append(drift, fmt.Sprintf("partitions %d, declared %d", partitions, decl.Partitions))
+	}
+
+	if replication != decl.ReplicationFactor {
+		drift = append(drift, fmt.Sprintf("replication factor %d, declared %d", replication, decl.ReplicationFactor))
+	}
+
+	declared := decl.configs()
+	changed := map[string]*string{}
+
+	for _, k := range sortedKeys(declared) {
+		v := declared[k]
+		if actual[k] != v {
+			drift = append(drift, fmt.Sprintf("%s %q, declared %q", k, actual[k], v))
+			changed[k] = &v
+		}
+	}
+
+	if len(drift) == 0 {
+		log.Debugf("kafka topic %s matches its declaration", name)
+
+		return nil
+	}
+
+	if !alter {
+		log.Warningf("kafka topic %s differs from its declaration, %s", name, strings.Join(drift, ", "))
+
+		return nil
+	}
+
+	return alterTopic(admin, name, decl, partitions, replication, overrides, changed)
+}
+
+func createTopic(admin topicAdmin, name string, decl *TopicConfiguration) error {
+	entries := map[string]*string{}
+
+	for k, v := range decl.configs() {
+		v := v
+		entries[k] = &v
+	}
+
+	err := admin.CreateTopic(
+		name,
+		&sarama.TopicDetail{
+			NumPartitions:     int32(decl.Partitions),
+			ReplicationFactor: int16(decl.ReplicationFactor),
+			ConfigEntries:     entries,
+		},
+		false,
+	)
+	if err != nil {
+		return errs.Wrap(err, "failed to create topic")
+	}
+
+	log.Infof(
+		"created kafka topic %s with %d partitions and replication factor %d",
+		name, decl.Partitions, decl.ReplicationFactor,
+	)
+
+	return nil
+}
+
+//nolint:revive // the current state of the topic is passed as it was described
+func alterTopic(
+	admin topicAdmin,
+	name string,
+	decl *TopicConfiguration,
+	partitions int32,
+	replication int,
+	overrides, changed map[string]*string,
+) error {
+	switch {
+	case partitions < int32(decl.Partitions):
+		err := admin.CreatePartitions(name, int32(decl.Partitions), nil, false)
+		if err != nil {
+			return errs.Wrap(err, "failed to add partitions")
+		}
+
+		log.Infof("increased partitions of kafka topic %s from %d to %d", name, partitions, decl.Partitions)
+	case partitions > int32(decl.Partitions):
+		log.Warningf(
+			"kafka topic %s has %d partitions, declared %d, partitions cannot be removed",
+			name, partitions, decl.Partitions,
+		)
+	}
+
+	if replication != decl.ReplicationFactor {
+		log.Warningf(
+			"kafka topic %s has replication factor %d, declared %d, replicas must be reassigned manually",
+			name, replication, decl.ReplicationFactor,
+		)
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	for k, v := range changed {
+		overrides[k] = v
+	}
+
+	err := admin.AlterConfig(sarama.TopicResource, name, overrides, false)
+	if err != nil {
+		return errs.Wrap(err, "failed to alter topic configs")
+	}
+
+	log.Infof("altered configs %s of kafka topic %s", strings.Join(sortedKeys(changed), ", "), name)
+
+	return nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
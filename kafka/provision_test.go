@@ -0,0 +1,223 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ topicAdmin = &mockAdmin{}
+
+type mockTopic struct {
+	partitions  int
+	replication int
+	configs     map[string]string
+	overrides   map[string]string
+}
+
+type mockAdmin struct {
+	topics map[string]*mockTopic
+	calls  []string
+}
+
+func (m *mockAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	out := make([]*sarama.TopicMetadata, 0, len(topics))
+
+	for _, name := range topics {
+		t, ok := m.topics[name]
+		if !ok {
+			out = append(out, &sarama.TopicMetadata{Name: name, Err: sarama.ErrUnknownTopicOrPartition})
+
+			continue
+		}
+
+		meta := &sarama.TopicMetadata{Name: name}
+		for i := 0; i < t.partitions; i++ {
+			meta.Partitions = append(
+				meta.Partitions, &sarama.PartitionMetadata{ID: int32(i), Replicas: make([]int32, t.replication)},
+			)
+		}
+
+		out = append(out, meta)
+	}
+
+	return out, nil
+}
+
+func (m *mockAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, _ bool) error {
+	m.calls = append(
+		m.calls,
+		fmt.Sprintf(
+			"create %s %d %d %s", topic, detail.NumPartitions, detail.ReplicationFactor, entries(detail.ConfigEntries),
+		),
+	)
+
+	return nil
+}
+
+func (m *mockAdmin) CreatePartitions(topic string, count int32, _ [][]int32, _ bool) error {
+	m.calls = append(m.calls, fmt.Sprintf("partitions %s %d", topic, count))
+
+	return nil
+}
+
+func (m *mockAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	t := m.topics[resource.Name]
+
+	var out []sarama.ConfigEntry
+
+	for k, v := range t.configs {
+		source := sarama.SourceDefault
+		if _, ok := t.overrides[k]; ok {
+			source = sarama.SourceTopic
+		}
+
+		out = append(out, sarama.ConfigEntry{Name: k, Value: v, Source: source})
+	}
+
+	return out, nil
+}
+
+func (m *mockAdmin) AlterConfig(_ sarama.ConfigResourceType, name string, e map[string]*string, _ bool) error {
+	m.calls = append(m.calls, fmt.Sprintf("alter %s %s", name, entries(e)))
+
+	return nil
+}
+
+func entries(e map[string]*string) string {
+	out := make([]string, 0, len(e))
+	for k, v := range e {
+		out = append(out, k+"="+*v)
+	}
+
+	sort.Strings(out)
+
+	return fmt.Sprint(out)
+}
+
+func Test_provisionTopics(t *testing.T) {
+	t.Parallel()
+
+	existing := func() map[string]*mockTopic {
+		return map[string]*mockTopic{
+			"zbx-events": {
+				partitions:  3,
+				replication: 2,
+				configs: map[string]string{
+					"retention.ms":     "86400000",
+					"cleanup.policy":   "delete",
+					"compression.type": "producer",
+					"segment.ms":       "3600000",
+				},
+				overrides: map[string]string{"retention.ms": "86400000", "segment.ms": "3600000"},
+			},
+		}
+	}
+
+	events := TopicConfiguration{
+		Partitions:        6,
+		ReplicationFactor: 3,
+		RetentionMs:       "604800000",
+		CompressionType:   "lz4",
+	}
+
+	tests := []struct {
+		name      string
+		mode      string
+		decls     map[string]TopicConfiguration
+		wantCalls []string
+		wantErr   bool
+	}{
+		{
+			"+create",
+			ProvisionCreate,
+			map[string]TopicConfiguration{
+				"items": {Partitions: 12, ReplicationFactor: 3, CleanupPolicy: "delete", RetentionMs: "3600000"},
+			},
+			[]string{"create zbx-items 12 3 [cleanup.policy=delete retention.ms=3600000]"},
+			false,
+		},
+		{"+driftReported", ProvisionCreate, map[string]TopicConfiguration{"events": events}, nil, false},
+		{
+			"+noDrift",
+			ProvisionAlter,
+			map[string]TopicConfiguration{
+				"events": {Partitions: 3, ReplicationFactor: 2, RetentionMs: "86400000", CleanupPolicy: "delete"},
+			},
+			nil,
+			false,
+		},
+		{
+			"+alter",
+			ProvisionAlter,
+			map[string]TopicConfiguration{"events": events},
+			[]string{
+				"partitions zbx-events 6",
+				"alter zbx-events [compression.type=lz4 retention.ms=604800000 segment.ms=3600000]",
+			},
+			false,
+		},
+		{
+			"+partitionsNotRemoved",
+			ProvisionAlter,
+			map[string]TopicConfiguration{"events": {Partitions: 1, ReplicationFactor: 2}},
+			nil,
+			false,
+		},
+		{
+			"+problemsTopicNotConfigured",
+			ProvisionCreate,
+			map[string]TopicConfiguration{"problems": {Partitions: 1, ReplicationFactor: 1}},
+			nil,
+			false,
+		},
+		{
+			"-unknownKind",
+			ProvisionCreate,
+			map[string]TopicConfiguration{"alerts": {Partitions: 1, ReplicationFactor: 1}},
+			nil,
+			true,
+		},
+		{"-unknownMode", "sometimes", map[string]TopicConfiguration{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			admin := &mockAdmin{topics: existing()}
+
+			err := provisionTopics(
+				admin,
+				tt.mode,
+				map[string]string{"events": "zbx-events", "items": "zbx-items", "problems": ""},
+				tt.decls,
+			)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("provisionTopics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if diff := cmp.Diff(tt.wantCalls, admin.calls); diff != "" {
+				t.Fatalf("provisionTopics() admin calls = %s", diff)
+			}
+		})
+	}
+}
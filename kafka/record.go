@@ -0,0 +1,89 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+)
+
+// Record is a message to be produced, it must not be modified once passed to a producer.
+type Record struct {
+	// Key is the message key, an empty key spreads messages across partitions.
+	Key string
+	// Value is the message value, a nil value produces a tombstone for the key.
+	Value   []byte
+	Headers []Header
+	// Timestamp is the message timestamp, if zero the producer sets the time the message is sent.
+	Timestamp time.Time
+	// Topic overrides the topic configured for the kind of the record.
+	Topic string
+}
+
+// Delivery is the result of producing a record, it is resolved once the record is acknowledged or fails.
+type Delivery struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// NewDelivery returns an unresolved delivery.
+func NewDelivery() *Delivery {
+	return &Delivery{done: make(chan struct{})}
+}
+
+// Delivered returns a delivery resolved with the error.
+func Delivered(err error) *Delivery {
+	d := NewDelivery()
+	d.Resolve(err)
+
+	return d
+}
+
+// Resolve sets the result of the delivery, only the first call has an effect.
+func (d *Delivery) Resolve(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.done)
+	})
+}
+
+// Done returns a channel closed when the delivery is resolved.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns the delivery error, it is nil until the delivery is resolved.
+func (d *Delivery) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the delivery is resolved and returns its error, or until the context is done.
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return errs.Wrap(ctx.Err(), "delivery not acknowledged")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDelivery(t *testing.T) {
+	t.Parallel()
+
+	d := NewDelivery()
+
+	if d.Err() != nil {
+		t.Fatalf("Delivery.Err() expected nil error before resolve")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Delivery.Wait() expected context error for unresolved delivery, but got: %v", err)
+	}
+
+	want := errors.New("fail")
+
+	d.Resolve(want)
+	d.Resolve(nil)
+
+	select {
+	case <-d.Done():
+	default:
+		t.Fatalf("Delivery.Done() expected closed channel after resolve")
+	}
+
+	if err := d.Wait(context.Background()); !errors.Is(err, want) {
+		t.Fatalf("Delivery.Wait() expected first resolve error, but got: %v", err)
+	}
+
+	if err := Delivered(nil).Err(); err != nil {
+		t.Fatalf("Delivered() expected resolved delivery without error, but got: %s", err.Error())
+	}
+}
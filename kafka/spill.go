@@ -0,0 +1,84 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+)
+
+// Kinds of spilled records, by the topic they were sent to.
+const (
+	SpillItems    = "items"
+	SpillEvents   = "events"
+	SpillProblems = "problems"
+	SpillOther    = "other"
+)
+
+// SpillFunc receives a record the producer failed to deliver, with the kind of its topic.
+type SpillFunc func(kind string, r *Record)
+
+// SetSpill sets the function records are passed to once they fail to be delivered, for example to keep
+// the records still queued on shutdown. A nil function stops spilling.
+func (p *DefaultProducer) SetSpill(fn SpillFunc) {
+	if fn == nil {
+		p.spill.Store(nil)
+
+		return
+	}
+
+	p.spill.Store(&fn)
+}
+
+// spillMessage passes the failed message to the spill function, if one is set.
+func (p *DefaultProducer) spillMessage(m *sarama.ProducerMessage) {
+	fn := p.spill.Load()
+	if fn == nil {
+		return
+	}
+
+	(*fn)(p.kindOf(m.Topic), recordOf(m))
+}
+
+func (p *DefaultProducer) kindOf(topic string) string {
+	switch topic {
+	case p.itemsTopic:
+		return SpillItems
+	case p.eventsTopic:
+		return SpillEvents
+	case p.problemsTopic:
+		return SpillProblems
+	default:
+		return SpillOther
+	}
+}
+
+// recordOf returns the record of a message created by newMessage, with the topic it was sent to.
+func recordOf(m *sarama.ProducerMessage) *Record {
+	r := &Record{Topic: m.Topic, Timestamp: m.Timestamp}
+
+	if k, ok := m.Key.(sarama.StringEncoder); ok {
+		r.Key = string(k)
+	}
+
+	if v, ok := m.Value.(sarama.ByteEncoder); ok {
+		r.Value = []byte(v)
+	}
+
+	for _, h := range m.Headers {
+		r.Headers = append(r.Headers, Header{Key: string(h.Key), Value: string(h.Value)})
+	}
+
+	return r
+}
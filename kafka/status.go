@@ -0,0 +1,92 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+)
+
+// Status is the state of a producer for the admin API.
+type Status struct {
+	Brokers    []BrokerStatus    `json:"brokers"`
+	QueueDepth int               `json:"queue_depth"`
+	Breaker    string            `json:"breaker,omitempty"`
+	Counts     Counts            `json:"counts"`
+	Topics     map[string]Counts `json:"topics"`
+}
+
+// BrokerStatus is the connection state of a broker known to the producer.
+type BrokerStatus struct {
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+}
+
+type topicCounters struct {
+	produced *metrics.Counter
+	failed   *metrics.Counter
+	dropped  *metrics.Counter
+}
+
+// Name returns the name of the cluster, it is empty for the default cluster.
+func (p *DefaultProducer) Name() string {
+	return p.name
+}
+
+// Status returns the current state of the producer with counters per topic.
+func (p *DefaultProducer) Status() Status {
+	s := Status{QueueDepth: p.QueueDepth(), Counts: p.Counts(), Topics: map[string]Counts{}}
+
+	if p.breaker != nil {
+		s.Breaker = p.breaker.current()
+	}
+
+	if p.client != nil {
+		for _, b := range p.client.Brokers() {
+			connected, _ := b.Connected() //nolint:errcheck // the error is the reason of a failed connection
+			s.Brokers = append(s.Brokers, BrokerStatus{Addr: b.Addr(), Connected: connected})
+		}
+	}
+
+	p.topicCounters.Range(func(k, v any) bool {
+		c := v.(*topicCounters) //nolint:forcetypeassert // only topic counters are stored
+
+		s.Topics[k.(string)] = Counts{ //nolint:forcetypeassert // keys are topic names
+			Produced: c.produced.Value(),
+			Failed:   c.failed.Value(),
+			Dropped:  c.dropped.Value(),
+		}
+
+		return true
+	})
+
+	return s
+}
+
+// topic returns the counters of the topic, registered as <prefix>topic.<topic>.* metrics on first use.
+func (p *DefaultProducer) topic(name string) *topicCounters {
+	if c, ok := p.topicCounters.Load(name); ok {
+		return c.(*topicCounters) //nolint:forcetypeassert // only topic counters are stored
+	}
+
+	prefix := p.prefix + "topic." + name + "."
+
+	c, _ := p.topicCounters.LoadOrStore(name, &topicCounters{
+		produced: metrics.GetCounter(prefix + "produced"),
+		failed:   metrics.GetCounter(prefix + "failed"),
+		dropped:  metrics.GetCounter(prefix + "dropped"),
+	})
+
+	return c.(*topicCounters) //nolint:forcetypeassert // only topic counters are stored
+}
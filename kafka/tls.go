@@ -0,0 +1,168 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"git.zabbix.com/ZT/kafka-connector/certs"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// tlsMaterial holds the CA and client certificates used for broker connections, which can be reloaded
+// at runtime. New broker connections use the current certificates, established connections are kept.
+type tlsMaterial struct {
+	// ca is nil if the system CA pool is used.
+	ca *certs.Pool
+	// keyPair is nil if no client certificate is presented.
+	keyPair *certs.KeyPair
+	// serverName overrides the broker host name verified on every connection.
+	serverName string
+	insecure   bool
+}
+
+// tlsDialer dials broker connections and performs the TLS handshake with the current certificates.
+// Sarama builds a single TLS config for all brokers, so TLS is done in the dialer instead,
+// where the config is created per connection and verifies the name of the broker being dialed.
+type tlsDialer struct {
+	dialer   *net.Dialer
+	material *tlsMaterial
+}
+
+// newTLSMaterial loads the TLS certificates, returns nil if TLS is disabled.
+// The CA file is optional, the system CA pool is used if it is not set.
+// The client certificate and key are required if TLS authentication is enabled.
+func newTLSMaterial(c *Configuration) (*tlsMaterial, error) {
+	if !c.EnableTLS && !c.TLSAuth {
+		return nil, nil //nolint:nilnil // TLS is disabled
+	}
+
+	m := &tlsMaterial{serverName: c.TLSServerName, insecure: c.TLSInsecureSkipVerify}
+
+	var err error
+
+	if c.CaFile != "" {
+		m.ca, err = certs.NewPool(c.CaFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.TLSAuth {
+		m.keyPair, err = certs.NewKeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.insecure {
+		log.Warningf("kafka broker certificates are not verified, do not use Kafka.TLSInsecureSkipVerify in production")
+	}
+
+	return m, nil
+}
+
+// clientConfig returns the TLS config of a connection to the broker address.
+func (m *tlsMaterial) clientConfig(addr string) *tls.Config {
+	serverName := m.serverName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+
+		serverName = host
+	}
+
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: m.insecure, //nolint:gosec // only set if configured, for test environments
+	}
+
+	if m.ca != nil {
+		conf.RootCAs = m.ca.Pool()
+	}
+
+	if m.keyPair != nil {
+		conf.GetClientCertificate = m.keyPair.GetClientCertificate
+	}
+
+	return conf
+}
+
+// reload reads all certificate files again, the current certificates are kept for files which are invalid.
+func (m *tlsMaterial) reload() error {
+	var caErr, keyErr error
+
+	if m.ca != nil {
+		caErr = m.ca.Reload()
+	}
+
+	if m.keyPair != nil {
+		keyErr = m.keyPair.Reload()
+	}
+
+	if caErr != nil {
+		return caErr
+	}
+
+	return keyErr
+}
+
+func (m *tlsMaterial) files() []string {
+	var out []string
+
+	if m.ca != nil {
+		out = append(out, m.ca.File())
+	}
+
+	if m.keyPair != nil {
+		out = append(out, m.keyPair.Files()...)
+	}
+
+	return out
+}
+
+// Dial implements the Sarama proxy dialer interface.
+func (d *tlsDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	if d.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d.dialer.Timeout)
+		defer cancel()
+	}
+
+	tlsConn := tls.Client(conn, d.material.clientConfig(addr))
+
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close() //nolint:errcheck // handshake error is returned
+
+		return nil, errs.Wrapf(err, "tls handshake with broker %s failed", addr)
+	}
+
+	return tlsConn, nil
+}
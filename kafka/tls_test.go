@@ -0,0 +1,422 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func Test_newTLSMaterial(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+
+	ca := newTestCert(t, "ca", nil)
+	writeTestCert(t, caFile, "", ca)
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "client", ca))
+
+	tests := []struct {
+		name      string
+		c         Configuration
+		wantNil   bool
+		wantFiles int
+		wantErr   bool
+	}{
+		{"+disabled", Configuration{CaFile: caFile}, true, 0, false},
+		{"+systemPool", Configuration{EnableTLS: true}, false, 0, false},
+		{"+customCA", Configuration{EnableTLS: true, CaFile: caFile}, false, 1, false},
+		{
+			"+tlsAuth",
+			Configuration{TLSAuth: true, CaFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile},
+			false,
+			3,
+			false,
+		},
+		{
+			"+tlsAuthSystemPool",
+			Configuration{TLSAuth: true, ClientCertFile: certFile, ClientKeyFile: keyFile},
+			false,
+			2,
+			false,
+		},
+		{"-tlsAuthWithoutCert", Configuration{TLSAuth: true, CaFile: caFile}, false, 0, true},
+		{"-invalidCA", Configuration{EnableTLS: true, CaFile: certFile + ".missing"}, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := newTLSMaterial(&tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTLSMaterial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newTLSMaterial() expected nil: %t, but got: %v", tt.wantNil, got)
+			}
+
+			if got != nil && len(got.files()) != tt.wantFiles {
+				t.Fatalf("newTLSMaterial() expected %d files, but got: %v", tt.wantFiles, got.files())
+			}
+		})
+	}
+}
+
+func Test_tlsMaterial_clientConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		material       tlsMaterial
+		addr           string
+		wantServerName string
+	}{
+		{"+brokerHost", tlsMaterial{}, "broker2.example.com:9093", "broker2.example.com"},
+		{"+brokerIP", tlsMaterial{}, "10.0.0.2:9093", "10.0.0.2"},
+		{"+noPort", tlsMaterial{}, "broker2.example.com", "broker2.example.com"},
+		{"+override", tlsMaterial{serverName: "kafka.example.com"}, "10.0.0.2:9093", "kafka.example.com"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := tt.material.clientConfig(tt.addr)
+			if got.ServerName != tt.wantServerName {
+				t.Fatalf(
+					"tlsMaterial.clientConfig() expected server name: %s, but got: %s",
+					tt.wantServerName, got.ServerName,
+				)
+			}
+
+			if got.InsecureSkipVerify {
+				t.Fatalf("tlsMaterial.clientConfig() expected certificates to be verified")
+			}
+
+			if got.RootCAs != nil {
+				t.Fatalf("tlsMaterial.clientConfig() expected system CA pool to be used")
+			}
+		})
+	}
+}
+
+func Test_tlsDialer_Dial(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	otherFile := filepath.Join(dir, "other.pem")
+
+	ca := newTestCert(t, "ca", nil)
+	writeTestCert(t, caFile, "", ca)
+	writeTestCert(t, otherFile, "", newTestCert(t, "other", nil))
+
+	addr := serveTLS(t, newTestCert(t, "broker1.example.com", ca, "broker1.example.com", "127.0.0.1"))
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("failed to split listener address: %s", err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		c       Configuration
+		addr    string
+		wantErr bool
+	}{
+		{"+ipAddress", Configuration{EnableTLS: true, CaFile: caFile}, addr, false},
+		{
+			"+serverName",
+			Configuration{EnableTLS: true, CaFile: caFile, TLSServerName: "broker1.example.com"},
+			addr,
+			false,
+		},
+		{
+			"+insecure",
+			Configuration{EnableTLS: true, CaFile: otherFile, TLSInsecureSkipVerify: true},
+			addr,
+			false,
+		},
+		{"-hostName", Configuration{EnableTLS: true, CaFile: caFile}, net.JoinHostPort("localhost", port), true},
+		{
+			"-wrongServerName",
+			Configuration{EnableTLS: true, CaFile: caFile, TLSServerName: "broker2.example.com"},
+			addr,
+			true,
+		},
+		{"-unknownCA", Configuration{EnableTLS: true, CaFile: otherFile}, addr, true},
+		{"-systemPool", Configuration{EnableTLS: true}, addr, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := newTLSMaterial(&tt.c)
+			if err != nil {
+				t.Fatalf("newTLSMaterial() unexpected error: %s", err.Error())
+			}
+
+			d := &tlsDialer{dialer: &net.Dialer{Timeout: 5 * time.Second}, material: m}
+
+			conn, err := d.Dial("tcp", tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tlsDialer.Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func Test_tlsMaterial_reload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+
+	oldCA := newTestCert(t, "old ca", nil)
+	newCA := newTestCert(t, "new ca", nil)
+
+	writeTestCert(t, caFile, "", oldCA)
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "old client", oldCA))
+
+	m, err := newTLSMaterial(
+		&Configuration{TLSAuth: true, CaFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile},
+	)
+	if err != nil {
+		t.Fatalf("newTLSMaterial() unexpected error: %s", err.Error())
+	}
+
+	// the broker certificate has been rotated to the new CA, which is not trusted yet.
+	broker := newTestCert(t, "localhost", newCA)
+
+	_, err = handshake(t, m.clientConfig("localhost:9093"), broker, newCA)
+	if err == nil {
+		t.Fatalf("tlsMaterial.clientConfig() expected handshake to fail before reload")
+	}
+
+	writeTestCert(t, caFile, "", newCA)
+	writeTestCert(t, certFile, keyFile, newTestCert(t, "new client", newCA))
+
+	err = m.reload()
+	if err != nil {
+		t.Fatalf("tlsMaterial.reload() unexpected error: %s", err.Error())
+	}
+
+	client, err := handshake(t, m.clientConfig("localhost:9093"), broker, newCA)
+	if err != nil {
+		t.Fatalf("tlsMaterial.clientConfig() unexpected handshake error after reload: %s", err.Error())
+	}
+
+	if client != "new client" {
+		t.Fatalf("tlsMaterial.clientConfig() expected client certificate: new client, but got: %s", client)
+	}
+}
+
+// handshake connects with the client config to a server presenting the broker certificate and requiring
+// a client certificate issued by the client CA, returns the client certificate common name.
+func handshake(t *testing.T, conf *tls.Config, broker, clientCA *testCert) (string, error) {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+
+	serverConn, clientConn := net.Pipe()
+
+	server := tls.Server(serverConn, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{
+			{Certificate: [][]byte{broker.cert.Raw}, PrivateKey: broker.key},
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	})
+
+	result := make(chan string, 1)
+
+	go func() {
+		defer server.Close()
+
+		if server.Handshake() != nil {
+			result <- ""
+
+			return
+		}
+
+		result <- server.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}()
+
+	client := tls.Client(clientConn, conf)
+	defer client.Close()
+
+	err := client.Handshake()
+	if err != nil {
+		clientConn.Close()
+		<-result
+
+		return "", err
+	}
+
+	return <-result, nil
+}
+
+// serveTLS accepts TLS connections on a local listener with the broker certificate, returns its address.
+func serveTLS(t *testing.T, broker *testCert) string {
+	t.Helper()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{
+			{Certificate: [][]byte{broker.cert.Raw}, PrivateKey: broker.key},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				conn.(*tls.Conn).Handshake() //nolint:errcheck,forcetypeassert // the client checks the result
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// newTestCert creates a certificate signed by the issuer, self-signed CA if the issuer is nil.
+// Subject alternative names are added as IP addresses or DNS names, the common name is used if there are none.
+func newTestCert(t *testing.T, cn string, issuer *testCert, sans ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if len(sans) == 0 {
+		sans = []string{cn}
+	}
+
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+
+	parent, signer := tmpl, key
+
+	if issuer == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err.Error())
+	}
+
+	return &testCert{cert, key}
+}
+
+// writeTestCert writes the PEM encoded certificate, and the key if keyFile is set.
+func writeTestCert(t *testing.T, certFile, keyFile string, c *testCert) {
+	t.Helper()
+
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %s", err.Error())
+	}
+
+	if keyFile == "" {
+		return
+	}
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err.Error())
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %s", err.Error())
+	}
+}
@@ -0,0 +1,173 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+	"github.com/IBM/sarama"
+)
+
+// Topic check modes.
+const (
+	TopicCheckOff   = "off"
+	TopicCheckWarn  = "warn"
+	TopicCheckFail  = "fail"
+	TopicCheckRetry = "retry"
+)
+
+const (
+	metadataKey = 3
+	// metadataAuthzVersion is the first metadata request version reporting the operations allowed on topics.
+	metadataAuthzVersion = 8
+	// authzOmitted is reported for topic operations that were not requested or are not known.
+	authzOmitted = -2147483648
+)
+
+// verifyTopics checks the topics according to the mode, in the retry mode it blocks until the check passes
+// or the context is done.
+func verifyTopics(ctx context.Context, mode string, interval time.Duration, check func() error) error {
+	switch mode {
+	case TopicCheckOff:
+		return nil
+	case TopicCheckWarn:
+		err := check()
+		if err != nil {
+			log.Warningf("kafka topic verification failed, %s", err.Error())
+		}
+
+		return nil
+	case TopicCheckFail:
+		return check()
+	case TopicCheckRetry:
+		for {
+			err := check()
+			if err == nil {
+				return nil
+			}
+
+			log.Warningf("kafka topic verification failed, retrying in %s, %s", interval, err.Error())
+
+			timer := time.NewTimer(interval)
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+
+				return errs.Wrapf(ctx.Err(), "kafka topic verification stopped, %s", err.Error())
+			}
+		}
+	default:
+		return errs.Errorf("unknown topic check mode %q", mode)
+	}
+}
+
+// checkTopics confirms that the configured topics exist and the producer may write to them, connecting with
+// the config, which must not be the one of the producer client. Write permission is checked only if the broker
+// reports the operations allowed on topics.
+func (p *DefaultProducer) checkTopics(config *sarama.Config) error {
+	topics := p.topics()
+
+	lb := p.client.LeastLoadedBroker()
+	if lb == nil {
+		return errs.New("no broker available")
+	}
+
+	// a separate connection, as the client refuses requests newer than the configured Kafka version,
+	// the request version is still limited to the versions the broker supports.
+	if !config.Version.IsAtLeast(sarama.V2_3_0_0) {
+		config.Version = sarama.V2_3_0_0
+	}
+
+	b := sarama.NewBroker(lb.Addr())
+
+	err := b.Open(config)
+	if err != nil {
+		return errs.Wrap(err, "failed to connect to broker")
+	}
+
+	defer b.Close() //nolint:errcheck // the connection is used for the check only
+
+	req := &sarama.MetadataRequest{Version: metadataVersion(b, p.client.Config()), Topics: topics}
+	req.IncludeTopicAuthorizedOperations = req.Version >= metadataAuthzVersion
+
+	resp, err := b.GetMetadata(req)
+	if err != nil {
+		return errs.Wrap(err, "failed to get topic metadata")
+	}
+
+	err = verifyMetadata(resp, topics)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("kafka topics %s verified", strings.Join(topics, ", "))
+
+	return nil
+}
+
+// metadataVersion returns the metadata request version reporting topic operations if the broker supports it,
+// otherwise the version for the configured Kafka version.
+func metadataVersion(b *sarama.Broker, c *sarama.Config) int16 {
+	resp, err := b.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err == nil {
+		for _, k := range resp.ApiKeys {
+			if k.ApiKey == metadataKey && k.MaxVersion >= metadataAuthzVersion {
+				return metadataAuthzVersion
+			}
+		}
+	}
+
+	return sarama.NewMetadataRequest(c.Version, nil).Version
+}
+
+// verifyMetadata returns an error listing every topic that does not exist or cannot be written to.
+func verifyMetadata(resp *sarama.MetadataResponse, topics []string) error {
+	found := make(map[string]*sarama.TopicMetadata, len(resp.Topics))
+	for _, t := range resp.Topics {
+		found[t.Name] = t
+	}
+
+	var problems []string
+
+	for _, name := range topics {
+		t, ok := found[name]
+
+		switch {
+		case !ok:
+			problems = append(problems, "topic "+name+" is missing in metadata")
+		case t.Err == sarama.ErrUnknownTopicOrPartition:
+			problems = append(problems, "topic "+name+" does not exist")
+		case t.Err == sarama.ErrTopicAuthorizationFailed:
+			problems = append(problems, "not authorized to access topic "+name)
+		case t.Err != sarama.ErrNoError:
+			problems = append(problems, "topic "+name+", "+t.Err.Error())
+		case resp.Version >= metadataAuthzVersion && t.TopicAuthorizedOperations != authzOmitted &&
+			t.TopicAuthorizedOperations&(1<<sarama.AclOperationWrite) == 0:
+			problems = append(problems, "not authorized to write to topic "+name)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errs.New(strings.Join(problems, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func Test_verifyMetadata(t *testing.T) {
+	t.Parallel()
+
+	const writable = 1<<sarama.AclOperationWrite | 1<<sarama.AclOperationDescribe
+
+	topic := func(name string, err sarama.KError, ops int32) *sarama.TopicMetadata {
+		return &sarama.TopicMetadata{Name: name, Err: err, TopicAuthorizedOperations: ops}
+	}
+
+	tests := []struct {
+		name    string
+		resp    *sarama.MetadataResponse
+		wantErr string
+	}{
+		{
+			"+valid",
+			&sarama.MetadataResponse{
+				Version: 8,
+				Topics:  []*sarama.TopicMetadata{topic("events", 0, writable), topic("items", 0, writable)},
+			},
+			"",
+		},
+		{
+			"+operationsOmitted",
+			&sarama.MetadataResponse{
+				Version: 8,
+				Topics: []*sarama.TopicMetadata{
+					topic("events", 0, authzOmitted), topic("items", 0, authzOmitted),
+				},
+			},
+			"",
+		},
+		{
+			"+oldVersion",
+			&sarama.MetadataResponse{
+				Version: 7,
+				Topics:  []*sarama.TopicMetadata{topic("events", 0, 0), topic("items", 0, 0)},
+			},
+			"",
+		},
+		{
+			"-missing",
+			&sarama.MetadataResponse{
+				Version: 8,
+				Topics: []*sarama.TopicMetadata{
+					topic("events", sarama.ErrUnknownTopicOrPartition, authzOmitted), topic("items", 0, writable),
+				},
+			},
+			"Topic events does not exist.",
+		},
+		{
+			"-notInResponse",
+			&sarama.MetadataResponse{Version: 8, Topics: []*sarama.TopicMetadata{topic("events", 0, writable)}},
+			"Topic items is missing in metadata.",
+		},
+		{
+			"-unauthorized",
+			&sarama.MetadataResponse{
+				Version: 8,
+				Topics: []*sarama.TopicMetadata{
+					topic("events", sarama.ErrTopicAuthorizationFailed, authzOmitted),
+					topic("items", 0, 1<<sarama.AclOperationDescribe),
+				},
+			},
+			"Not authorized to access topic events, not authorized to write to topic items.",
+		},
+		{
+			"-otherError",
+			&sarama.MetadataResponse{
+				Version: 8,
+				Topics: []*sarama.TopicMetadata{
+					topic("events", 0, writable), topic("items", sarama.ErrLeaderNotAvailable, authzOmitted),
+				},
+			},
+			"Topic items, " + sarama.ErrLeaderNotAvailable.Error() + ".",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := verifyMetadata(tt.resp, []string{"events", "items"})
+
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+
+			if got != tt.wantErr {
+				t.Fatalf("verifyMetadata() expected error: %q, but got: %q", tt.wantErr, got)
+			}
+		})
+	}
+}
+
+func Test_verifyTopics_canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls int
+
+	err := verifyTopics(ctx, TopicCheckRetry, time.Hour, func() error {
+		calls++
+
+		cancel()
+
+		return errors.New("topic events does not exist")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("verifyTopics() expected error: %v, but got: %v", context.Canceled, err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("verifyTopics() expected 1 check, but got: %d", calls)
+	}
+}
+
+func Test_verifyTopics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		mode      string
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"+off", TopicCheckOff, 1, 0, false},
+		{"+warn", TopicCheckWarn, 1, 1, false},
+		{"+fail", TopicCheckFail, 0, 1, false},
+		{"+retry", TopicCheckRetry, 2, 3, false},
+		{"-fail", TopicCheckFail, 1, 1, true},
+		{"-unknownMode", "maybe", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls int
+
+			err := verifyTopics(context.Background(), tt.mode, time.Millisecond, func() error {
+				calls++
+
+				if calls <= tt.failures {
+					return errors.New("topic events does not exist")
+				}
+
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyTopics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if calls != tt.wantCalls {
+				t.Fatalf("verifyTopics() expected %d checks, but got: %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
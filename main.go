@@ -22,11 +22,21 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 
+	"git.zabbix.com/ZT/kafka-connector/auth"
+	"git.zabbix.com/ZT/kafka-connector/certs"
+	"git.zabbix.com/ZT/kafka-connector/correlation"
+	"git.zabbix.com/ZT/kafka-connector/health"
 	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
 	"git.zabbix.com/ZT/kafka-connector/server"
+	"git.zabbix.com/ZT/kafka-connector/sink"
+	"git.zabbix.com/ZT/kafka-connector/systemd"
+	"git.zabbix.com/ZT/kafka-connector/watch"
 	"git.zabbix.com/ap/plugin-support/conf"
 	"git.zabbix.com/ap/plugin-support/errs"
 	"git.zabbix.com/ap/plugin-support/log"
@@ -51,6 +61,12 @@ Zabbix home page: <https://www.zabbix.com>
 Documentation: <https://www.zabbix.com/documentation>
 `
 
+const (
+	// kafkaHealth is the health component reporting whether Kafka is reachable.
+	kafkaHealth         = "kafka.brokers"
+	kafkaHealthInterval = 10 * time.Second
+)
+
 type serverConf struct {
 	Port        string `conf:"default=80"`
 	LogType     string `conf:"default=file"`
@@ -63,11 +79,47 @@ type serverConf struct {
 	LogLevel    int    `conf:"range=0:5,default=3"`
 	EnableTLS   bool   `conf:"default=false"`
 	Timeout     int    `conf:"range=1:30,default=3"`
+	Encoding    string `conf:"default=raw"`
+
+	MaxDecompressedSize int  `conf:"range=1:4096,default=64"`
+	MaxBodySize         int  `conf:"range=1:4096,default=64"`
+	MaxRecords          int  `conf:"range=0:10000000,default=0"`
+	Streaming           bool `conf:"default=false"`
+	PassThrough         bool `conf:"default=false"`
+
+	TokenFile     string `conf:"optional"`
+	WatchInterval int    `conf:"range=1:3600,default=10"`
+
+	MaintenanceFile string `conf:"optional"`
+
+	ClientCAFile        string `conf:"optional"`
+	ClientVerify        string `conf:"default=none"`
+	ClientCertAllowFile string `conf:"optional"`
+	TLSMinVersion       string `conf:"default=1.2"`
+	TLSCipherSuites     string `conf:"optional"`
+
+	QueueHighWaterMark int `conf:"range=0:10000000,default=0"`
+	QueueRetryAfter    int `conf:"range=1:3600,default=5"`
+	ProduceTimeout     int `conf:"range=0:3600,default=10"`
+
+	ShutdownDelay int `conf:"range=0:300,default=0"`
+	DrainTimeout  int `conf:"range=1:3600,default=5"`
+	FlushTimeout  int `conf:"range=1:3600,default=10"`
+
+	SpoolDir string `conf:"optional"`
 }
 
 type configuration struct {
-	Kafka     kafka.Configuration `conf:"optional"`
-	Connector serverConf          `conf:"optional"`
+	Kafka       kafka.Configuration           `conf:"optional"`
+	Connector   serverConf                    `conf:"optional"`
+	Correlation correlation.Configuration     `conf:"optional"`
+	Dedup       server.DedupConfiguration     `conf:"optional"`
+	RateLimit   server.RateLimitConfiguration `conf:"optional"`
+	Sink        sink.Configuration            `conf:"optional"`
+	Failover    sink.FailoverConfiguration    `conf:"optional"`
+
+	KafkaClusters map[string]kafka.Configuration      `conf:"optional"`
+	Topics        map[string]kafka.TopicConfiguration `conf:"optional"`
 }
 
 type arguments struct {
@@ -107,9 +159,50 @@ func main() {
 		fatalExit("failed to initialize the logger", err)
 	}
 
-	p, err := kafka.NewProducer(&c.Kafka)
+	notify(systemd.Status("initializing sinks"))
+
+	a := &admin{config: &c}
+
+	var producers []*kafka.DefaultProducer
+
+	// a shutdown signal stops waiting for Kafka topics while the sinks are created.
+	startup, stopStartup := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	sinks := sink.NewRegistry()
+	sinks.Register(sink.NameKafka, func() (sink.Sink, error) {
+		s, ps, err := newKafkaSink(startup, &c)
+		if err != nil {
+			return nil, err
+		}
+
+		producers = ps
+
+		return s, nil
+	})
+	sinks.Register(sink.NameFile, func() (sink.Sink, error) { return sink.NewFile(&c.Sink) })
+	sinks.Register(sink.NameStdout, func() (sink.Sink, error) { return sink.NewWriter(sink.NameStdout, os.Stdout), nil })
+
+	// only the selected sinks are created, so no broker is needed if Kafka is not selected.
+	p, err := sink.NewProducer(sinks, &c.Sink)
+
+	stopStartup()
+
 	if err != nil {
-		fatalExit("failed to initialize kafka producer", err)
+		fatalExit("failed to initialize sinks", err)
+	}
+
+	a.producers = producers
+
+	for _, kp := range producers {
+		if files := kp.TLSFiles(); len(files) > 0 {
+			w := a.watchFile(files, c.Connector.WatchInterval, "kafka tls certificates", kp.ReloadTLS)
+			defer w.Stop() //nolint:gocritic // watchers run until main returns
+		}
+	}
+
+	if c.Connector.SpoolDir != "" {
+		notify(systemd.Status("sending spooled records"))
+		replay(c.Connector.SpoolDir, p, time.Duration(c.Connector.FlushTimeout)*time.Second)
 	}
 
 	allowedIPs, err := zbxnet.GetAllowedPeers(c.Connector.AllowedIP)
@@ -117,42 +210,461 @@ func main() {
 		fatalExit("failed to initialize allowed ip", err)
 	}
 
-	router := server.NewRouter(p, c.Connector.BearerToken, allowedIPs)
+	encoding, err := server.ParseEncoding(c.Connector.Encoding)
+	if err != nil {
+		fatalExit("failed to initialize encoding", err)
+	}
+
+	var correlator *correlation.Correlator
+
+	if c.Correlation.Enable {
+		correlator, err = correlation.New(&c.Correlation)
+		if err != nil {
+			fatalExit("failed to initialize event correlation", err)
+		}
+
+		correlator.StartSaving(time.Duration(c.Correlation.SaveInterval) * time.Second)
+	}
+
+	var tokens *auth.Tokens
+
+	if c.Connector.TokenFile != "" {
+		tokens, err = auth.NewTokens(c.Connector.TokenFile)
+		if err != nil {
+			fatalExit("failed to load client tokens", err)
+		}
+
+		w := a.watchFile([]string{c.Connector.TokenFile}, c.Connector.WatchInterval, "client tokens", tokens.Reload)
+		defer w.Stop()
+	}
+
+	var allowList *auth.Certificates
+
+	if c.Connector.ClientCertAllowFile != "" {
+		allowList, err = auth.NewCertificates(c.Connector.ClientCertAllowFile)
+		if err != nil {
+			fatalExit("failed to load client certificate allow-list", err)
+		}
+
+		w := a.watchFile(
+			[]string{c.Connector.ClientCertAllowFile},
+			c.Connector.WatchInterval,
+			"client certificate allow-list",
+			allowList.Reload,
+		)
+		defer w.Stop()
+	}
+
+	pause := server.NewPause()
+	defer pause.Close()
+
+	if c.Connector.MaintenanceFile != "" {
+		pause.WatchFile(c.Connector.MaintenanceFile, time.Duration(c.Connector.WatchInterval)*time.Second)
+	}
+
+	stopPauseSignals := handlePauseSignals(pause)
+	defer stopPauseSignals()
+
+	inflight := server.NewInflight()
+
+	router := server.NewRouter(
+		p,
+		c.Connector.BearerToken,
+		allowedIPs,
+		&server.Options{
+			Encoding:   encoding,
+			Correlator: correlator,
+			Dedup:      &c.Dedup,
+			RateLimit:  &c.RateLimit,
+
+			MaxDecompressedSize: int64(c.Connector.MaxDecompressedSize) * 1024 * 1024,
+			MaxBodySize:         int64(c.Connector.MaxBodySize) * 1024 * 1024,
+			MaxRecords:          c.Connector.MaxRecords,
+			Streaming:           c.Connector.Streaming,
+			PassThrough:         c.Connector.PassThrough,
+			Tokens:              tokens,
+			Certificates:        allowList,
+			MaxQueueDepth:       c.Connector.QueueHighWaterMark,
+			QueueRetryAfter:     time.Duration(c.Connector.QueueRetryAfter) * time.Second,
+			ProduceTimeout:      time.Duration(c.Connector.ProduceTimeout) * time.Second,
+			Admin:               a,
+			Pause:               pause,
+			Inflight:            inflight,
+		},
+	)
 
 	s := server.ServerInit(c.Connector.Port, router, c.Connector.Timeout)
 
+	if c.Connector.EnableTLS {
+		keyPair, err := certs.NewKeyPair(c.Connector.CertFile, c.Connector.KeyFile)
+		if err != nil {
+			fatalExit("failed to load tls certificate", err)
+		}
+
+		w := a.watchFile(keyPair.Files(), c.Connector.WatchInterval, "tls certificate", keyPair.Reload)
+		defer w.Stop()
+
+		s.TLSConfig, err = server.NewTLSConfig(&server.TLSConfiguration{
+			KeyPair:      keyPair,
+			MinVersion:   c.Connector.TLSMinVersion,
+			CipherSuites: c.Connector.TLSCipherSuites,
+			ClientCAFile: c.Connector.ClientCAFile,
+			ClientVerify: c.Connector.ClientVerify,
+		})
+		if err != nil {
+			fatalExit("failed to initialize tls", err)
+		}
+	}
+
 	log.Infof("Starting server")
 
+	l, err := server.Listen(s)
+	if err != nil {
+		fatalExit("failed to start the server", err)
+	}
+
 	errors := make(chan error)
 
-	go server.Run(s, c.Connector.CertFile, c.Connector.KeyFile, c.Connector.EnableTLS, errors)
+	go server.Run(s, l, c.Connector.CertFile, c.Connector.KeyFile, c.Connector.EnableTLS, errors)
+
+	notify(systemd.Ready, systemd.Status("serving on "+l.Addr().String()))
+
+	stopKafkaHealth := watchKafkaHealth(pause, producers, kafkaHealthInterval)
+	defer stopKafkaHealth()
+
+	// the watchdog checks that the connector itself still serves, Kafka outages are reported by the health status.
+	wd := systemd.StartWatchdog(func() bool { return server.Alive(router) })
 
 	err = waitExit(errors)
 	if err != nil {
 		fatalExit("server failed", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	wd.Stop()
 
-	defer cancel()
+	// records failing while requests drain are spooled too, not only the ones still queued afterwards.
+	closeSpool := func() {}
+
+	if c.Connector.SpoolDir != "" {
+		closeSpool, err = spill(c.Connector.SpoolDir, &c.Sink, producers)
+		if err != nil {
+			log.Errf("failed to open the spool, undelivered records are lost, %s", err.Error())
+		}
+	}
+
+	notify(systemd.Stopping, systemd.Status("draining in-flight requests"))
 
 	log.Infof("shutting down the server")
 
-	err = s.Shutdown(ctx)
+	err = server.Drain(s, pause, inflight, &server.DrainConfiguration{
+		Delay:   time.Duration(c.Connector.ShutdownDelay) * time.Second,
+		Timeout: time.Duration(c.Connector.DrainTimeout) * time.Second,
+	})
 	if err != nil {
 		log.Errf("failed to shutdown the server, %s", err.Error())
 	}
 
-	log.Debugf("shutting down the kafka producer")
+	log.Infof("flushing %d queued records", p.QueueDepth())
+	notify(systemd.Status(fmt.Sprintf("flushing %d queued records", p.QueueDepth())))
+
+	deadline := time.Now().Add(time.Duration(c.Connector.FlushTimeout) * time.Second)
+
+	err = server.Flush(p, time.Until(deadline))
+	if err != nil {
+		log.Warningf("failed to flush the sinks, remaining records are lost unless delivered on close, %s", err.Error())
+	}
+
+	log.Debugf("shutting down the sinks")
 
-	err = p.Close()
+	err = closeBefore(p, deadline)
 	if err != nil {
-		log.Errf("failed to close Kafka producer, %s", err.Error())
+		log.Errf("failed to close sinks, %s", err.Error())
+	}
+
+	closeSpool()
+
+	if correlator != nil {
+		err = correlator.Close()
+		if err != nil {
+			log.Errf("failed to save open problems, %s", err.Error())
+		}
 	}
 
 	log.Infof("Server shut down, good bye!")
 }
 
+// closeBefore closes the sinks, giving up at the deadline so brokers that do not answer can not block the shutdown.
+// Records still queued when it gives up are lost.
+func closeBefore(p io.Closer, deadline time.Time) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- p.Close()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errs.New("sinks not closed before the flush timeout")
+	}
+}
+
+// replay sends the records spooled on a previous shutdown again, files with records that are not delivered
+// before the timeout are kept for the next start.
+func replay(dir string, p kafka.Producer, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	n, err := sink.Replay(ctx, dir, p)
+	if n > 0 {
+		log.Infof("sent %d spooled records", n)
+	}
+
+	if err != nil {
+		log.Warningf("failed to send spooled records, they are kept in %s, %s", dir, err.Error())
+	}
+}
+
+// spill makes the Kafka producers write the records they fail to deliver to a file sink in dir, so records still
+// queued on shutdown are kept. The returned function stops spilling and closes the sink.
+func spill(dir string, c *sink.Configuration, producers []*kafka.DefaultProducer) (func(), error) {
+	spool, err := sink.NewFile(&sink.Configuration{
+		FileDir:        dir,
+		FileMaxSize:    c.FileMaxSize,
+		FileMaxBackups: c.FileMaxBackups,
+	})
+	if err != nil {
+		return func() {}, err
+	}
+
+	spilled := metrics.GetCounter(spilledMetric)
+	before := spilled.Value()
+
+	for _, kp := range producers {
+		kp.SetSpill(func(kind string, r *kafka.Record) {
+			_, err := spool.Produce(context.Background(), sink.Kind(kind), r)
+			if err != nil {
+				log.Errf("failed to spool record for id: %s, %s", r.Key, err.Error())
+
+				return
+			}
+
+			spilled.Inc()
+		})
+	}
+
+	return func() {
+		for _, kp := range producers {
+			kp.SetSpill(nil)
+		}
+
+		if n := spilled.Value() - before; n > 0 {
+			log.Warningf("%d undelivered records written to %s", n, dir)
+		}
+
+		err := spool.Close()
+		if err != nil {
+			log.Errf("failed to close the spool, %s", err.Error())
+		}
+	}, nil
+}
+
+// newKafkaSink returns the Kafka sink of the Kafka section, with additional clusters of the KafkaClusters sections
+// the sink sends every record to all clusters. If a failover secondary is set, its KafkaClusters section is used
+// as the standby of the Kafka section instead.
+func newKafkaSink(ctx context.Context, c *configuration) (sink.Sink, []*kafka.DefaultProducer, error) {
+	p, err := kafka.NewProducer(ctx, "", &c.Kafka, c.Topics)
+	if err != nil {
+		return nil, nil, errs.Wrap(err, "failed to initialize kafka producer")
+	}
+
+	producers := []*kafka.DefaultProducer{p}
+
+	var primary sink.Sink = sink.NewKafka(p)
+
+	if c.Failover.Secondary != "" {
+		sc, ok := c.KafkaClusters[c.Failover.Secondary]
+		if !ok {
+			closeProducers(producers)
+
+			return nil, nil, errs.Errorf(
+				"failover secondary cluster %s has no KafkaClusters section", c.Failover.Secondary,
+			)
+		}
+
+		sp, err := kafka.NewProducer(ctx, c.Failover.Secondary, &sc, c.Topics)
+		if err != nil {
+			closeProducers(producers)
+
+			return nil, nil, errs.Wrapf(
+				err, "failed to initialize kafka producer of failover cluster %s", c.Failover.Secondary,
+			)
+		}
+
+		producers = append(producers, sp)
+		primary = sink.NewFailover(&c.Failover, "kafka", p, sp)
+	}
+
+	names := make([]string, 0, len(c.KafkaClusters))
+	for name := range c.KafkaClusters {
+		if name != c.Failover.Secondary {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return primary, producers, nil
+	}
+
+	sort.Strings(names)
+
+	members := []sink.Member{{Name: "kafka", Sink: primary, Delivery: c.Kafka.Delivery}}
+
+	for _, name := range names {
+		kc := c.KafkaClusters[name]
+
+		p, err = kafka.NewProducer(ctx, name, &kc, c.Topics)
+		if err != nil {
+			closeKafkaSinks(members)
+
+			return nil, nil, errs.Wrapf(err, "failed to initialize kafka producer of cluster %s", name)
+		}
+
+		producers = append(producers, p)
+		members = append(members, sink.Member{Name: name, Sink: sink.NewKafka(p), Delivery: kc.Delivery})
+	}
+
+	s, err := sink.NewFanOut(members)
+	if err != nil {
+		closeKafkaSinks(members)
+
+		return nil, nil, errs.Wrap(err, "failed to initialize kafka clusters")
+	}
+
+	return s, producers, nil
+}
+
+// closeKafkaSinks closes the sinks of the clusters, the failover sink closes both of its clusters.
+func closeKafkaSinks(members []sink.Member) {
+	for _, m := range members {
+		err := m.Sink.Close()
+		if err != nil {
+			log.Errf("failed to close Kafka producer of cluster %s, %s", m.Name, err.Error())
+		}
+	}
+}
+
+// watchKafkaHealth reports whether Kafka is reachable as the kafka.brokers health component, checking that at least one
+// cluster answers at the interval in the background, so a broker outage makes the connector not ready without
+// slowing down health requests. While ingestion is paused, for example during broker maintenance, an unreachable
+// Kafka is only degraded. The returned function stops the checks.
+func watchKafkaHealth(pause *server.Pause, producers []*kafka.DefaultProducer, interval time.Duration) func() {
+	if len(producers) == 0 {
+		return func() {}
+	}
+
+	var (
+		mu        sync.Mutex
+		reachable = map[string]bool{}
+	)
+
+	check := func() {
+		out := make(map[string]bool, len(producers))
+
+		for _, p := range producers {
+			err := p.Ping()
+			if err != nil {
+				log.Debugf("kafka cluster %s is not reachable, %s", clusterName(p), err.Error())
+			}
+
+			out[clusterName(p)] = err == nil
+		}
+
+		mu.Lock()
+		reachable = out
+		mu.Unlock()
+	}
+
+	health.Register(kafkaHealth, func() health.Report {
+		mu.Lock()
+		defer mu.Unlock()
+
+		clusters := make(map[string]any, len(reachable))
+		status := health.StatusDown
+
+		for name, ok := range reachable {
+			clusters[name] = ok
+
+			if ok {
+				status = health.StatusOK
+			}
+		}
+
+		// not checked yet.
+		if len(reachable) == 0 {
+			status = health.StatusOK
+		}
+
+		if status == health.StatusDown && pause.Status().Paused {
+			status = health.StatusDegraded
+		}
+
+		return health.Report{Status: status, Details: map[string]any{"reachable": clusters}}
+	})
+
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			check()
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		health.Unregister(kafkaHealth)
+	}
+}
+
+// clusterName returns the name of the cluster of the producer, as in the admin API.
+func clusterName(p *kafka.DefaultProducer) string {
+	if p.Name() == "" {
+		return "kafka"
+	}
+
+	return p.Name()
+}
+
+// notify sends the states to systemd, a failure is only logged as the connector works without it.
+func notify(states ...string) {
+	err := systemd.Notify(states...)
+	if err != nil {
+		log.Warningf("failed to notify systemd, %s", err.Error())
+	}
+}
+
+func closeProducers(producers []*kafka.DefaultProducer) {
+	for _, p := range producers {
+		err := p.Close()
+		if err != nil {
+			log.Errf("failed to close Kafka producer, %s", err.Error())
+		}
+	}
+}
+
 func waitExit(errsChan <-chan error) error {
 	sigs := createSigsChan()
 
@@ -174,6 +686,36 @@ func createSigsChan() chan os.Signal {
 	return sigs
 }
 
+// handlePauseSignals pauses ingestion on SIGUSR1 and resumes it on SIGUSR2, the returned function stops it.
+func handlePauseSignals(pause *server.Pause) func() {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == syscall.SIGUSR1 {
+					pause.Pause(server.PauseSignal, time.Time{})
+
+					continue
+				}
+
+				pause.Resume(server.PauseSignal)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
 func initLogger(logType, logFile string, debugLevel, logFileSize int) error {
 	err := log.Open(
 		getLogType(logType),
@@ -257,6 +799,16 @@ func getFlags(fs *flag.FlagSet) (*arguments, error) {
 	return a, nil
 }
 
+// watchFile calls reload whenever any of the files changes, the previous state is kept if reload fails.
+func watchFile(files []string, interval int, name string, reload func() error) *watch.Watcher {
+	return watch.New(files, time.Duration(interval)*time.Second, func() {
+		err := reload()
+		if err != nil {
+			log.Errf("failed to reload %s, keeping previous %s, %s", name, name, err.Error())
+		}
+	})
+}
+
 //nolint:revive //fatalExit is called everywhere where os.Exit(1) is needed.
 func fatalExit(message string, err error) {
 	fmt.Fprintf(
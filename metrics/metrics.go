@@ -0,0 +1,126 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var defaultRegistry = &registry{metrics: map[string]any{}}
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Gauge is a metric that can increase and decrease.
+type Gauge struct {
+	v atomic.Int64
+}
+
+type registry struct {
+	mu      sync.Mutex
+	metrics map[string]any
+}
+
+// GetCounter returns the counter registered with the name, registering a new one if it does not exist.
+func GetCounter(name string) *Counter {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	if c, ok := defaultRegistry.metrics[name].(*Counter); ok {
+		return c
+	}
+
+	c := &Counter{}
+	defaultRegistry.metrics[name] = c
+
+	return c
+}
+
+// GetGauge returns the gauge registered with the name, registering a new one if it does not exist.
+func GetGauge(name string) *Gauge {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	if g, ok := defaultRegistry.metrics[name].(*Gauge); ok {
+		return g
+	}
+
+	g := &Gauge{}
+	defaultRegistry.metrics[name] = g
+
+	return g
+}
+
+// SetFunc registers a metric which value is computed by f on every snapshot, replacing any previous one.
+func SetFunc(name string, f func() any) {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	defaultRegistry.metrics[name] = f
+}
+
+// Snapshot returns current values of all registered metrics.
+func Snapshot() map[string]any {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+
+	out := make(map[string]any, len(defaultRegistry.metrics))
+
+	for name, m := range defaultRegistry.metrics {
+		switch v := m.(type) {
+		case *Counter:
+			out[name] = v.Value()
+		case *Gauge:
+			out[name] = v.Value()
+		case func() any:
+			out[name] = v()
+		}
+	}
+
+	return out
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+// Value returns the current counter value.
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+// Set sets the gauge to n.
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Add adds n to the gauge, n can be negative.
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+// Value returns the current gauge value.
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
@@ -0,0 +1,56 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	c := GetCounter("test.snapshot.counter")
+	// the registry is global, so the counter keeps its value when the test is repeated.
+	before := c.Value()
+
+	c.Inc()
+	c.Add(2)
+
+	if GetCounter("test.snapshot.counter") != c {
+		t.Fatalf("GetCounter() expected the registered counter to be returned")
+	}
+
+	g := GetGauge("test.snapshot.gauge")
+	g.Set(10)
+	g.Add(-3)
+
+	SetFunc("test.snapshot.func", func() any { return 0.5 })
+
+	got := Snapshot()
+
+	want := map[string]any{
+		"test.snapshot.counter": before + 3,
+		"test.snapshot.gauge":   int64(7),
+		"test.snapshot.func":    0.5,
+	}
+
+	for name, v := range want {
+		if diff := cmp.Diff(v, got[name]); diff != "" {
+			t.Fatalf("Snapshot() %s = %s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+const (
+	scopeName = "zabbix-kafka-connector"
+
+	valueTypeFloat    = 0
+	valueTypeUnsigned = 3
+
+	eventValueProblem = 1
+)
+
+// Protobuf wire types used by the OTLP messages.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// OTLP severity numbers, see opentelemetry/proto/logs/v1/logs.proto.
+const (
+	severityUnspecified = 0
+	severityInfo        = 9
+	severityWarn        = 13
+	severityError       = 17
+	severityError3      = 19
+	severityFatal       = 21
+)
+
+// Host is a Zabbix host as exported by Zabbix server streaming.
+type Host struct {
+	Host string `json:"host"`
+	Name string `json:"name"`
+}
+
+// Tag is a Zabbix item or event tag.
+type Tag struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// Item is a single Zabbix item value as exported by Zabbix server streaming.
+type Item struct {
+	Host   Host            `json:"host"`
+	Groups []string        `json:"groups"`
+	Tags   []Tag           `json:"item_tags"` //nolint:tagliatelle // Zabbix export field name
+	Value  json.RawMessage `json:"value"`
+	Name   string          `json:"name"`
+	ItemID uint64          `json:"itemid"`
+	Clock  int64           `json:"clock"`
+	NS     int64           `json:"ns"`
+	Type   int             `json:"type"`
+}
+
+// Event is a single Zabbix problem or recovery event as exported by Zabbix server streaming.
+type Event struct {
+	Hosts    []Host   `json:"hosts"`
+	Groups   []string `json:"groups"`
+	Tags     []Tag    `json:"tags"`
+	Name     string   `json:"name"`
+	EventID  uint64   `json:"eventid"`
+	PEventID uint64   `json:"p_eventid"` //nolint:tagliatelle // Zabbix export field name
+	Clock    int64    `json:"clock"`
+	NS       int64    `json:"ns"`
+	Value    int      `json:"value"`
+	Severity int      `json:"severity"`
+}
+
+type resource struct {
+	host   Host
+	groups []string
+}
+
+type metric struct {
+	name   string
+	points [][]byte
+}
+
+type resourceMetrics struct {
+	resource
+	metrics []*metric
+	byName  map[string]*metric
+}
+
+type resourceLogs struct {
+	resource
+	records [][]byte
+}
+
+// EncodeMetrics encodes numeric item values into a protobuf ExportMetricsServiceRequest.
+// Values of the same host are grouped into one resource, values of the same item name into one gauge.
+// Items with non-numeric value types are skipped, numeric values that can not be parsed are skipped too,
+// logged and counted in the otlp.items.invalid metric. Nil is returned if no value could be encoded.
+func EncodeMetrics(items []Item) []byte {
+	var (
+		order  []*resourceMetrics
+		byHost = map[string]*resourceMetrics{}
+	)
+
+	for _, i := range items {
+		point, ok := dataPoint(&i)
+		if !ok {
+			continue
+		}
+
+		rm, ok := byHost[i.Host.Host]
+		if !ok {
+			rm = &resourceMetrics{
+				resource: resource{i.Host, i.Groups},
+				byName:   map[string]*metric{},
+			}
+			byHost[i.Host.Host] = rm
+			order = append(order, rm)
+		}
+
+		m, ok := rm.byName[i.Name]
+		if !ok {
+			m = &metric{name: i.Name}
+			rm.byName[i.Name] = m
+			rm.metrics = append(rm.metrics, m)
+		}
+
+		m.points = append(m.points, point)
+	}
+
+	if len(order) == 0 {
+		return nil
+	}
+
+	var out []byte
+
+	for _, rm := range order {
+		scope := appendMessage(nil, 1, encodeScope())
+
+		for _, m := range rm.metrics {
+			var gauge []byte
+			for _, p := range m.points {
+				gauge = appendMessage(gauge, 1, p)
+			}
+
+			mb := appendString(nil, 1, m.name)
+			mb = appendMessage(mb, 5, gauge)
+			scope = appendMessage(scope, 2, mb)
+		}
+
+		b := appendMessage(nil, 1, rm.encode())
+		b = appendMessage(b, 2, scope)
+		out = appendMessage(out, 1, b)
+	}
+
+	return out
+}
+
+// EncodeLogs encodes events into a protobuf ExportLogsServiceRequest.
+// Events are grouped into resources by their first host, the event name is used as the log body.
+func EncodeLogs(events []Event) []byte {
+	var (
+		order  []*resourceLogs
+		byHost = map[string]*resourceLogs{}
+	)
+
+	for _, e := range events {
+		var h Host
+		if len(e.Hosts) > 0 {
+			h = e.Hosts[0]
+		}
+
+		rl, ok := byHost[h.Host]
+		if !ok {
+			rl = &resourceLogs{resource: resource{h, e.Groups}}
+			byHost[h.Host] = rl
+			order = append(order, rl)
+		}
+
+		rl.records = append(rl.records, logRecord(&e))
+	}
+
+	var out []byte
+
+	for _, rl := range order {
+		scope := appendMessage(nil, 1, encodeScope())
+		for _, r := range rl.records {
+			scope = appendMessage(scope, 2, r)
+		}
+
+		b := appendMessage(nil, 1, rl.encode())
+		b = appendMessage(b, 2, scope)
+		out = appendMessage(out, 1, b)
+	}
+
+	return out
+}
+
+// Severity maps Zabbix event severity to OTLP severity number and text.
+func Severity(e *Event) (int, string) {
+	if e.Value != eventValueProblem {
+		return severityInfo, "Resolved"
+	}
+
+	switch e.Severity {
+	case 1:
+		return severityInfo, "Information"
+	case 2:
+		return severityWarn, "Warning"
+	case 3:
+		return severityError, "Average"
+	case 4:
+		return severityError3, "High"
+	case 5:
+		return severityFatal, "Disaster"
+	default:
+		return severityUnspecified, "Not classified"
+	}
+}
+
+func (r *resource) encode() []byte {
+	var b []byte
+
+	if r.host.Host != "" {
+		b = appendMessage(b, 1, keyValue("host.name", stringValue(r.host.Host)))
+	}
+
+	if r.host.Name != "" {
+		b = appendMessage(b, 1, keyValue("zabbix.host.name", stringValue(r.host.Name)))
+	}
+
+	if len(r.groups) > 0 {
+		var arr []byte
+		for _, g := range r.groups {
+			arr = appendMessage(arr, 1, stringValue(g))
+		}
+
+		b = appendMessage(b, 1, keyValue("zabbix.host.groups", appendMessage(nil, 5, arr)))
+	}
+
+	return b
+}
+
+func dataPoint(i *Item) ([]byte, bool) {
+	var b []byte
+
+	raw := trimQuotes(string(i.Value))
+
+	switch i.Type {
+	case valueTypeFloat:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			skipInvalid(i, err)
+
+			return nil, false
+		}
+
+		b = appendFixed64(b, 4, math.Float64bits(f))
+	case valueTypeUnsigned:
+		u, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			skipInvalid(i, err)
+
+			return nil, false
+		}
+
+		if u > math.MaxInt64 {
+			b = appendFixed64(b, 4, math.Float64bits(float64(u)))
+		} else {
+			b = appendFixed64(b, 6, u)
+		}
+	default:
+		return nil, false
+	}
+
+	b = appendFixed64(b, 3, timestamp(i.Clock, i.NS))
+	b = appendMessage(b, 7, keyValue("zabbix.item.id", intValue(i.ItemID)))
+
+	for _, t := range i.Tags {
+		b = appendMessage(b, 7, keyValue("zabbix.tag."+t.Tag, stringValue(t.Value)))
+	}
+
+	return b, true
+}
+
+// skipInvalid logs and counts a numeric item value that can not be encoded.
+func skipInvalid(i *Item, err error) {
+	metrics.GetCounter("otlp.items.invalid").Inc()
+
+	log.Warningf("skipping invalid value of item with ID %d, %s", i.ItemID, err.Error())
+}
+
+func logRecord(e *Event) []byte {
+	num, text := Severity(e)
+	ts := timestamp(e.Clock, e.NS)
+
+	b := appendFixed64(nil, 1, ts)
+	b = appendVarint(b, 2, uint64(num))
+	b = appendString(b, 3, text)
+
+	if e.Name != "" {
+		b = appendMessage(b, 5, stringValue(e.Name))
+	}
+
+	b = appendMessage(b, 6, keyValue("zabbix.event.id", intValue(e.EventID)))
+	b = appendMessage(b, 6, keyValue("zabbix.event.value", intValue(uint64(e.Value))))
+
+	if e.PEventID != 0 {
+		b = appendMessage(b, 6, keyValue("zabbix.problem.event.id", intValue(e.PEventID)))
+	}
+
+	for _, t := range e.Tags {
+		b = appendMessage(b, 6, keyValue("zabbix.tag."+t.Tag, stringValue(t.Value)))
+	}
+
+	return appendFixed64(b, 11, ts)
+}
+
+func encodeScope() []byte {
+	return appendString(nil, 1, scopeName)
+}
+
+func keyValue(key string, value []byte) []byte {
+	b := appendString(nil, 1, key)
+
+	return appendMessage(b, 2, value)
+}
+
+func stringValue(s string) []byte {
+	return appendString(nil, 1, s)
+}
+
+func intValue(v uint64) []byte {
+	return appendVarint(nil, 3, v)
+}
+
+func timestamp(clock, ns int64) uint64 {
+	return uint64(clock)*uint64(1e9) + uint64(ns)
+}
+
+func trimQuotes(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+
+	return binary.AppendUvarint(b, v)
+}
+
+func appendFixed64(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireFixed64)
+
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+
+	return append(b, s...)
+}
+
+func appendMessage(b []byte, field int, m []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(m)))
+
+	return append(b, m...)
+}
@@ -0,0 +1,428 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"github.com/google/go-cmp/cmp"
+)
+
+type field struct {
+	num   int
+	value uint64
+	data  []byte
+}
+
+func TestEncodeMetrics(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		items []Item
+	}
+
+	tests := []struct {
+		name          string
+		args          args
+		wantResources int
+		wantHosts     []string
+		wantPoints    int
+		wantNil       bool
+	}{
+		{
+			"+valid",
+			args{
+				[]Item{
+					{
+						Host:   Host{"host_one", "Host one"},
+						Groups: []string{"Linux servers"},
+						Name:   "CPU load",
+						ItemID: 1,
+						Clock:  1700000000,
+						Type:   valueTypeFloat,
+						Value:  json.RawMessage("0.5"),
+					},
+					{
+						Host:  Host{"host_one", "Host one"},
+						Name:  "CPU load",
+						Type:  valueTypeFloat,
+						Value: json.RawMessage("0.7"),
+					},
+					{
+						Host:  Host{"host_two", "Host two"},
+						Name:  "Free memory",
+						Type:  valueTypeUnsigned,
+						Value: json.RawMessage("1024"),
+					},
+				},
+			},
+			2,
+			[]string{"host_one", "host_two"},
+			3,
+			false,
+		},
+		{
+			"+nonNumericSkipped",
+			args{
+				[]Item{
+					{Host: Host{"foo", "Foo"}, Name: "Log", Type: 2, Value: json.RawMessage(`"text"`)},
+					{Host: Host{"foo", "Foo"}, Name: "Uptime", Type: valueTypeUnsigned, Value: json.RawMessage("42")},
+				},
+			},
+			1,
+			[]string{"foo"},
+			1,
+			false,
+		},
+		{
+			"-onlyNonNumeric",
+			args{
+				[]Item{
+					{Host: Host{"foo", "Foo"}, Name: "Text", Type: 4, Value: json.RawMessage(`"text"`)},
+				},
+			},
+			0,
+			nil,
+			0,
+			true,
+		},
+		{
+			"-invalidValue",
+			args{
+				[]Item{
+					{Host: Host{"foo", "Foo"}, Name: "Load", Type: valueTypeFloat, Value: json.RawMessage(`"abc"`)},
+				},
+			},
+			0,
+			nil,
+			0,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := EncodeMetrics(tt.args.items)
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("EncodeMetrics() expected nil: %t, but got: %v", tt.wantNil, got)
+			}
+
+			resources := decode(t, got)
+			if len(resources) != tt.wantResources {
+				t.Fatalf("EncodeMetrics() expected %d resources, but got: %d", tt.wantResources, len(resources))
+			}
+
+			var (
+				hosts  []string
+				points int
+			)
+
+			for _, rm := range resources {
+				rf := decode(t, rm.data)
+				hosts = append(hosts, attribute(t, decode(t, rf[0].data), "host.name"))
+
+				for _, m := range decode(t, rf[1].data)[1:] {
+					gauge := decode(t, decode(t, m.data)[1].data)
+					points += len(gauge)
+				}
+			}
+
+			if diff := cmp.Diff(tt.wantHosts, hosts); diff != "" {
+				t.Fatalf("EncodeMetrics() = %s", diff)
+			}
+
+			if points != tt.wantPoints {
+				t.Fatalf("EncodeMetrics() expected %d data points, but got: %d", tt.wantPoints, points)
+			}
+		})
+	}
+}
+
+func TestEncodeLogs(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		events []Event
+	}
+
+	tests := []struct {
+		name         string
+		args         args
+		wantSeverity []uint64
+		wantBodies   []string
+	}{
+		{
+			"+problemAndRecovery",
+			args{
+				[]Event{
+					{
+						Hosts:    []Host{{"foo", "Foo"}},
+						Name:     "High CPU",
+						EventID:  10,
+						Value:    1,
+						Severity: 4,
+					},
+					{
+						EventID:  11,
+						PEventID: 10,
+						Value:    0,
+					},
+				},
+			},
+			[]uint64{severityError3, severityInfo},
+			[]string{"High CPU", ""},
+		},
+		{
+			"+notClassified",
+			args{
+				[]Event{
+					{Hosts: []Host{{"foo", "Foo"}}, Name: "Something", EventID: 1, Value: 1},
+				},
+			},
+			[]uint64{severityUnspecified},
+			[]string{"Something"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				severities []uint64
+				bodies     []string
+			)
+
+			for _, rl := range decode(t, EncodeLogs(tt.args.events)) {
+				scope := decode(t, decode(t, rl.data)[1].data)
+
+				for _, r := range scope[1:] {
+					var body string
+
+					for _, f := range decode(t, r.data) {
+						switch f.num {
+						case 2:
+							severities = append(severities, f.value)
+						case 5:
+							body = string(decode(t, f.data)[0].data)
+						}
+					}
+
+					bodies = append(bodies, body)
+				}
+			}
+
+			if diff := cmp.Diff(tt.wantSeverity, severities); diff != "" {
+				t.Fatalf("EncodeLogs() severity = %s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantBodies, bodies); diff != "" {
+				t.Fatalf("EncodeLogs() body = %s", diff)
+			}
+		})
+	}
+}
+
+func Test_dataPoint(t *testing.T) {
+	t.Parallel()
+
+	type args struct {
+		item Item
+	}
+
+	tests := []struct {
+		name       string
+		args       args
+		wantField  int
+		wantValue  uint64
+		wantTimeNS uint64
+		wantOk     bool
+	}{
+		{
+			"+float",
+			args{Item{Type: valueTypeFloat, Value: json.RawMessage("1.5"), Clock: 2, NS: 3}},
+			4,
+			math.Float64bits(1.5),
+			2000000003,
+			true,
+		},
+		{
+			"+unsigned",
+			args{Item{Type: valueTypeUnsigned, Value: json.RawMessage("18446744073709551"), Clock: 1}},
+			6,
+			18446744073709551,
+			1000000000,
+			true,
+		},
+		{
+			"+unsignedOverflow",
+			args{Item{Type: valueTypeUnsigned, Value: json.RawMessage("18446744073709551615")}},
+			4,
+			math.Float64bits(18446744073709551615),
+			0,
+			true,
+		},
+		{
+			"+quoted",
+			args{Item{Type: valueTypeUnsigned, Value: json.RawMessage(`"5"`)}},
+			6,
+			5,
+			0,
+			true,
+		},
+		{
+			"-text",
+			args{Item{Type: 4, Value: json.RawMessage(`"foo"`)}},
+			0,
+			0,
+			0,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := dataPoint(&tt.args.item)
+			if ok != tt.wantOk {
+				t.Fatalf("dataPoint() expected ok: %t, but got: %t", tt.wantOk, ok)
+			}
+
+			if !ok {
+				return
+			}
+
+			fields := decode(t, got)
+
+			if fields[0].num != tt.wantField || fields[0].value != tt.wantValue {
+				t.Fatalf(
+					"dataPoint() expected field %d with value %d, but got field %d with value %d",
+					tt.wantField, tt.wantValue, fields[0].num, fields[0].value,
+				)
+			}
+
+			if fields[1].value != tt.wantTimeNS {
+				t.Fatalf("dataPoint() expected time: %d, but got: %d", tt.wantTimeNS, fields[1].value)
+			}
+		})
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		event    Event
+		wantNum  int
+		wantText string
+	}{
+		{"+information", Event{Value: 1, Severity: 1}, severityInfo, "Information"},
+		{"+warning", Event{Value: 1, Severity: 2}, severityWarn, "Warning"},
+		{"+average", Event{Value: 1, Severity: 3}, severityError, "Average"},
+		{"+high", Event{Value: 1, Severity: 4}, severityError3, "High"},
+		{"+disaster", Event{Value: 1, Severity: 5}, severityFatal, "Disaster"},
+		{"+notClassified", Event{Value: 1}, severityUnspecified, "Not classified"},
+		{"+recovery", Event{Value: 0, Severity: 5}, severityInfo, "Resolved"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			num, text := Severity(&tt.event)
+			if num != tt.wantNum || text != tt.wantText {
+				t.Fatalf("Severity() = %d %q, want %d %q", num, text, tt.wantNum, tt.wantText)
+			}
+		})
+	}
+}
+
+// decode splits a protobuf message into its top level fields.
+func decode(t *testing.T, b []byte) []field {
+	t.Helper()
+
+	var out []field
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("failed to decode field tag")
+		}
+
+		b = b[n:]
+		f := field{num: int(tag >> 3)}
+
+		switch tag & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			f.data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+
+		out = append(out, f)
+	}
+
+	return out
+}
+
+// attribute returns the string value of a key value attribute.
+func attribute(t *testing.T, fields []field, key string) string {
+	t.Helper()
+
+	for _, f := range fields {
+		kv := decode(t, f.data)
+		if string(kv[0].data) == key {
+			return string(decode(t, kv[1].data)[0].data)
+		}
+	}
+
+	return ""
+}
+
+func TestEncodeMetrics_invalidCounted(t *testing.T) {
+	t.Parallel()
+
+	invalid := metrics.GetCounter("otlp.items.invalid")
+	before := invalid.Value()
+
+	got := EncodeMetrics([]Item{{Name: "Uptime", Type: valueTypeUnsigned, Value: json.RawMessage("-1")}})
+	if got != nil {
+		t.Fatalf("EncodeMetrics() expected nil for an invalid value, but got: %v", got)
+	}
+
+	if after := invalid.Value(); after <= before {
+		t.Fatalf("EncodeMetrics() expected otlp.items.invalid counted, but got: %d", after)
+	}
+}
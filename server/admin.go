@@ -0,0 +1,306 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/auth"
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// errInvalidAdminRequest is wrapped by admin API errors caused by the request.
+var errInvalidAdminRequest = errs.New("invalid admin request")
+
+const (
+	defaultFlushTimeout = 10 * time.Second
+	flushPollInterval   = 10 * time.Millisecond
+)
+
+// Admin provides the state of the connector and the actions outside of the server to the admin API.
+type Admin interface {
+	// Config returns the effective configuration with secrets masked.
+	Config() any
+	// Status returns the status of the producers.
+	Status() any
+	// Spool returns the status of the spool of undelivered records, nil if no spool is configured.
+	Spool() any
+	// ReloadFiles reloads the files the connector watches for changes, the configuration file is not read again.
+	ReloadFiles() error
+}
+
+type logLevelRequest struct {
+	Level *int `json:"level"`
+}
+
+// adminRoutes registers the admin API, every path requires the admin permission. The routes are registered only
+// if clients are authenticated, as the admin API changes the state of the connector.
+func (h handler) adminRoutes(router *http.ServeMux) {
+	routes := []struct {
+		path    string
+		methods []string
+		handler func(w http.ResponseWriter, r *http.Request) error
+	}{
+		{"/api/v1/admin/config", []string{http.MethodGet}, h.adminConfig},
+		{"/api/v1/admin/status", []string{http.MethodGet}, h.adminStatus},
+		{"/api/v1/admin/loglevel", []string{http.MethodGet, http.MethodPut}, h.adminLogLevel},
+		{"/api/v1/admin/pause", []string{http.MethodPost}, h.adminPause},
+		{"/api/v1/admin/resume", []string{http.MethodPost}, h.adminResume},
+		{"/api/v1/admin/flush", []string{http.MethodPost}, h.adminFlush},
+		{"/api/v1/admin/reload-files", []string{http.MethodPost}, h.adminReloadFiles},
+	}
+
+	if !h.authenticated() {
+		log.Warningf(
+			"admin API disabled, it requires Connector.BearerToken, Connector.TokenFile or Connector.ClientCertAllowFile",
+		)
+
+		return
+	}
+
+	for _, route := range routes {
+		router.HandleFunc(
+			route.path,
+			allowedMethodsMW(
+				route.methods,
+				h.accessMW(
+					auth.PermissionAdmin,
+					errorHandlingMW(route.handler),
+				),
+			),
+		)
+	}
+}
+
+// pauseMW rejects ingest requests while ingestion is paused, Zabbix server retries them later.
+func (h handler) pauseMW(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.pause.Status().Paused {
+			next(w, r)
+
+			return
+		}
+
+		log.Debugf("ingestion paused, rejecting request from %s", clientName(r))
+
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(h.pause.RetryAfter(h.queueRetryAfter))))
+
+		write(
+			w,
+			http.StatusServiceUnavailable,
+			jsonResponse(map[string]string{"response": "fail", "error": "ingestion is paused"}),
+		)
+	}
+}
+
+func (h handler) adminConfig(w http.ResponseWriter, _ *http.Request) error {
+	if h.admin == nil {
+		return writeJSON(w, map[string]any{})
+	}
+
+	return writeJSON(w, h.admin.Config())
+}
+
+func (h handler) adminStatus(w http.ResponseWriter, _ *http.Request) error {
+	status, _ := health.Snapshot()
+
+	out := map[string]any{
+		"pause":       h.pause.Status(),
+		"queue_depth": h.producer.QueueDepth(),
+		"health":      status,
+	}
+
+	if h.admin != nil {
+		out["producers"] = h.admin.Status()
+
+		if spool := h.admin.Spool(); spool != nil {
+			out["spool"] = spool
+		}
+	}
+
+	return writeJSON(w, out)
+}
+
+func (h handler) adminLogLevel(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPut {
+		var req logLevelRequest
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return errs.Wrapf(errInvalidAdminRequest, "failed to decode log level request, %s", err.Error())
+		}
+
+		if req.Level == nil || *req.Level < log.Info || *req.Level > log.Trace {
+			return errs.Wrapf(errInvalidAdminRequest, "log level must be from %d to %d", log.Info, log.Trace)
+		}
+
+		from := logLevel()
+		setLogLevel(*req.Level)
+
+		log.Infof("log level changed from %d to %d by %s", from, *req.Level, clientName(r))
+	}
+
+	return writeJSON(w, map[string]int{"level": logLevel()})
+}
+
+// adminPause pauses ingestion, until the time in the until query parameter (RFC 3339) or for the duration
+// in the duration query parameter (for example 30m), if any of them is set.
+func (h handler) adminPause(w http.ResponseWriter, r *http.Request) error {
+	until, err := pauseEnd(r, time.Now())
+	if err != nil {
+		return err
+	}
+
+	h.pause.Pause(PauseAdmin+" "+clientName(r), until)
+
+	return writeSuccess(w)
+}
+
+func (h handler) adminResume(w http.ResponseWriter, r *http.Request) error {
+	h.pause.Resume(PauseAdmin + " " + clientName(r))
+
+	return writeSuccess(w)
+}
+
+// adminFlush waits until all queued records are delivered or fail like the shutdown does, at most for the timeout
+// query parameter in seconds.
+func (h handler) adminFlush(w http.ResponseWriter, r *http.Request) error {
+	timeout := defaultFlushTimeout
+
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 3600 {
+			return errs.Wrapf(errInvalidAdminRequest, "timeout must be from 1 to 3600 seconds, got %q", s)
+		}
+
+		timeout = time.Duration(n) * time.Second
+	}
+
+	log.Infof("flush requested by %s", clientName(r))
+
+	err := flush(r.Context(), h.producer, timeout)
+	if err != nil {
+		return err
+	}
+
+	return writeSuccess(w)
+}
+
+func (h handler) adminReloadFiles(w http.ResponseWriter, r *http.Request) error {
+	if h.admin == nil {
+		return writeSuccess(w)
+	}
+
+	log.Infof("reload of watched files requested by %s", clientName(r))
+
+	err := h.admin.ReloadFiles()
+	if err != nil {
+		return errs.Wrap(err, "failed to reload")
+	}
+
+	return writeSuccess(w)
+}
+
+// pauseEnd returns the end time of a pause requested through the admin API, zero if it has none.
+func pauseEnd(r *http.Request, now time.Time) (time.Time, error) {
+	q := r.URL.Query()
+
+	if s := q.Get("until"); s != "" {
+		until, err := time.Parse(time.RFC3339, s)
+		if err != nil || !until.After(now) {
+			return time.Time{}, errs.Wrapf(errInvalidAdminRequest, "until must be a future RFC 3339 time, got %q", s)
+		}
+
+		return until, nil
+	}
+
+	if s := q.Get("duration"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return time.Time{}, errs.Wrapf(errInvalidAdminRequest, "duration must be positive, got %q", s)
+		}
+
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// waitFlushed returns once the queue is empty or the context is done.
+func waitFlushed(ctx context.Context, depth func() int) error {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for depth() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck // wrapped by the caller
+		}
+	}
+
+	return nil
+}
+
+// logLevel returns the current log level, the logger only tells whether a level is logged.
+func logLevel() int {
+	level := log.None
+
+	for l := log.Info; l <= log.Trace; l++ {
+		if log.CheckLogLevel(l) {
+			level = l
+		}
+	}
+
+	return level
+}
+
+// setLogLevel steps the log level to the requested one, as the logger can only increase or decrease it.
+func setLogLevel(level int) {
+	for logLevel() < level {
+		if !log.IncreaseLogLevel() {
+			return
+		}
+	}
+
+	for logLevel() > level {
+		if !log.DecreaseLogLevel() {
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return errs.Wrap(err, "failed to marshal response")
+	}
+
+	write(w, http.StatusOK, string(out))
+
+	return nil
+}
+
+func writeSuccess(w http.ResponseWriter) error {
+	write(w, http.StatusOK, jsonResponse(map[string]string{"response": "success"}))
+
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.zabbix.com/ap/plugin-support/log"
+	"git.zabbix.com/ap/plugin-support/zbxnet"
+)
+
+var _ Admin = &mockAdmin{}
+
+type mockAdmin struct {
+	reloaded  int
+	reloadErr error
+}
+
+func (m *mockAdmin) Config() any {
+	return map[string]any{"Kafka": map[string]any{"Password": "******"}}
+}
+
+func (m *mockAdmin) Status() any {
+	return map[string]any{"kafka": map[string]int{"queue_depth": 3}}
+}
+
+func (m *mockAdmin) Spool() any {
+	return map[string]any{"dir": "/var/spool", "size": 10, "spilled": 2}
+}
+
+func (m *mockAdmin) ReloadFiles() error {
+	m.reloaded++
+
+	return m.reloadErr
+}
+
+func newAdminRouter(t *testing.T, p *mockProducer, a Admin) http.Handler {
+	t.Helper()
+
+	ips, err := zbxnet.GetAllowedPeers("192.0.2.1")
+	if err != nil {
+		t.Fatalf("failed to parse allowed peers: %s", err.Error())
+	}
+
+	return NewRouter(p, "secret", ips, &Options{Admin: a})
+}
+
+func adminRequest(router http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+
+	router.ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_handler_admin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		reloadErr error
+		wantCode  int
+		wantBody  string
+	}{
+		{"+config", http.MethodGet, "/api/v1/admin/config", nil, http.StatusOK, `{"Kafka":{"Password":"******"}}`},
+		{"+status", http.MethodGet, "/api/v1/admin/status", nil, http.StatusOK, `"producers":{"kafka"`},
+		{"+statusPaused", http.MethodGet, "/api/v1/admin/status", nil, http.StatusOK, `"paused":false`},
+		{"+statusSpool", http.MethodGet, "/api/v1/admin/status", nil, http.StatusOK, `"spool":{"dir":"/var/spool"`},
+		{"+flush", http.MethodPost, "/api/v1/admin/flush", nil, http.StatusOK, `"success"`},
+		{"+reload", http.MethodPost, "/api/v1/admin/reload-files", nil, http.StatusOK, `"success"`},
+		{
+			"-reload",
+			http.MethodPost,
+			"/api/v1/admin/reload-files",
+			errors.New("bad token file"),
+			http.StatusInternalServerError,
+			"Failed to reload: bad token file.",
+		},
+		{"-flushTimeout", http.MethodPost, "/api/v1/admin/flush?timeout=0", nil, http.StatusBadRequest, "Timeout must"},
+		{"+pauseDuration", http.MethodPost, "/api/v1/admin/pause?duration=30m", nil, http.StatusOK, `"success"`},
+		{
+			"+pauseUntil",
+			http.MethodPost,
+			"/api/v1/admin/pause?until=2999-01-01T00:00:00Z",
+			nil,
+			http.StatusOK,
+			`"success"`,
+		},
+		{
+			"-pauseUntilPassed",
+			http.MethodPost,
+			"/api/v1/admin/pause?until=2001-01-01T00:00:00Z",
+			nil,
+			http.StatusBadRequest,
+			"Until must be a future RFC 3339 time",
+		},
+		{"-pauseDuration", http.MethodPost, "/api/v1/admin/pause?duration=-1s", nil, http.StatusBadRequest, "Duration must"},
+		{"-method", http.MethodGet, "/api/v1/admin/reload-files", nil, http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := &mockAdmin{reloadErr: tt.reloadErr}
+			router := newAdminRouter(t, &mockProducer{}, a)
+
+			w := adminRequest(router, tt.method, tt.target, "")
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("admin API expected status code: %d, but got: %d, %s", tt.wantCode, w.Code, w.Body.String())
+			}
+
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Fatalf("admin API expected response to contain: %s, but got: %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_handler_admin_unauthorized(t *testing.T) {
+	t.Parallel()
+
+	router := newAdminRouter(t, &mockProducer{}, &mockAdmin{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/pause", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("admin API expected status code: %d, but got: %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func Test_handler_admin_unauthenticated(t *testing.T) {
+	t.Parallel()
+
+	ips, err := zbxnet.GetAllowedPeers("192.0.2.1")
+	if err != nil {
+		t.Fatalf("failed to parse allowed peers: %s", err.Error())
+	}
+
+	p := &mockProducer{}
+	router := NewRouter(p, "", ips, &Options{Admin: &mockAdmin{}})
+
+	for _, path := range []string{"/api/v1/admin/pause", "/api/v1/admin/reload-files", "/api/v1/admin/flush"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("admin API %s expected status code without authentication: %d, but got: %d",
+				path, http.StatusNotFound, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		http.MethodPost, "/api/v1/items", strings.NewReader(getRequestString([]map[string]any{{"itemid": 1}})),
+	)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated || p.called != 1 {
+		t.Fatalf("ingest expected status code without authentication: %d, but got: %d", http.StatusCreated, w.Code)
+	}
+}
+
+func Test_handler_adminFlush_queued(t *testing.T) {
+	t.Parallel()
+
+	router := newAdminRouter(t, &mockProducer{depth: 2}, &mockAdmin{})
+
+	w := adminRequest(router, http.MethodPost, "/api/v1/admin/flush?timeout=1", "")
+
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "2 records still queued") {
+		t.Fatalf("admin flush expected queued records to time out, but got: %d, %s", w.Code, w.Body.String())
+	}
+}
+
+func Test_handler_adminPause(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{}
+	router := newAdminRouter(t, p, &mockAdmin{})
+
+	items := func() *httptest.ResponseRecorder {
+		return adminRequest(
+			router, http.MethodPost, "/api/v1/items", getRequestString([]map[string]any{{"itemid": 1}}),
+		)
+	}
+
+	if w := adminRequest(router, http.MethodPost, "/api/v1/admin/pause", ""); w.Code != http.StatusOK {
+		t.Fatalf("admin pause expected status code: %d, but got: %d", http.StatusOK, w.Code)
+	}
+
+	w := items()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("paused ingest expected 503 with Retry-After, but got: %d, %v", w.Code, w.Header())
+	}
+
+	if w := adminRequest(router, http.MethodGet, "/api/v1/admin/status", ""); !strings.Contains(
+		w.Body.String(), `"paused":true`,
+	) {
+		t.Fatalf("admin status expected paused, but got: %s", w.Body.String())
+	}
+
+	if w := adminRequest(router, http.MethodPost, "/api/v1/admin/resume", ""); w.Code != http.StatusOK {
+		t.Fatalf("admin resume expected status code: %d, but got: %d", http.StatusOK, w.Code)
+	}
+
+	if w := items(); w.Code != http.StatusCreated || p.called != 1 {
+		t.Fatalf("resumed ingest expected status code: %d, but got: %d", http.StatusCreated, w.Code)
+	}
+}
+
+//nolint:paralleltest // changes the global log level
+func Test_handler_adminLogLevel(t *testing.T) {
+	router := newAdminRouter(t, &mockProducer{}, &mockAdmin{})
+
+	initial := logLevel()
+	defer setLogLevel(initial)
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"+set", http.MethodPut, `{"level":4}`, http.StatusOK, `{"level":4}`},
+		{"+get", http.MethodGet, "", http.StatusOK, `{"level":4}`},
+		{"+lower", http.MethodPut, `{"level":1}`, http.StatusOK, `{"level":1}`},
+		{"-range", http.MethodPut, `{"level":9}`, http.StatusBadRequest, "Log level must be from 0 to 5"},
+		{"-missing", http.MethodPut, `{}`, http.StatusBadRequest, "Log level must be from 0 to 5"},
+		{"-malformed", http.MethodPut, `{`, http.StatusBadRequest, "Failed to decode log level request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := adminRequest(router, tt.method, "/api/v1/admin/loglevel", tt.body)
+
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Fatalf(
+					"admin log level expected: %d %s, but got: %d %s", tt.wantCode, tt.wantBody, w.Code, w.Body.String(),
+				)
+			}
+		})
+	}
+
+	if !log.CheckLogLevel(log.Crit) || log.CheckLogLevel(log.Err) {
+		t.Fatalf("admin log level expected logger level %d", log.Crit)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// backpressureMW rejects requests while the producer queue is at the high-water mark, so Zabbix server
+// retries them later instead of records being dropped after timing out in the full queue.
+func (h *handler) backpressureMW(handler http.HandlerFunc) http.HandlerFunc {
+	rejected := metrics.GetCounter("backpressure.rejected")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.maxQueueDepth <= 0 {
+			handler(w, r)
+
+			return
+		}
+
+		depth := h.producer.QueueDepth()
+		if depth < h.maxQueueDepth {
+			handler(w, r)
+
+			return
+		}
+
+		rejected.Inc()
+
+		log.Debugf("producer queue depth %d at high-water mark, rejecting request from %s", depth, clientName(r))
+
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(h.queueRetryAfter)))
+
+		write(
+			w,
+			http.StatusServiceUnavailable,
+			jsonResponse(
+				map[string]string{
+					"response": "fail",
+					"error":    fmt.Sprintf("producer queue is full, %d messages waiting for delivery", depth),
+				},
+			),
+		)
+	}
+}
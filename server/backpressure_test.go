@@ -0,0 +1,76 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_handler_backpressureMW(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		maxQueueDepth  int
+		depth          int
+		retryAfter     time.Duration
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{"+disabled", 0, 1000, 5 * time.Second, http.StatusOK, ""},
+		{"+belowHighWaterMark", 10, 9, 5 * time.Second, http.StatusOK, ""},
+		{"-atHighWaterMark", 10, 10, 5 * time.Second, http.StatusServiceUnavailable, "5"},
+		{"-aboveHighWaterMark", 10, 20, 30 * time.Second, http.StatusServiceUnavailable, "30"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &handler{
+				producer:        &mockProducer{depth: tt.depth},
+				maxQueueDepth:   tt.maxQueueDepth,
+				queueRetryAfter: tt.retryAfter,
+			}
+
+			var called bool
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+
+			h.backpressureMW(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("handler.backpressureMW() expected status code: %d, but got: %d", tt.wantCode, w.Code)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Fatalf("handler.backpressureMW() expected Retry-After: %q, but got: %q", tt.wantRetryAfter, got)
+			}
+
+			if called != (tt.wantCode == http.StatusOK) {
+				t.Fatalf("handler.backpressureMW() expected next handler called: %t, but got: %t",
+					tt.wantCode == http.StatusOK, called)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"github.com/klauspost/compress/zstd"
+)
+
+const contentEncoding = "Content-Encoding"
+
+// decompressMW replaces the request body with a decompressing reader according to the Content-Encoding header.
+// Decompressed data is limited to maxDecompressed bytes to protect against decompression bombs.
+func (h *handler) decompressMW(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enc := strings.ToLower(strings.TrimSpace(r.Header.Get(contentEncoding)))
+		if enc == "" || enc == "identity" {
+			handler(w, r)
+
+			return
+		}
+
+		if !supportedEncoding(enc) {
+			write(
+				w,
+				http.StatusUnsupportedMediaType,
+				jsonResponse(
+					map[string]string{
+						"response": "fail",
+						"error":    fmt.Sprintf("unsupported %s %q, supported: gzip, deflate, zstd", contentEncoding, enc),
+					},
+				),
+			)
+
+			return
+		}
+
+		body, err := h.decompressor(enc, r.Body)
+		if err != nil {
+			write(
+				w,
+				http.StatusBadRequest,
+				jsonResponse(
+					map[string]string{
+						"response": "fail",
+						"error":    fmt.Sprintf("failed to decompress request body, %s", err.Error()),
+					},
+				),
+			)
+
+			return
+		}
+
+		defer body.Close() //nolint:errcheck // decompressors have nothing to flush on close
+
+		if h.maxDecompressed > 0 {
+			body = http.MaxBytesReader(w, body, h.maxDecompressed)
+		}
+
+		r.Body = body
+		r.Header.Del(contentEncoding)
+		r.ContentLength = -1
+
+		handler(w, r)
+	}
+}
+
+func (h *handler) decompressor(enc string, r io.Reader) (io.ReadCloser, error) {
+	switch enc {
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errs.Wrap(err, "failed to create gzip reader")
+		}
+
+		return gr, nil
+	case "deflate":
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, errs.Wrap(err, "failed to create deflate reader")
+		}
+
+		return zr, nil
+	case "zstd":
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if h.maxDecompressed > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(h.maxDecompressed)))
+		}
+
+		zr, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return nil, errs.Wrap(err, "failed to create zstd reader")
+		}
+
+		return zr.IOReadCloser(), nil
+	}
+
+	return nil, errs.New("unsupported encoding")
+}
+
+func supportedEncoding(enc string) bool {
+	switch enc {
+	case "gzip", "x-gzip", "deflate", "zstd":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func Test_handler_decompressMW(t *testing.T) {
+	t.Parallel()
+
+	body := getRequestString([]map[string]any{{"itemid": 1}, {"itemid": 2}})
+
+	type args struct {
+		encoding string
+		body     []byte
+	}
+
+	tests := []struct {
+		name            string
+		maxDecompressed int64
+		args            args
+		wantCode        int
+		wantCalls       int
+		wantIds         []string
+	}{
+		{"+identity", 0, args{"", []byte(body)}, http.StatusCreated, 2, []string{"1", "2"}},
+		{"+gzip", 0, args{"gzip", compressGzip(t, body)}, http.StatusCreated, 2, []string{"1", "2"}},
+		{"+deflate", 0, args{"deflate", compressZlib(t, body)}, http.StatusCreated, 2, []string{"1", "2"}},
+		{"+zstd", 0, args{"zstd", compressZstd(t, body)}, http.StatusCreated, 2, []string{"1", "2"}},
+		{"+withinLimit", 1024, args{"gzip", compressGzip(t, body)}, http.StatusCreated, 2, []string{"1", "2"}},
+		{"-unsupported", 0, args{"br", []byte(body)}, http.StatusUnsupportedMediaType, 0, nil},
+		{"-corrupted", 0, args{"gzip", []byte(body)}, http.StatusBadRequest, 0, nil},
+		{
+			"-bomb",
+			16,
+			args{"gzip", compressGzip(t, strings.Repeat(`{"itemid":1}`, 1000))},
+			http.StatusRequestEntityTooLarge,
+			0,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &mockProducer{}
+			h := &handler{producer: p, maxDecompressed: tt.maxDecompressed}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", bytes.NewReader(tt.args.body))
+			r.Header.Set(contentEncoding, tt.args.encoding)
+
+			h.decompressMW(errorHandlingMW(h.items))(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf(
+					"handler.decompressMW() expected status code: %d, but got: %d\nresponse body: %s",
+					tt.wantCode,
+					w.Code,
+					w.Body,
+				)
+			}
+
+			if p.called != tt.wantCalls {
+				t.Fatalf("handler.decompressMW() expected %d produce calls, but got: %d", tt.wantCalls, p.called)
+			}
+
+			if diff := cmp.Diff(tt.wantIds, p.ids); diff != "" {
+				t.Fatalf("handler.decompressMW() = %s", diff)
+			}
+		})
+	}
+}
+
+func compressGzip(t *testing.T, in string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w := gzip.NewWriter(&b)
+
+	return compress(t, w, &b, in)
+}
+
+func compressZlib(t *testing.T, in string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w := zlib.NewWriter(&b)
+
+	return compress(t, w, &b, in)
+}
+
+func compressZstd(t *testing.T, in string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatalf("failed to create zstd writer: %s", err.Error())
+	}
+
+	return compress(t, w, &b, in)
+}
+
+func compress(t *testing.T, w io.WriteCloser, b *bytes.Buffer, in string) []byte {
+	t.Helper()
+
+	_, err := w.Write([]byte(in))
+	if err != nil {
+		t.Fatalf("failed to compress test data: %s", err.Error())
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("failed to close compressor: %s", err.Error())
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,228 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+)
+
+// approximate memory used by a cache entry on top of its key, the map entry and the queue element.
+const dedupEntryOverhead = 96
+
+// DedupConfiguration holds deduplication configuration tags based on Zabbix configuration package from plugin support.
+type DedupConfiguration struct {
+	Enable      bool   `conf:"default=false"`
+	Window      int    `conf:"range=1:86400,default=300"`
+	MaxMemory   int    `conf:"range=1:4096,default=64"`
+	EventFields string `conf:"default=eventid"`
+	ItemFields  string `conf:"default=itemid,clock,ns"`
+}
+
+// dedup is a bounded, time windowed cache of record identities.
+// Entries expire after the window, the oldest entries are evicted when the memory ceiling is reached.
+type dedup struct {
+	mu        sync.Mutex
+	window    time.Duration
+	maxMemory int
+	memory    int
+	entries   map[string]dedupItem
+	queue     []dedupEntry
+	gen       uint64
+	now       func() time.Time
+
+	hits      *metrics.Counter
+	misses    *metrics.Counter
+	evictions *metrics.Counter
+}
+
+// dedupItem is a remembered key, gen identifies its queue entry, as a forgotten key may be remembered again
+// while the queue entry of the forgotten one is still queued.
+type dedupItem struct {
+	expires time.Time
+	gen     uint64
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+	gen     uint64
+}
+
+func newDedup(c *DedupConfiguration) *dedup {
+	d := &dedup{
+		window:    time.Duration(c.Window) * time.Second,
+		maxMemory: c.MaxMemory * 1024 * 1024,
+		entries:   map[string]dedupItem{},
+		now:       time.Now,
+		hits:      metrics.GetCounter("dedup.hits"),
+		misses:    metrics.GetCounter("dedup.misses"),
+		evictions: metrics.GetCounter("dedup.evictions"),
+	}
+
+	metrics.SetFunc("dedup.entries", func() any { return d.len() })
+	metrics.SetFunc("dedup.memory", func() any { return d.size() })
+	metrics.SetFunc("dedup.hit_rate", func() any {
+		hits, total := d.hits.Value(), d.hits.Value()+d.misses.Value()
+		if total == 0 {
+			return 0.0
+		}
+
+		return float64(hits) / float64(total)
+	})
+
+	return d
+}
+
+// seen returns true if the key was already seen within the window, otherwise the key is remembered.
+func (d *dedup) seen(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.expire(now)
+
+	if it, ok := d.entries[key]; ok && now.Before(it.expires) {
+		d.hits.Inc()
+
+		return true
+	}
+
+	d.misses.Inc()
+
+	size := len(key) + dedupEntryOverhead
+	for d.memory+size > d.maxMemory && len(d.queue) > 0 {
+		if d.evict() {
+			d.evictions.Inc()
+		}
+	}
+
+	d.gen++
+
+	expires := now.Add(d.window)
+	d.entries[key] = dedupItem{expires, d.gen}
+	d.queue = append(d.queue, dedupEntry{key, expires, d.gen})
+	d.memory += size
+
+	return false
+}
+
+// forget removes the key, so a record that failed to be produced is not dropped as a duplicate when it is retried.
+// Its queue entry is left to expire, evict skips it as its generation is no longer remembered.
+func (d *dedup) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[key]; ok {
+		delete(d.entries, key)
+		d.memory -= len(key) + dedupEntryOverhead
+	}
+}
+
+// expire removes entries which are out of the window, entries are queued in expiry order.
+func (d *dedup) expire(now time.Time) {
+	for len(d.queue) > 0 && !now.Before(d.queue[0].expires) {
+		d.evict()
+	}
+}
+
+// evict removes the oldest queue entry, returns true if its key was still remembered.
+func (d *dedup) evict() bool {
+	e := d.queue[0]
+	d.queue[0] = dedupEntry{}
+	d.queue = d.queue[1:]
+
+	if it, ok := d.entries[e.key]; !ok || it.gen != e.gen {
+		return false
+	}
+
+	delete(d.entries, e.key)
+	d.memory -= len(e.key) + dedupEntryOverhead
+
+	return true
+}
+
+func (d *dedup) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.entries)
+}
+
+func (d *dedup) size() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.memory
+}
+
+// identity builds a deduplication key from the values of the fields in a JSON record.
+// Returns false if the record is not an object or any of the fields is missing.
+func identity(prefix, data string, fields []string) (string, bool) {
+	var record map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(data), &record)
+	if err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+
+	b.WriteString(prefix)
+
+	for _, f := range fields {
+		v, ok := record[f]
+		if !ok {
+			return "", false
+		}
+
+		b.WriteByte('|')
+		b.Write(v)
+	}
+
+	return b.String(), true
+}
+
+// duplicate returns true if a record with the same identity was already seen, otherwise the identity is
+// remembered and returned, so it can be forgotten if producing the record fails.
+func (h handler) duplicate(prefix, data string, fields []string) (string, bool) {
+	key, ok := identity(prefix, data, fields)
+	if !ok {
+		return "", false
+	}
+
+	if h.dedup.seen(key) {
+		return "", true
+	}
+
+	return key, false
+}
+
+func splitFields(fields string) []string {
+	var out []string
+
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,292 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_dedup_seen(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		key     string
+		advance time.Duration
+		want    bool
+	}
+
+	tests := []struct {
+		name        string
+		maxMemory   int
+		steps       []step
+		wantEntries int
+	}{
+		{
+			"+duplicate",
+			1024 * 1024,
+			[]step{
+				{"a", 0, false},
+				{"a", time.Second, true},
+				{"b", 0, false},
+			},
+			2,
+		},
+		{
+			"+expired",
+			1024 * 1024,
+			[]step{
+				{"a", 0, false},
+				{"a", 10 * time.Second, false},
+				{"a", 5 * time.Second, true},
+			},
+			1,
+		},
+		{
+			"+memoryCeiling",
+			2 * (1 + dedupEntryOverhead),
+			[]step{
+				{"a", 0, false},
+				{"b", 0, false},
+				{"c", 0, false},
+				{"a", 0, false},
+				{"c", 0, true},
+			},
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Unix(0, 0)
+
+			d := newDedup(&DedupConfiguration{Window: 10})
+			d.maxMemory = tt.maxMemory
+			d.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+
+				if got := d.seen(s.key); got != s.want {
+					t.Fatalf("dedup.seen() step %d expected %t, but got: %t", i, s.want, got)
+				}
+			}
+
+			if d.len() != tt.wantEntries {
+				t.Fatalf("dedup.seen() expected %d entries, but got: %d", tt.wantEntries, d.len())
+			}
+		})
+	}
+}
+
+func Test_dedup_forget(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+
+	d := newDedup(&DedupConfiguration{Window: 10})
+	d.maxMemory = 2 * (1 + dedupEntryOverhead)
+	d.now = func() time.Time { return now }
+
+	d.seen("a")
+	d.forget("a")
+
+	if got := d.size(); got != 0 {
+		t.Fatalf("dedup.forget() expected no memory used, but got: %d", got)
+	}
+
+	// remembered again with the same expiry, while the queue entry of the forgotten key is still queued.
+	if d.seen("a") {
+		t.Fatalf("dedup.seen() expected forgotten key not seen")
+	}
+
+	if d.seen("b") {
+		t.Fatalf("dedup.seen() expected new key not seen")
+	}
+
+	if !d.seen("a") {
+		t.Fatalf("dedup.seen() expected remembered key not evicted by its forgotten queue entry")
+	}
+
+	if got, want := d.size(), 2*(1+dedupEntryOverhead); got != want {
+		t.Fatalf("dedup.seen() expected memory %d, but got: %d", want, got)
+	}
+
+	now = now.Add(10 * time.Second)
+
+	if d.seen("c"); d.len() != 1 || d.size() != 1+dedupEntryOverhead {
+		t.Fatalf("dedup.seen() expected expired entries removed, but got: %d entries, %d bytes", d.len(), d.size())
+	}
+}
+
+func Test_identity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		data   string
+		fields []string
+		want   string
+		wantOk bool
+	}{
+		{"+event", `{"eventid":23,"name":"foo"}`, []string{"eventid"}, "e|23", true},
+		{"+item", `{"itemid":1,"clock":2,"ns":3}`, []string{"itemid", "clock", "ns"}, "e|1|2|3", true},
+		{"-missingField", `{"itemid":1,"clock":2}`, []string{"itemid", "clock", "ns"}, "", false},
+		{"-notObject", `[1,2]`, []string{"itemid"}, "", false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := identity("e", tt.data, tt.fields)
+			if ok != tt.wantOk {
+				t.Fatalf("identity() expected ok: %t, but got: %t", tt.wantOk, ok)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("identity() = %s", diff)
+			}
+		})
+	}
+}
+
+func Test_handler_produceItem_dedup(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{}
+	h := handler{
+		producer:   p,
+		dedup:      newDedup(&DedupConfiguration{Window: 60, MaxMemory: 1}),
+		itemFields: []string{"itemid", "clock", "ns"},
+	}
+
+	items := []item{
+		{1, `{"itemid":1,"clock":10,"ns":0}`},
+		{1, `{"itemid":1,"clock":10,"ns":0}`},
+		{1, `{"itemid":1,"clock":11,"ns":0}`},
+		{1, `{"itemid":1,"clock":11,"ns":0}`},
+	}
+
+	for i := range items {
+		err := settledItem(h, &items[i])
+		if err != nil {
+			t.Fatalf("handler.produceItem() unexpected error: %s", err.Error())
+		}
+	}
+
+	if p.called != 2 {
+		t.Fatalf("handler.produceItem() expected 2 produced items, but got: %d", p.called)
+	}
+}
+
+func Test_handler_produce_dedupRetry(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{err: errors.New("queue full")}
+	h := handler{
+		producer:    p,
+		dedup:       newDedup(&DedupConfiguration{Window: 60, MaxMemory: 1}),
+		eventFields: []string{"eventid"},
+		itemFields:  []string{"itemid", "clock", "ns"},
+	}
+
+	i := item{1, `{"itemid":1,"clock":10,"ns":0}`}
+	e := event{2, `{"eventid":2,"value":1}`}
+
+	if err := settledItem(h, &i); err == nil {
+		t.Fatalf("handler.produceItem() expected error")
+	}
+
+	if err := settledEvent(h, &e); err == nil {
+		t.Fatalf("handler.produceEvent() expected error")
+	}
+
+	p.err = nil
+
+	if err := settledItem(h, &i); err != nil {
+		t.Fatalf("handler.produceItem() unexpected error: %s", err.Error())
+	}
+
+	if err := settledEvent(h, &e); err != nil {
+		t.Fatalf("handler.produceEvent() unexpected error: %s", err.Error())
+	}
+
+	if p.called != 2 {
+		t.Fatalf("handler.produce() expected retried records to be produced, but got: %d", p.called)
+	}
+
+	if err := settledItem(h, &i); err != nil || p.called != 2 {
+		t.Fatalf("handler.produceItem() expected produced record to be dropped as duplicate")
+	}
+}
+
+func Test_handler_produce_dedupNotDelivered(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{deliveryErr: errors.New("broker not available")}
+	h := handler{
+		producer:   p,
+		dedup:      newDedup(&DedupConfiguration{Window: 60, MaxMemory: 1}),
+		itemFields: []string{"itemid", "clock", "ns"},
+	}
+
+	i := item{1, `{"itemid":1,"clock":10,"ns":0}`}
+
+	if err := settledItem(h, &i); err == nil {
+		t.Fatalf("handler.produceItem() expected error")
+	}
+
+	p.deliveryErr = nil
+
+	if err := settledItem(h, &i); err != nil || p.called != 2 {
+		t.Fatalf("handler.produceItem() expected undelivered record to be produced again, but got: %d, %v", p.called, err)
+	}
+}
+
+func Test_handler_produceOTLPItems_dedupRetry(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{err: errors.New("queue full")}
+	h := handler{
+		producer:   p,
+		dedup:      newDedup(&DedupConfiguration{Window: 60, MaxMemory: 1}),
+		itemFields: []string{"itemid", "clock", "ns"},
+	}
+
+	items := []item{{1, `{"itemid":1,"clock":10,"ns":0,"value":1.5}`}}
+
+	produce := func(ctx context.Context, in *ingest) error {
+		return h.produceOTLPItems(ctx, in, items)
+	}
+
+	if err := settled(h, produce); err == nil {
+		t.Fatalf("handler.produceOTLPItems() expected error")
+	}
+
+	p.err = nil
+
+	if err := settled(h, produce); err != nil || p.called != 1 {
+		t.Fatalf("handler.produceOTLPItems() expected retried batch to be produced, but got: %d, %v", p.called, err)
+	}
+}
@@ -0,0 +1,265 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ZT/kafka-connector/watch"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Sources of a pause.
+const (
+	PauseAdmin    = "admin"
+	PauseSignal   = "signal"
+	PauseFile     = "file"
+	PauseSchedule = "schedule"
+)
+
+const pauseHealth = "ingest"
+
+// Pause is the pause state of ingestion, while paused ingest requests are rejected so Zabbix server
+// buffers the data. A pause ends when it is resumed or at its end time, if it has one.
+type Pause struct {
+	mu     sync.Mutex
+	paused bool
+	source string
+	until  time.Time
+	timer  *time.Timer
+	// gen identifies the current pause, so a timer of a replaced pause does not end it.
+	gen     uint64
+	now     func() time.Time
+	watcher *watch.Watcher
+	// file is the last content of the maintenance file, nil while the file does not exist.
+	file *string
+}
+
+// PauseStatus describes the pause state.
+type PauseStatus struct {
+	Paused bool       `json:"paused"`
+	Source string     `json:"source,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// NewPause returns a pause state in which ingestion is not paused. The state is reported in the ingest.paused
+// metric and in the health output, where pausing is ok as it is deliberate.
+func NewPause() *Pause {
+	p := newPause()
+
+	metrics.SetFunc("ingest.paused", func() any { return p.Status().Paused })
+	health.Register(pauseHealth, p.health)
+
+	return p
+}
+
+func newPause() *Pause {
+	return &Pause{now: time.Now}
+}
+
+// Pause pauses ingestion until it is resumed or until the end time, if it is not zero.
+// Pausing again replaces the source and the end time of the current pause.
+func (p *Pause) Pause(source string, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopTimer()
+
+	p.gen++
+	p.paused, p.source, p.until = true, source, until
+
+	if until.IsZero() {
+		log.Infof("ingestion paused by %s", source)
+
+		return
+	}
+
+	gen := p.gen
+	p.timer = time.AfterFunc(until.Sub(p.now()), func() { p.end(gen) })
+
+	log.Infof("ingestion paused by %s until %s", source, until.Format(time.RFC3339))
+}
+
+// Resume resumes ingestion, it returns false if ingestion was not paused.
+func (p *Pause) Resume(source string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.resume(source)
+}
+
+// Status returns the current pause state.
+func (p *Pause) Status() PauseStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PauseStatus{Paused: p.paused, Source: p.source}
+
+	if !p.until.IsZero() {
+		until := p.until
+		s.Until = &until
+	}
+
+	return s
+}
+
+// RetryAfter returns the time until a paused ingestion resumes, def if the pause has no end time.
+func (p *Pause) RetryAfter(def time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.until.IsZero() {
+		return def
+	}
+
+	return p.until.Sub(p.now())
+}
+
+// WatchFile pauses ingestion while the maintenance file exists. The file may contain the end time of the pause
+// in RFC 3339 format, otherwise ingestion is paused until the file is removed.
+func (p *Pause) WatchFile(path string, interval time.Duration) {
+	p.applyFile(path)
+
+	p.watcher = watch.New([]string{path}, interval, func() { p.applyFile(path) })
+}
+
+// Close stops watching the maintenance file and the scheduled end of the pause.
+func (p *Pause) Close() {
+	if p.watcher != nil {
+		p.watcher.Stop()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopTimer()
+	health.Unregister(pauseHealth)
+}
+
+// applyFile pauses ingestion when the maintenance file appears or changes,
+// and resumes it when the file is removed, unless the pause was since replaced by another source.
+func (p *Pause) applyFile(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errf("failed to read maintenance file, %s", err.Error())
+
+			return
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.file != nil && p.source == PauseFile {
+			p.resume(PauseFile)
+		}
+
+		p.file = nil
+
+		return
+	}
+
+	content := strings.TrimSpace(string(b))
+
+	p.mu.Lock()
+	if p.file != nil && *p.file == content {
+		p.mu.Unlock()
+
+		return
+	}
+
+	p.file = &content
+	p.mu.Unlock()
+
+	var until time.Time
+
+	if content != "" {
+		until, err = time.Parse(time.RFC3339, content)
+		if err != nil {
+			log.Warningf("invalid end time in maintenance file %s, pausing until it is removed, %s", path, err.Error())
+		}
+
+		if !until.IsZero() && !until.After(p.now()) {
+			log.Warningf("end time in maintenance file %s has passed, not pausing", path)
+
+			// a pause of the previous contents of the file ends with them.
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			if p.source == PauseFile {
+				p.resume(PauseFile)
+			}
+
+			return
+		}
+	}
+
+	p.Pause(PauseFile, until)
+}
+
+// end resumes ingestion at the end time of the pause, if it was not replaced meanwhile.
+func (p *Pause) end(gen uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.gen == gen {
+		p.resume(PauseSchedule)
+	}
+}
+
+// resume must be called with the mutex held.
+func (p *Pause) resume(source string) bool {
+	if !p.paused {
+		return false
+	}
+
+	p.stopTimer()
+
+	p.gen++
+	p.paused, p.source, p.until = false, "", time.Time{}
+
+	log.Infof("ingestion resumed by %s", source)
+
+	return true
+}
+
+func (p *Pause) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// health reports ok while paused, as Zabbix server is expected to keep the data until ingestion resumes.
+func (p *Pause) health() health.Report {
+	s := p.Status()
+
+	r := health.Report{Status: health.StatusOK, Details: map[string]any{"paused": s.Paused}}
+
+	if s.Paused {
+		r.Details["source"] = s.Source
+
+		if s.Until != nil {
+			r.Details["until"] = s.Until
+		}
+	}
+
+	return r
+}
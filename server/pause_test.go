@@ -0,0 +1,230 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+)
+
+func TestPause(t *testing.T) {
+	t.Parallel()
+
+	p := newPause()
+
+	if p.Resume(PauseAdmin) {
+		t.Fatalf("Pause.Resume() expected false when not paused")
+	}
+
+	p.Pause(PauseAdmin, time.Time{})
+
+	if s := p.Status(); !s.Paused || s.Source != PauseAdmin || s.Until != nil {
+		t.Fatalf("Pause.Status() expected paused by admin without end, but got: %+v", s)
+	}
+
+	if got := p.RetryAfter(5 * time.Second); got != 5*time.Second {
+		t.Fatalf("Pause.RetryAfter() expected default without end, but got: %s", got)
+	}
+
+	if r := p.health(); r.Status != health.StatusOK {
+		t.Fatalf("Pause.health() expected ok while paused, but got: %s", r.Status)
+	}
+
+	if !p.Resume(PauseAdmin) || p.Status().Paused {
+		t.Fatalf("Pause.Resume() expected ingestion resumed")
+	}
+}
+
+func TestPause_scheduled(t *testing.T) {
+	t.Parallel()
+
+	p := newPause()
+
+	p.Pause(PauseAdmin, time.Now().Add(50*time.Millisecond))
+
+	if got := p.RetryAfter(time.Hour); got <= 0 || got > 50*time.Millisecond {
+		t.Fatalf("Pause.RetryAfter() expected time until the end, but got: %s", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for p.Status().Paused {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pause expected to resume at the end time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPause_replaced(t *testing.T) {
+	t.Parallel()
+
+	p := newPause()
+
+	p.Pause(PauseAdmin, time.Now().Add(20*time.Millisecond))
+	p.Pause(PauseSignal, time.Time{})
+
+	time.Sleep(100 * time.Millisecond)
+
+	if s := p.Status(); !s.Paused || s.Source != PauseSignal {
+		t.Fatalf("Pause expected the replacing pause to stay, but got: %+v", s)
+	}
+}
+
+func TestPause_applyFile(t *testing.T) {
+	t.Parallel()
+
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		content    string
+		wantPaused bool
+		wantUntil  bool
+	}{
+		{"+empty", "", true, false},
+		{"+until", future.Format(time.RFC3339), true, true},
+		{"+invalid", "tomorrow", true, false},
+		{"-passed", time.Now().Add(-time.Hour).Format(time.RFC3339), false, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "maintenance")
+
+			err := os.WriteFile(path, []byte(tt.content+"\n"), 0o600)
+			if err != nil {
+				t.Fatalf("failed to write maintenance file: %s", err.Error())
+			}
+
+			p := newPause()
+			defer p.stopTimer()
+
+			p.applyFile(path)
+
+			s := p.Status()
+			if s.Paused != tt.wantPaused || (s.Until != nil) != tt.wantUntil {
+				t.Fatalf("Pause.applyFile() expected paused %t with end %t, but got: %+v", tt.wantPaused, tt.wantUntil, s)
+			}
+
+			if tt.wantUntil && !s.Until.Equal(future) {
+				t.Fatalf("Pause.applyFile() expected end time %s, but got: %s", future, s.Until)
+			}
+
+			err = os.Remove(path)
+			if err != nil {
+				t.Fatalf("failed to remove maintenance file: %s", err.Error())
+			}
+
+			p.applyFile(path)
+
+			if p.Status().Paused {
+				t.Fatalf("Pause.applyFile() expected removed file to resume ingestion")
+			}
+		})
+	}
+}
+
+func TestPause_applyFile_endPassed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "maintenance")
+
+	err := os.WriteFile(path, nil, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write maintenance file: %s", err.Error())
+	}
+
+	p := newPause()
+	defer p.stopTimer()
+
+	p.applyFile(path)
+
+	if !p.Status().Paused {
+		t.Fatalf("Pause.applyFile() expected maintenance file to pause ingestion")
+	}
+
+	err = os.WriteFile(path, []byte(time.Now().Add(-time.Hour).Format(time.RFC3339)), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write maintenance file: %s", err.Error())
+	}
+
+	p.applyFile(path)
+
+	if s := p.Status(); s.Paused {
+		t.Fatalf("Pause.applyFile() expected passed end time to resume ingestion, but got: %+v", s)
+	}
+}
+
+func TestPause_applyFile_otherSource(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "maintenance")
+
+	err := os.WriteFile(path, nil, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write maintenance file: %s", err.Error())
+	}
+
+	p := newPause()
+
+	p.applyFile(path)
+	p.Pause(PauseAdmin, time.Time{})
+
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatalf("failed to remove maintenance file: %s", err.Error())
+	}
+
+	p.applyFile(path)
+
+	if s := p.Status(); !s.Paused || s.Source != PauseAdmin {
+		t.Fatalf("Pause.applyFile() expected admin pause to stay, but got: %+v", s)
+	}
+}
+
+func Test_handler_pauseMW_until(t *testing.T) {
+	t.Parallel()
+
+	p := newPause()
+	p.Pause(PauseAdmin, time.Now().Add(90*time.Second))
+
+	defer p.stopTimer()
+
+	h := handler{pause: p, queueRetryAfter: 5 * time.Second}
+
+	w := httptest.NewRecorder()
+	h.pauseMW(func(http.ResponseWriter, *http.Request) {
+		t.Fatalf("pauseMW() expected request to be rejected")
+	})(w, httptest.NewRequest(http.MethodPost, "/api/v1/items", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("pauseMW() expected status code: %d, but got: %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "90" && got != "89" {
+		t.Fatalf("pauseMW() expected Retry-After until the end of the pause, but got: %s", got)
+	}
+}
@@ -0,0 +1,323 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/auth"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// RateLimitConfiguration holds rate limit configuration tags based on Zabbix configuration package from plugin support.
+// Limits are per client, zero means no limit. Zero bursts default to one second worth of the rate.
+type RateLimitConfiguration struct {
+	Requests      int `conf:"range=0:1000000,default=0"`
+	RequestBurst  int `conf:"range=0:1000000,default=0"`
+	Records       int `conf:"range=0:100000000,default=0"`
+	RecordBurst   int `conf:"range=0:100000000,default=0"`
+	MaxConcurrent int `conf:"range=0:100000,default=0"`
+}
+
+// limiter holds token buckets of every client and the global concurrent request limit.
+// Records are only known after a request is decoded, so they are charged afterwards and the record
+// bucket can go into debt, rejecting further requests of the client until it is refilled.
+type limiter struct {
+	mu        sync.Mutex
+	requests  rate
+	records   rate
+	clients   map[string]*clientBuckets
+	lastSweep time.Time
+	now       func() time.Time
+
+	// sem is nil if concurrent requests are not limited.
+	sem chan struct{}
+
+	rejectedRequests    *metrics.Counter
+	rejectedRecords     *metrics.Counter
+	rejectedConcurrency *metrics.Counter
+}
+
+// rate is a token bucket refill rate per second and bucket size, a zero rate means no limit.
+type rate struct {
+	limit float64
+	burst float64
+}
+
+type clientBuckets struct {
+	requests float64
+	records  float64
+	last     time.Time
+}
+
+// newLimiter returns nil if no limit is set.
+func newLimiter(c *RateLimitConfiguration) *limiter {
+	if c.Requests <= 0 && c.Records <= 0 && c.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	l := &limiter{
+		requests:            newRate(c.Requests, c.RequestBurst),
+		records:             newRate(c.Records, c.RecordBurst),
+		clients:             map[string]*clientBuckets{},
+		now:                 time.Now,
+		rejectedRequests:    metrics.GetCounter("ratelimit.rejected.requests"),
+		rejectedRecords:     metrics.GetCounter("ratelimit.rejected.records"),
+		rejectedConcurrency: metrics.GetCounter("ratelimit.rejected.concurrency"),
+	}
+
+	l.lastSweep = l.now()
+
+	if c.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, c.MaxConcurrent)
+	}
+
+	metrics.SetFunc("ratelimit.concurrent", func() any { return len(l.sem) })
+	metrics.SetFunc("ratelimit.clients", func() any {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return len(l.clients)
+	})
+
+	return l
+}
+
+func newRate(limit, burst int) rate {
+	if burst <= 0 {
+		burst = limit
+	}
+
+	return rate{float64(limit), float64(burst)}
+}
+
+// allow takes a request token of the client. Returns false and the time after which the request
+// can be retried if the client is over its request limit or in record debt.
+func (l *limiter) allow(client string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets(client)
+
+	if l.records.limit > 0 && b.records < 1 {
+		l.rejectedRecords.Inc()
+
+		return l.records.wait(b.records), false
+	}
+
+	if l.requests.limit > 0 {
+		if b.requests < 1 {
+			l.rejectedRequests.Inc()
+
+			return l.requests.wait(b.requests), false
+		}
+
+		b.requests--
+	}
+
+	return 0, true
+}
+
+// charge takes record tokens of the client for the records of a request.
+func (l *limiter) charge(client string, records int) {
+	if l.records.limit <= 0 || records <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buckets(client).records -= float64(records)
+}
+
+// acquire takes a concurrent request slot, returns false if all slots are taken.
+func (l *limiter) acquire() bool {
+	if l.sem == nil {
+		return true
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		l.rejectedConcurrency.Inc()
+
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// buckets returns the refilled buckets of the client, buckets of new clients start full.
+// Must be called with the lock held.
+func (l *limiter) buckets(client string) *clientBuckets {
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &clientBuckets{requests: l.requests.burst, records: l.records.burst, last: now}
+		l.clients[client] = b
+
+		return b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.requests = l.requests.refill(b.requests, elapsed)
+	b.records = l.records.refill(b.records, elapsed)
+	b.last = now
+
+	return b
+}
+
+// sweep removes clients which have been idle long enough for their buckets to be full,
+// as they are equal to new ones. Must be called with the lock held.
+func (l *limiter) sweep(now time.Time) {
+	idle := time.Duration(math.Max(l.requests.fill(), l.records.fill()) * float64(time.Second))
+	if idle < time.Minute {
+		idle = time.Minute
+	}
+
+	if now.Sub(l.lastSweep) < idle {
+		return
+	}
+
+	l.lastSweep = now
+
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= idle {
+			delete(l.clients, client)
+		}
+	}
+}
+
+func (r rate) refill(tokens, elapsed float64) float64 {
+	if r.limit <= 0 {
+		return tokens
+	}
+
+	return math.Min(r.burst, tokens+elapsed*r.limit)
+}
+
+// wait returns the time until the bucket holds a whole token.
+func (r rate) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / r.limit * float64(time.Second))
+}
+
+// fill returns the seconds it takes to fill an empty bucket.
+func (r rate) fill() float64 {
+	if r.limit <= 0 {
+		return 0
+	}
+
+	return r.burst / r.limit
+}
+
+// limitMW rejects requests of clients over their rate limits and requests over the concurrent request limit.
+func (h *handler) limitMW(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.limiter == nil {
+			handler(w, r)
+
+			return
+		}
+
+		client := clientKey(r)
+
+		wait, ok := h.limiter.allow(client)
+		if !ok {
+			log.Debugf("rate limit exceeded by client %s, retry after %s", client, wait)
+			throttled(w, r, wait, fmt.Sprintf("rate limit exceeded by client %s", client))
+
+			return
+		}
+
+		if !h.limiter.acquire() {
+			log.Debugf("concurrent request limit reached, rejecting request from %s", client)
+			throttled(w, r, time.Second, "too many concurrent requests")
+
+			return
+		}
+
+		defer h.limiter.release()
+
+		handler(w, r)
+	}
+}
+
+// chargeRecords charges the records of a request to the rate limit of its client.
+func (h handler) chargeRecords(r *http.Request, records int) {
+	if h.limiter == nil {
+		return
+	}
+
+	h.limiter.charge(clientKey(r), records)
+}
+
+// throttled responds with 429 and the seconds after which the request can be retried.
+func throttled(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	if c := auth.FromContext(r.Context()); c != nil {
+		metrics.GetCounter("client." + c.Name + ".throttled").Inc()
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter(wait)))
+
+	write(
+		w,
+		http.StatusTooManyRequests,
+		jsonResponse(
+			map[string]string{
+				"response": "fail",
+				"error":    msg,
+			},
+		),
+	)
+}
+
+// retryAfter rounds the wait up to whole seconds, at least one.
+func retryAfter(wait time.Duration) int {
+	s := int(math.Ceil(wait.Seconds()))
+	if s < 1 {
+		return 1
+	}
+
+	return s
+}
+
+// clientKey identifies the client of a request for rate limiting, by name if it is authenticated,
+// by IP address otherwise.
+func clientKey(r *http.Request) string {
+	if c := auth.FromContext(r.Context()); c != nil {
+		return c.Name
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
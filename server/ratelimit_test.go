@@ -0,0 +1,248 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/auth"
+)
+
+func Test_limiter_allow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	l := newLimiter(&RateLimitConfiguration{Requests: 2, Records: 10})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.allow("a"); !ok {
+			t.Fatalf("limiter.allow() expected request %d within burst to be allowed", i)
+		}
+	}
+
+	wait, ok := l.allow("a")
+	if ok {
+		t.Fatalf("limiter.allow() expected request over burst to be rejected")
+	}
+
+	if wait != 500*time.Millisecond {
+		t.Fatalf("limiter.allow() expected wait: %s, but got: %s", 500*time.Millisecond, wait)
+	}
+
+	if _, ok := l.allow("b"); !ok {
+		t.Fatalf("limiter.allow() expected other client to be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+
+	if _, ok := l.allow("a"); !ok {
+		t.Fatalf("limiter.allow() expected request to be allowed after refill")
+	}
+
+	// a request with 30 records puts the client 20 records into debt, which takes 2.1 seconds to repay.
+	l.charge("a", 30)
+
+	now = now.Add(time.Second)
+
+	wait, ok = l.allow("a")
+	if ok {
+		t.Fatalf("limiter.allow() expected request to be rejected while in record debt")
+	}
+
+	if wait != 1100*time.Millisecond {
+		t.Fatalf("limiter.allow() expected wait: %s, but got: %s", 1100*time.Millisecond, wait)
+	}
+
+	now = now.Add(1100 * time.Millisecond)
+
+	if _, ok := l.allow("a"); !ok {
+		t.Fatalf("limiter.allow() expected request to be allowed after record debt is repaid")
+	}
+}
+
+func Test_limiter_sweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	l := newLimiter(&RateLimitConfiguration{Requests: 1, RequestBurst: 120})
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+
+	l.allow("a")
+
+	now = now.Add(time.Minute)
+	l.allow("b")
+
+	if len(l.clients) != 2 {
+		t.Fatalf("limiter.sweep() expected clients with buckets that are not full to be kept, got: %d", len(l.clients))
+	}
+
+	now = now.Add(time.Minute)
+	l.allow("b")
+
+	if _, ok := l.clients["a"]; ok {
+		t.Fatalf("limiter.sweep() expected idle client to be removed")
+	}
+
+	if _, ok := l.clients["b"]; !ok {
+		t.Fatalf("limiter.sweep() expected active client to be kept")
+	}
+}
+
+func Test_newLimiter(t *testing.T) {
+	t.Parallel()
+
+	if newLimiter(&RateLimitConfiguration{}) != nil {
+		t.Fatalf("newLimiter() expected nil limiter without limits")
+	}
+
+	l := newLimiter(&RateLimitConfiguration{Requests: 5})
+	if l.requests.burst != 5 {
+		t.Fatalf("newLimiter() expected burst to default to the rate, but got: %f", l.requests.burst)
+	}
+}
+
+func Test_handler_limitMW(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		client         string
+		wantCode       int
+		wantRetryAfter string
+	}
+
+	tests := []struct {
+		name     string
+		conf     RateLimitConfiguration
+		inFlight int
+		requests []request
+	}{
+		{
+			"+unlimited",
+			RateLimitConfiguration{Requests: 0},
+			0,
+			[]request{{"a", http.StatusOK, ""}, {"a", http.StatusOK, ""}, {"a", http.StatusOK, ""}},
+		},
+		{
+			"+perClient",
+			RateLimitConfiguration{Requests: 1},
+			0,
+			[]request{{"a", http.StatusOK, ""}, {"b", http.StatusOK, ""}},
+		},
+		{
+			"-requests",
+			RateLimitConfiguration{Requests: 1},
+			0,
+			[]request{{"a", http.StatusOK, ""}, {"a", http.StatusTooManyRequests, "1"}},
+		},
+		{
+			"-recordDebt",
+			RateLimitConfiguration{Records: 1},
+			0,
+			[]request{{"a", http.StatusOK, ""}, {"a", http.StatusTooManyRequests, "10"}},
+		},
+		{
+			"-concurrency",
+			RateLimitConfiguration{MaxConcurrent: 1},
+			1,
+			[]request{{"a", http.StatusTooManyRequests, "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := &handler{limiter: newLimiter(&tt.conf)}
+
+			for i := 0; i < tt.inFlight; i++ {
+				h.limiter.acquire()
+			}
+
+			for i, req := range tt.requests {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+				r = r.WithContext(auth.WithClient(r.Context(), auth.NewClient(req.client)))
+
+				h.limitMW(func(w http.ResponseWriter, r *http.Request) {
+					h.chargeRecords(r, 10)
+					w.WriteHeader(http.StatusOK)
+				})(w, r)
+
+				if w.Code != req.wantCode {
+					t.Fatalf("handler.limitMW() request %d expected status code: %d, but got: %d", i, req.wantCode, w.Code)
+				}
+
+				if got := w.Header().Get("Retry-After"); got != req.wantRetryAfter {
+					t.Fatalf("handler.limitMW() request %d expected Retry-After: %q, but got: %q", i, req.wantRetryAfter, got)
+				}
+			}
+
+			if h.limiter != nil && h.limiter.sem != nil && len(h.limiter.sem) != tt.inFlight {
+				t.Fatalf("handler.limitMW() expected concurrent slots to be released")
+			}
+		})
+	}
+}
+
+func Test_clientKey(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+	r.RemoteAddr = "10.0.0.1:34567"
+
+	if got := clientKey(r); got != "10.0.0.1" {
+		t.Fatalf("clientKey() expected: 10.0.0.1, but got: %s", got)
+	}
+
+	r = r.WithContext(auth.WithClient(r.Context(), auth.NewClient("zabbix-eu")))
+
+	if got := clientKey(r); got != "zabbix-eu" {
+		t.Fatalf("clientKey() expected: zabbix-eu, but got: %s", got)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		wait time.Duration
+		want int
+	}{
+		{"+zero", 0, 1},
+		{"+subSecond", 100 * time.Millisecond, 1},
+		{"+roundUp", 1100 * time.Millisecond, 2},
+		{"+whole", 3 * time.Second, 3},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := retryAfter(tt.wait); got != tt.want {
+				t.Fatalf("retryAfter() expected: %d, but got: %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -16,7 +16,10 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -25,7 +28,13 @@ import (
 	"strings"
 	"time"
 
+	"git.zabbix.com/ZT/kafka-connector/auth"
+	"git.zabbix.com/ZT/kafka-connector/correlation"
+	"git.zabbix.com/ZT/kafka-connector/health"
 	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ZT/kafka-connector/otlp"
+	"git.zabbix.com/ZT/kafka-connector/systemd"
 	"git.zabbix.com/ap/plugin-support/errs"
 	"git.zabbix.com/ap/plugin-support/log"
 	"git.zabbix.com/ap/plugin-support/zbxnet"
@@ -35,10 +44,31 @@ const (
 	contentType        = "Content-Type"
 	applicationXndJSON = "application/x-ndjson"
 	applicationJSON    = "application/json"
+
+	// clientHeader is the Kafka message header holding the name of the client which sent the record.
+	clientHeader = "zabbix-client"
+	// defaultClient is the name of the client authenticated with the Connector.BearerToken.
+	defaultClient = "default"
+
+	bearerTokenAuth = "bearer token"
+	certificateAuth = "client certificate"
+)
+
+// Supported encodings of the data produced to Kafka.
+const (
+	// EncodingRaw produces every received record as is, one Kafka message per record.
+	EncodingRaw Encoding = iota
+	// EncodingOTLP produces OTLP protobuf requests, one Kafka message per HTTP request.
+	EncodingOTLP
 )
 
 var _ http.ResponseWriter = &BufferedResponseWriter{}
 
+var errTooManyRecords = errs.New("too many records in request")
+
+// Encoding defines how received data is encoded before it is produced to Kafka.
+type Encoding int
+
 // BufferedResponseWriter response writer for http handler.
 type BufferedResponseWriter struct {
 	w      http.ResponseWriter
@@ -46,10 +76,75 @@ type BufferedResponseWriter struct {
 	code   int
 	header http.Header
 }
+
+// Options holds optional request processing features of the router.
+type Options struct {
+	Encoding Encoding
+	// Correlator is optional, if set problem and recovery events are correlated before they are produced.
+	Correlator *correlation.Correlator
+	// Dedup is optional, if enabled duplicate records are dropped before they are produced.
+	Dedup *DedupConfiguration
+	// MaxDecompressedSize limits decompressed request bodies (in bytes), zero means no limit.
+	MaxDecompressedSize int64
+	// MaxBodySize limits request bodies as received (in bytes), zero means no limit.
+	MaxBodySize int64
+	// MaxRecords limits the amount of records in a request, zero means no limit.
+	MaxRecords int
+	// Streaming produces every record as soon as it is decoded, instead of after the whole request is read.
+	Streaming bool
+	// PassThrough produces records with the exact bytes received, instead of re-encoding them.
+	PassThrough bool
+	// Tokens is optional, if set bearer tokens are additionally checked against named client tokens.
+	Tokens *auth.Tokens
+	// Certificates is optional, if set verified client certificates must match the allow-list,
+	// requests without a client certificate fall back to bearer token authentication.
+	Certificates *auth.Certificates
+	// RateLimit is optional, if set ingest requests are limited per client and in total.
+	RateLimit *RateLimitConfiguration
+	// MaxQueueDepth is the producer queue high-water mark at which requests are rejected, zero means no limit.
+	MaxQueueDepth int
+	// QueueRetryAfter is the time after which requests rejected due to a full queue should be retried.
+	QueueRetryAfter time.Duration
+	// ProduceTimeout limits the time spent queueing the records of a request, zero means no limit.
+	ProduceTimeout time.Duration
+	// Admin is optional, if set the admin API exposes its configuration and status and reloads through it.
+	Admin Admin
+	// Pause is optional, if set it is the pause state of ingestion, so it can also be paused outside of the server.
+	Pause *Pause
+	// Inflight is optional, if set it tracks the ingest requests being handled, so shutdown can wait for them.
+	Inflight *Inflight
+}
+
 type handler struct {
 	authToken    string
+	tokens       *auth.Tokens
+	certs        *auth.Certificates
 	producer     kafka.Producer
 	allowedPeers *zbxnet.AllowedPeers
+	encoding     Encoding
+	correlator   *correlation.Correlator
+	dedup        *dedup
+	eventFields  []string
+	itemFields   []string
+	limiter      *limiter
+	admin        Admin
+	pause        *Pause
+	inflight     *Inflight
+
+	maxDecompressed int64
+	maxBody         int64
+	maxRecords      int
+	streaming       bool
+	passThrough     bool
+	maxQueueDepth   int
+	queueRetryAfter time.Duration
+	produceTimeout  time.Duration
+}
+
+// acceptedError is the failure of a request after its first records were already produced.
+type acceptedError struct {
+	err      error
+	accepted int
 }
 
 type event struct {
@@ -62,6 +157,74 @@ type item struct {
 	Data   string `json:"data"`
 }
 
+// pending is the state a record changes once it is delivered: its deduplication identity, remembered unless
+// the record is not delivered, and its correlation update, applied once it is delivered.
+type pending struct {
+	key    string
+	update *correlation.Update
+}
+
+// tracked is a record handed to the producer with the state it changes once delivered,
+// a nil delivery is a record that is handled without being produced, like a duplicate.
+type tracked struct {
+	pending
+	delivery *kafka.Delivery
+}
+
+// ingest is the state of producing the records of one request until they are settled.
+type ingest struct {
+	headers []kafka.Header
+	batch   *correlation.Batch
+	records []tracked
+}
+
+// deliveryError is the failure of a record handed to the producer to be delivered.
+type deliveryError struct {
+	err error
+}
+
+func (e *acceptedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *acceptedError) Unwrap() error {
+	return e.err
+}
+
+func (e *deliveryError) Error() string {
+	return "record not delivered: " + e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+// track adds a record handed to the producer, or dropped as a duplicate if the delivery is nil.
+func (in *ingest) track(p pending, d *kafka.Delivery) {
+	in.records = append(in.records, tracked{p, d})
+}
+
+// withAccepted returns the request error with the amount of records produced before it, if any were.
+func withAccepted(err error, accepted int) error {
+	if accepted == 0 {
+		return err
+	}
+
+	return &acceptedError{err, accepted}
+}
+
+// ParseEncoding returns the encoding matching the configuration value.
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "", "raw":
+		return EncodingRaw, nil
+	case "otlp":
+		return EncodingOTLP, nil
+	}
+
+	return 0, errs.Errorf("unsupported encoding %q", s)
+}
+
 // ServerInit initializes a http server with provided parameters.
 func ServerInit(port string, router http.Handler, timeout int) *http.Server {
 	return &http.Server{
@@ -71,25 +234,88 @@ func ServerInit(port string, router http.Handler, timeout int) *http.Server {
 	}
 }
 
-// Run starts the server.
-func Run(server *http.Server, cert, key string, tls bool, errors chan<- error) {
+// Listen returns the listener of the server, the first socket passed by systemd socket activation if there is any,
+// otherwise a socket bound to the server address.
+func Listen(server *http.Server) (net.Listener, error) {
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to use systemd sockets")
+	}
+
+	if len(listeners) == 0 {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return nil, errs.Wrap(err, "failed to listen")
+		}
+
+		return l, nil
+	}
+
+	for _, l := range listeners[1:] {
+		log.Warningf("ignoring additional systemd socket %s", l.Addr())
+		l.Close() //nolint:errcheck,gosec // the socket is unused
+	}
+
+	log.Infof("using systemd socket %s", listeners[0].Addr())
+
+	return listeners[0], nil
+}
+
+// Run starts the server on the listener.
+func Run(server *http.Server, l net.Listener, cert, key string, tls bool, errors chan<- error) {
 	if tls {
-		runTLS(server, cert, key, errors)
+		runTLS(server, l, cert, key, errors)
 
 		return
 	}
 
-	run(server, errors)
+	run(server, l, errors)
 }
 
 // NewRouter creates a mux http handler with all the routing handled.
-func NewRouter(producer *kafka.DefaultProducer, auth string, allowedIPs *zbxnet.AllowedPeers) http.Handler {
+func NewRouter(
+	producer kafka.Producer, authToken string, allowedIPs *zbxnet.AllowedPeers, opts *Options,
+) http.Handler {
 	router := http.NewServeMux()
 
 	h := handler{
-		authToken:    auth,
+		authToken:    authToken,
+		tokens:       opts.Tokens,
+		certs:        opts.Certificates,
 		producer:     producer,
 		allowedPeers: allowedIPs,
+		encoding:     opts.Encoding,
+		correlator:   opts.Correlator,
+		admin:        opts.Admin,
+		pause:        opts.Pause,
+		inflight:     opts.Inflight,
+
+		maxDecompressed: opts.MaxDecompressedSize,
+		maxBody:         opts.MaxBodySize,
+		maxRecords:      opts.MaxRecords,
+		streaming:       opts.Streaming,
+		passThrough:     opts.PassThrough,
+		maxQueueDepth:   opts.MaxQueueDepth,
+		queueRetryAfter: opts.QueueRetryAfter,
+		produceTimeout:  opts.ProduceTimeout,
+	}
+
+	if h.pause == nil {
+		h.pause = newPause()
+	}
+
+	if h.inflight == nil {
+		h.inflight = NewInflight()
+	}
+
+	if opts.RateLimit != nil {
+		h.limiter = newLimiter(opts.RateLimit)
+	}
+
+	if opts.Dedup != nil && opts.Dedup.Enable {
+		h.dedup = newDedup(opts.Dedup)
+		h.eventFields = splitFields(opts.Dedup.EventFields)
+		h.itemFields = splitFields(opts.Dedup.ItemFields)
 	}
 
 	router.HandleFunc(
@@ -97,7 +323,20 @@ func NewRouter(producer *kafka.DefaultProducer, auth string, allowedIPs *zbxnet.
 		allowedMethodsMW(
 			[]string{http.MethodPost},
 			h.accessMW(
-				errorHandlingMW(h.events),
+				auth.PermissionEvents,
+				h.pauseMW(
+					h.inflightMW(
+						h.limitMW(
+							h.backpressureMW(
+								h.bodyLimitMW(
+									h.decompressMW(
+										errorHandlingMW(h.events),
+									),
+								),
+							),
+						),
+					),
+				),
 			),
 		),
 	)
@@ -107,7 +346,44 @@ func NewRouter(producer *kafka.DefaultProducer, auth string, allowedIPs *zbxnet.
 		allowedMethodsMW(
 			[]string{http.MethodPost},
 			h.accessMW(
-				errorHandlingMW(h.items),
+				auth.PermissionItems,
+				h.pauseMW(
+					h.inflightMW(
+						h.limitMW(
+							h.backpressureMW(
+								h.bodyLimitMW(
+									h.decompressMW(
+										errorHandlingMW(h.items),
+									),
+								),
+							),
+						),
+					),
+				),
+			),
+		),
+	)
+
+	router.HandleFunc(
+		"/api/v1/metrics",
+		allowedMethodsMW(
+			[]string{http.MethodGet},
+			h.accessMW(
+				auth.PermissionAdmin,
+				errorHandlingMW(h.metrics),
+			),
+		),
+	)
+
+	h.adminRoutes(router)
+
+	// health is not authenticated, so probes do not need credentials.
+	router.HandleFunc(
+		"/api/v1/health",
+		allowedMethodsMW(
+			[]string{http.MethodGet},
+			h.ipMW(
+				errorHandlingMW(h.health),
 			),
 		),
 	)
@@ -139,22 +415,24 @@ func (b *BufferedResponseWriter) WriteHeader(code int) {
 func (b *BufferedResponseWriter) WriteResponse() {
 	b.w.Header().Set("Content-Type", applicationJSON)
 	b.w.Header().Set("X-Content-Type-Options", "nosniff")
-	b.w.WriteHeader(b.code)
 
+	// headers must be set before the status code is written, otherwise they are dropped.
 	for k, v := range b.header {
 		for _, vv := range v {
 			b.w.Header().Add(k, vv)
 		}
 	}
 
+	b.w.WriteHeader(b.code)
+
 	_, err := b.w.Write(b.buffer.Bytes())
 	if err != nil {
 		log.Errf("failed to write response %s", err)
 	}
 }
 
-//nolint:revive // checks 3 things no reason to split up because of complexity
-func (h *handler) accessMW(handler http.HandlerFunc) http.HandlerFunc {
+// ipMW rejects requests from addresses not allowed by Connector.AllowedIP.
+func (h *handler) ipMW(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := h.checkIP(r)
 		if err != nil {
@@ -172,8 +450,15 @@ func (h *handler) accessMW(handler http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if h.authToken != "" {
-			code, err := h.validateBearerToken(r)
+		handler(w, r)
+	}
+}
+
+//nolint:revive // checks 3 things no reason to split up because of complexity
+func (h *handler) accessMW(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return h.ipMW(func(w http.ResponseWriter, r *http.Request) {
+		if h.authenticated() {
+			client, code, err := h.authorize(r, permission)
 			if err != nil {
 				write(
 					w,
@@ -181,13 +466,15 @@ func (h *handler) accessMW(handler http.HandlerFunc) http.HandlerFunc {
 					jsonResponse(
 						map[string]string{
 							"response": "fail",
-							"error":    fmt.Sprintf("bearer token validation failed, %s", err.Error()),
+							"error":    fmt.Sprintf("%s validation failed, %s", h.authMethod(r), err.Error()),
 						},
 					),
 				)
 
 				return
 			}
+
+			r = r.WithContext(auth.WithClient(r.Context(), client))
 		}
 
 		ct := r.Header.Get(contentType)
@@ -206,10 +493,45 @@ func (h *handler) accessMW(handler http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		handler(w, r)
+	})
+}
+
+// bodyLimitMW rejects request bodies larger than the configured limit.
+func (h *handler) bodyLimitMW(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.maxBody <= 0 {
+			handler(w, r)
+
+			return
+		}
+
+		if r.ContentLength > h.maxBody {
+			write(
+				w,
+				http.StatusRequestEntityTooLarge,
+				jsonResponse(
+					map[string]string{
+						"response": "fail",
+						"error":    fmt.Sprintf("request body larger than %d bytes", h.maxBody),
+					},
+				),
+			)
+
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+
 		handler(w, r)
 	}
 }
 
+// authenticated returns true if clients are authenticated by a bearer token or a client certificate.
+func (h *handler) authenticated() bool {
+	return h.authToken != "" || h.tokens != nil || h.certs != nil
+}
+
 func (h *handler) checkIP(req *http.Request) error {
 	host, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
@@ -223,32 +545,128 @@ func (h *handler) checkIP(req *http.Request) error {
 	return nil
 }
 
-func (h *handler) validateBearerToken(r *http.Request) (int, error) {
+// authorize validates the bearer token and checks the client has the permission.
+func (h *handler) authorize(r *http.Request, permission string) (*auth.Client, int, error) {
+	client, code, err := h.authenticate(r)
+	if err != nil {
+		return nil, code, err
+	}
+
+	if !client.Allowed(permission) {
+		return nil, http.StatusForbidden, errs.Errorf("client %s is not allowed to access %s", client.Name, permission)
+	}
+
+	return client, 0, nil
+}
+
+// authenticate returns the client of a verified client certificate, if there is none, of the bearer token.
+func (h *handler) authenticate(r *http.Request) (*auth.Client, int, error) {
+	if h.authMethod(r) == bearerTokenAuth {
+		return h.validateBearerToken(r)
+	}
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, http.StatusUnauthorized, errs.New("certificate required")
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	client, ok := h.certs.Lookup(cert)
+	if !ok {
+		return nil, http.StatusForbidden, errs.Errorf("%s is not allowed", cert.Subject.String())
+	}
+
+	return client, 0, nil
+}
+
+// authMethod returns the authentication method of the request, client certificates take precedence
+// over bearer tokens when both are configured.
+func (h *handler) authMethod(r *http.Request) string {
+	if h.certs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return certificateAuth
+	}
+
+	if h.authToken != "" || h.tokens != nil {
+		return bearerTokenAuth
+	}
+
+	return certificateAuth
+}
+
+// validateBearerToken returns the client of the bearer token, tokens are compared in constant time.
+func (h *handler) validateBearerToken(r *http.Request) (*auth.Client, int, error) {
 	splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")
 
 	if len(splitToken) < 2 {
-		return http.StatusBadRequest, errs.New("failed to retrieve bearer auth token")
+		return nil, http.StatusBadRequest, errs.New("failed to retrieve bearer auth token")
 	}
 
-	if h.authToken != splitToken[1] {
-		return http.StatusUnauthorized, errs.New("incorrect bearer auth token")
+	if h.authToken != "" && subtle.ConstantTimeCompare([]byte(h.authToken), []byte(splitToken[1])) == 1 {
+		return auth.NewClient(
+			defaultClient, auth.PermissionItems, auth.PermissionEvents, auth.PermissionAdmin,
+		), 0, nil
+	}
+
+	if h.tokens != nil {
+		client, ok := h.tokens.Lookup(splitToken[1])
+		if ok {
+			return client, 0, nil
+		}
 	}
 
-	return 0, nil
+	return nil, http.StatusUnauthorized, errs.New("incorrect bearer auth token")
 }
 
 func (h handler) events(w http.ResponseWriter, r *http.Request) error {
-	events, err := decodeEvents(r.Body)
+	var batch []event
+
+	ctx, cancel := h.produceContext(r)
+	defer cancel()
+
+	in := h.newIngest(clientHeaders(r))
+
+	// records are produced right away when streaming, otlp encoding always needs the whole request.
+	stream := h.streaming && h.encoding != EncodingOTLP
+
+	count, err := decodeEvents(r.Body, h.maxRecords, h.passThrough, func(e event) error {
+		if stream {
+			return h.produceEvent(ctx, in, &e)
+		}
+
+		batch = append(batch, e)
+
+		return nil
+	})
+	h.chargeRecords(r, count)
+
 	if err != nil {
-		return errs.Wrap(err, "failed to read request")
+		return h.fail(ctx, in, errs.Wrap(err, "failed to read request"))
 	}
 
-	if len(events) == 0 {
+	countRequest(r, count)
+
+	if count == 0 {
 		return errs.New("empty request")
 	}
 
-	for _, v := range events {
-		h.producer.ProduceEvent(strconv.Itoa(v.EventID), v.Data)
+	if h.encoding == EncodingOTLP {
+		err = h.produceOTLPEvents(ctx, in, batch)
+	} else {
+		for i := range batch {
+			err = h.produceEvent(ctx, in, &batch[i])
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return h.fail(ctx, in, err)
+	}
+
+	accepted, err := h.settle(ctx, in)
+	if err != nil {
+		return withAccepted(err, accepted)
 	}
 
 	write(
@@ -265,17 +683,54 @@ func (h handler) events(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h handler) items(w http.ResponseWriter, r *http.Request) error {
-	items, err := decodeItems(r.Body)
+	var batch []item
+
+	ctx, cancel := h.produceContext(r)
+	defer cancel()
+
+	in := h.newIngest(clientHeaders(r))
+
+	stream := h.streaming && h.encoding != EncodingOTLP
+
+	count, err := decodeItems(r.Body, h.maxRecords, h.passThrough, func(i item) error {
+		if stream {
+			return h.produceItem(ctx, in, &i)
+		}
+
+		batch = append(batch, i)
+
+		return nil
+	})
+	h.chargeRecords(r, count)
+
 	if err != nil {
-		return errs.Wrap(err, "failed to read request")
+		return h.fail(ctx, in, errs.Wrap(err, "failed to read request"))
 	}
 
-	if len(items) == 0 {
+	countRequest(r, count)
+
+	if count == 0 {
 		return errs.New("empty request")
 	}
 
-	for _, v := range items {
-		h.producer.ProduceItem(strconv.Itoa(v.ItemID), v.Data)
+	if h.encoding == EncodingOTLP {
+		err = h.produceOTLPItems(ctx, in, batch)
+	} else {
+		for i := range batch {
+			err = h.produceItem(ctx, in, &batch[i])
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return h.fail(ctx, in, err)
+	}
+
+	accepted, err := h.settle(ctx, in)
+	if err != nil {
+		return withAccepted(err, accepted)
 	}
 
 	write(
@@ -291,6 +746,448 @@ func (h handler) items(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// health responds with the status of all components, 503 Service Unavailable if any of them is down.
+func (h handler) health(w http.ResponseWriter, _ *http.Request) error {
+	status, components := health.Snapshot()
+
+	out, err := json.Marshal(map[string]any{"status": status, "components": components})
+	if err != nil {
+		return errs.Wrap(err, "failed to marshal health")
+	}
+
+	code := http.StatusOK
+	if status != health.StatusOK && status != health.StatusDegraded {
+		code = http.StatusServiceUnavailable
+	}
+
+	write(w, code, string(out))
+
+	return nil
+}
+
+// Alive reports whether the router handles requests, by handling a health request in process. The request waits
+// for any lock the health reports need, so a deadlocked connector does not return. Any response counts, as the
+// request may be rejected by the Connector.AllowedIP check.
+func Alive(router http.Handler) bool {
+	r, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/api/v1/health", http.NoBody)
+	if err != nil {
+		return false
+	}
+
+	r.RemoteAddr = "127.0.0.1:0"
+
+	w := &BufferedResponseWriter{header: http.Header{}}
+
+	router.ServeHTTP(w, r)
+
+	return w.code != 0
+}
+
+func (h handler) metrics(w http.ResponseWriter, _ *http.Request) error {
+	out, err := json.Marshal(metrics.Snapshot())
+	if err != nil {
+		return errs.Wrap(err, "failed to marshal metrics")
+	}
+
+	write(w, http.StatusOK, string(out))
+
+	return nil
+}
+
+func (h handler) produceEvent(ctx context.Context, in *ingest, e *event) error {
+	p, ok, err := h.prepareEvent(in.batch, e)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		in.track(p, nil)
+
+		return nil
+	}
+
+	d, err := h.producer.ProduceEvent(
+		ctx, &kafka.Record{Key: strconv.Itoa(e.EventID), Value: []byte(e.Data), Headers: in.headers},
+	)
+	if err != nil {
+		h.release([]pending{p})
+
+		return errs.Wrap(err, "failed to produce event")
+	}
+
+	in.track(p, d)
+
+	return nil
+}
+
+func (h handler) produceItem(ctx context.Context, in *ingest, i *item) error {
+	p, ok := h.prepareItem(i)
+	if !ok {
+		in.track(p, nil)
+
+		return nil
+	}
+
+	d, err := h.producer.ProduceItem(
+		ctx, &kafka.Record{Key: strconv.Itoa(i.ItemID), Value: []byte(i.Data), Headers: in.headers},
+	)
+	if err != nil {
+		h.release([]pending{p})
+
+		return errs.Wrap(err, "failed to produce item value")
+	}
+
+	in.track(p, d)
+
+	return nil
+}
+
+// produceContext returns the context for producing the records of a request, it is canceled when the client
+// disconnects, the produce timeout runs out or shutdown cancels in-flight requests.
+func (h handler) produceContext(r *http.Request) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	if h.produceTimeout <= 0 {
+		ctx, cancel = context.WithCancel(r.Context())
+	} else {
+		ctx, cancel = context.WithTimeout(r.Context(), h.produceTimeout)
+	}
+
+	untrack := h.inflight.track(cancel)
+
+	return ctx, func() {
+		untrack()
+		cancel()
+	}
+}
+
+// newIngest returns the state of producing the records of a request, with the correlation batch of its events
+// if correlation is enabled.
+func (h handler) newIngest(headers []kafka.Header) *ingest {
+	in := &ingest{headers: headers}
+
+	if h.correlator != nil {
+		in.batch = h.correlator.NewBatch()
+	}
+
+	return in
+}
+
+// prepareEvent drops duplicate events and correlates problem and recovery events within the batch of the request.
+// Returns false if the event must not be produced.
+func (h handler) prepareEvent(b *correlation.Batch, e *event) (pending, bool, error) {
+	var p pending
+
+	if h.dedup != nil {
+		key, dup := h.duplicate("e", e.Data, h.eventFields)
+		if dup {
+			log.Debugf("dropping duplicate event with ID %d", e.EventID)
+
+			return p, false, nil
+		}
+
+		p.key = key
+	}
+
+	if h.correlator == nil {
+		return p, true, nil
+	}
+
+	if b == nil {
+		b = h.correlator.NewBatch()
+	}
+
+	data, update, err := b.Correlate(e.Data)
+	if err != nil {
+		h.release([]pending{p})
+
+		return p, false, errs.Wrap(err, "failed to correlate event")
+	}
+
+	e.Data = data
+	p.update = update
+
+	return p, true, nil
+}
+
+// prepareItem drops duplicate item values, returns false if the value must not be produced.
+func (h handler) prepareItem(i *item) (pending, bool) {
+	if h.dedup == nil {
+		return pending{}, true
+	}
+
+	key, dup := h.duplicate("i", i.Data, h.itemFields)
+	if dup {
+		log.Debugf("dropping duplicate item value with ID %d", i.ItemID)
+
+		return pending{}, false
+	}
+
+	return pending{key: key}, true
+}
+
+// settle waits until the records of a request are delivered, at most until the context is done, and returns
+// the amount of records delivered or dropped as duplicates. Deduplication identities of records that are not
+// delivered are forgotten, so they are produced when the request is retried. The problem state updates of
+// delivered events are produced and, once delivered too, applied to the open problems in the request order.
+func (h handler) settle(ctx context.Context, in *ingest) (int, error) {
+	var (
+		delivered []tracked
+		failed    []pending
+		first     error
+	)
+
+	for _, t := range in.records {
+		err := wait(ctx, t.delivery)
+		if err != nil {
+			failed = append(failed, t.pending)
+
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		delivered = append(delivered, t)
+	}
+
+	h.release(failed)
+
+	err := h.commit(ctx, delivered, in.headers)
+	if err != nil && first == nil {
+		first = err
+	}
+
+	return len(delivered), first
+}
+
+// fail settles the records produced before the request failed and returns the request error, with the amount
+// of records delivered before the failure.
+func (h handler) fail(ctx context.Context, in *ingest, err error) error {
+	accepted, serr := h.settle(ctx, in)
+	if serr != nil {
+		log.Debugf("records produced before the request failed are not all delivered, %s", serr.Error())
+	}
+
+	return withAccepted(err, accepted)
+}
+
+// commit produces the problem state updates of delivered events and applies the ones that are delivered in turn.
+// Events whose update is not delivered are forgotten, so they are correlated again when the request is retried.
+func (h handler) commit(ctx context.Context, ts []tracked, headers []kafka.Header) error {
+	states := make([]*kafka.Delivery, len(ts))
+
+	for i, t := range ts {
+		if t.update == nil {
+			continue
+		}
+
+		r := &kafka.Record{Key: t.update.Key, Headers: headers}
+
+		// resolved problems are removed from the compacted topic with a tombstone.
+		if t.update.Data != "" {
+			r.Value = []byte(t.update.Data)
+		}
+
+		d, err := h.producer.ProduceProblem(ctx, r)
+		if err != nil {
+			d = kafka.Delivered(errs.Wrap(err, "failed to produce problem state"))
+		}
+
+		states[i] = d
+	}
+
+	var first error
+
+	for i, t := range ts {
+		if t.update == nil {
+			continue
+		}
+
+		err := wait(ctx, states[i])
+		if err != nil {
+			h.release([]pending{t.pending})
+
+			if first == nil {
+				first = err
+			}
+
+			continue
+		}
+
+		h.correlator.Apply(t.update)
+	}
+
+	return first
+}
+
+// wait waits for the delivery of a record, a nil delivery is a record that was not produced and needs no waiting.
+func wait(ctx context.Context, d *kafka.Delivery) error {
+	if d == nil {
+		return nil
+	}
+
+	err := d.Wait(ctx)
+	if err != nil {
+		return &deliveryError{err}
+	}
+
+	return nil
+}
+
+// release forgets the deduplication identities of records that failed to be produced.
+func (h handler) release(ps []pending) {
+	if h.dedup == nil {
+		return
+	}
+
+	for _, p := range ps {
+		if p.key != "" {
+			h.dedup.forget(p.key)
+		}
+	}
+}
+
+// clientHeaders returns the Kafka headers identifying the client of the request.
+func clientHeaders(r *http.Request) []kafka.Header {
+	c := auth.FromContext(r.Context())
+	if c == nil {
+		return nil
+	}
+
+	return []kafka.Header{{Key: clientHeader, Value: c.Name}}
+}
+
+// countRequest updates the request and record counters of the client.
+func countRequest(r *http.Request, records int) {
+	c := auth.FromContext(r.Context())
+	if c == nil {
+		return
+	}
+
+	metrics.GetCounter("client." + c.Name + ".requests").Inc()
+	metrics.GetCounter("client." + c.Name + ".records").Add(uint64(records))
+}
+
+// clientName returns the name of the request client, or its address if the client is not authenticated.
+func clientName(r *http.Request) string {
+	c := auth.FromContext(r.Context())
+	if c == nil {
+		return r.RemoteAddr
+	}
+
+	return c.Name
+}
+
+// produceOTLPEvents produces all events of a request as a single OTLP logs request.
+// The message has no key, so batches are spread across the topic partitions.
+func (h handler) produceOTLPEvents(ctx context.Context, in *ingest, events []event) error {
+	out := make([]otlp.Event, 0, len(events))
+	ps := make([]pending, 0, len(events))
+
+	for i := range events {
+		p, ok, err := h.prepareEvent(in.batch, &events[i])
+		if err != nil {
+			h.release(ps)
+
+			return err
+		}
+
+		if !ok {
+			in.track(p, nil)
+
+			continue
+		}
+
+		ps = append(ps, p)
+
+		var e otlp.Event
+
+		err = json.Unmarshal([]byte(events[i].Data), &e)
+		if err != nil {
+			h.release(ps)
+
+			return errs.Wrap(err, "failed to unmarshal event for otlp encoding")
+		}
+
+		out = append(out, e)
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	d, err := h.producer.ProduceEvent(ctx, &kafka.Record{Value: otlp.EncodeLogs(out), Headers: in.headers})
+	if err != nil {
+		h.release(ps)
+
+		return errs.Wrap(err, "failed to produce otlp events")
+	}
+
+	for _, p := range ps {
+		in.track(p, d)
+	}
+
+	return nil
+}
+
+// produceOTLPItems produces all numeric item values of a request as a single OTLP metrics request.
+func (h handler) produceOTLPItems(ctx context.Context, in *ingest, items []item) error {
+	out := make([]otlp.Item, 0, len(items))
+	ps := make([]pending, 0, len(items))
+
+	for idx := range items {
+		p, ok := h.prepareItem(&items[idx])
+		if !ok {
+			in.track(p, nil)
+
+			continue
+		}
+
+		ps = append(ps, p)
+
+		var i otlp.Item
+
+		err := json.Unmarshal([]byte(items[idx].Data), &i)
+		if err != nil {
+			h.release(ps)
+
+			return errs.Wrap(err, "failed to unmarshal item for otlp encoding")
+		}
+
+		out = append(out, i)
+	}
+
+	b := otlp.EncodeMetrics(out)
+	if b == nil {
+		log.Debugf("no numeric item values in request, nothing to produce")
+
+		// the values are handled without being produced, so they are still remembered for deduplication.
+		for _, p := range ps {
+			in.track(p, nil)
+		}
+
+		return nil
+	}
+
+	d, err := h.producer.ProduceItem(ctx, &kafka.Record{Value: b, Headers: in.headers})
+	if err != nil {
+		h.release(ps)
+
+		return errs.Wrap(err, "failed to produce otlp item values")
+	}
+
+	for _, p := range ps {
+		in.track(p, d)
+	}
+
+	return nil
+}
+
 func notFoundMW(handler http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -351,22 +1248,54 @@ func errorHandlingMW(
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := handler(w, r)
 		if err != nil {
-			log.Errf("failed handle request, %s", err.Error())
+			log.Errf("failed handle request from %s, %s", clientName(r), err.Error())
 
-			write(
-				w,
-				http.StatusInternalServerError,
-				jsonResponse(
-					map[string]string{
-						"response": "fail",
-						"error":    err.Error(),
-					},
-				),
-			)
+			var openErr *kafka.CircuitOpenError
+			if errors.As(err, &openErr) {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter(openErr.RetryAfter)))
+			}
+
+			response := map[string]string{
+				"response": "fail",
+				"error":    err.Error(),
+			}
+
+			// records produced before the failure are sent again when the request is retried.
+			var acceptedErr *acceptedError
+			if errors.As(err, &acceptedErr) {
+				response["accepted"] = strconv.Itoa(acceptedErr.accepted)
+			}
+
+			write(w, errorStatus(err), jsonResponse(response))
 		}
 	}
 }
 
+// errorStatus returns the response status code for a request handling error.
+func errorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) || errors.Is(err, errTooManyRecords) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	if errors.Is(err, errInvalidAdminRequest) {
+		return http.StatusBadRequest
+	}
+
+	// records could not be queued or delivered in time or Kafka is failing, Zabbix server retries the request later.
+	var (
+		openErr     *kafka.CircuitOpenError
+		deliveryErr *deliveryError
+	)
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.As(err, &openErr) ||
+		errors.As(err, &deliveryErr) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
 func write(w http.ResponseWriter, status int, message string) {
 	w.WriteHeader(status)
 
@@ -376,94 +1305,116 @@ func write(w http.ResponseWriter, status int, message string) {
 	}
 }
 
-func decodeEvents(r io.Reader) ([]event, error) {
-	var (
-		d      any
-		events []event
-	)
-
-	decoder := json.NewDecoder(r)
+// decodeEvents decodes events one by one, calling fn for every event as soon as it is decoded.
+// Returns the amount of decoded events, decoding fails if there are more than maxRecords of them.
+func decodeEvents(r io.Reader, maxRecords int, passThrough bool, fn func(event) error) (int, error) {
+	return decodeRecords(r, maxRecords, passThrough, func(b []byte) error {
+		var e event
 
-	for decoder.More() {
-		err := decoder.Decode(&d)
+		err := json.Unmarshal(b, &e)
 		if err != nil {
-			return nil, errs.Wrap(err, "failed to decode incoming item data")
+			return errs.Wrap(err, "failed to unmarshal incoming event data")
 		}
 
-		b, err := json.Marshal(d)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed to marshal incoming item data")
-		}
+		e.Data = string(b)
 
-		var e event
+		log.Tracef("Received event with ID %d", e.EventID)
+
+		return fn(e)
+	})
+}
 
-		err = json.Unmarshal(b, &e)
+// decodeItems decodes item values one by one, calling fn for every value as soon as it is decoded.
+// Returns the amount of decoded values, decoding fails if there are more than maxRecords of them.
+func decodeItems(r io.Reader, maxRecords int, passThrough bool, fn func(item) error) (int, error) {
+	return decodeRecords(r, maxRecords, passThrough, func(b []byte) error {
+		var i item
+
+		err := json.Unmarshal(b, &i)
 		if err != nil {
-			return nil, errs.Wrap(err, "failed to unmarshal incoming item data")
+			return errs.Wrap(err, "failed to unmarshal incoming item data")
 		}
 
-		e.Data = string(b)
-
-		log.Tracef("Received event with ID %d", e.EventID)
+		i.Data = string(b)
 
-		events = append(events, e)
-	}
+		log.Tracef("Received item with ID %d", i.ItemID)
 
-	return events, nil
+		return fn(i)
+	})
 }
 
-func decodeItems(r io.Reader) ([]item, error) {
+// decodeRecords calls fn with the JSON encoding of every record. Records are re-encoded, which sorts
+// object keys and keeps numbers as received, unless passThrough is set and the received bytes are kept.
+func decodeRecords(r io.Reader, maxRecords int, passThrough bool, fn func([]byte) error) (int, error) {
 	var (
 		d     any
-		items []item
+		raw   json.RawMessage
+		count int
 	)
 
 	decoder := json.NewDecoder(r)
+	// numbers are kept as received, so unsigned values above 2^53 do not lose precision.
+	decoder.UseNumber()
 
 	for decoder.More() {
-		err := decoder.Decode(&d)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed to decode incoming item data")
-		}
+		var (
+			b   []byte
+			err error
+		)
 
-		b, err := json.Marshal(d)
-		if err != nil {
-			return nil, errs.Wrap(err, "failed to marshal incoming item data")
+		if passThrough {
+			err = decoder.Decode(&raw)
+			b = raw
+		} else {
+			err = decoder.Decode(&d)
 		}
 
-		var i item
-
-		err = json.Unmarshal(b, &i)
 		if err != nil {
-			return nil, errs.Wrap(err, "failed to unmarshal incoming item data")
+			return count, errs.Wrap(err, "failed to decode incoming data")
 		}
 
-		i.Data = string(b)
+		count++
+		if maxRecords > 0 && count > maxRecords {
+			return count, errTooManyRecords
+		}
 
-		log.Tracef("Received item with ID %d", i.ItemID)
+		if !passThrough {
+			b, err = json.Marshal(d)
+			if err != nil {
+				return count, errs.Wrap(err, "failed to marshal incoming data")
+			}
+		}
 
-		items = append(items, i)
+		err = fn(b)
+		if err != nil {
+			return count, err
+		}
 	}
 
-	return items, nil
+	return count, nil
 }
 
-func run(server *http.Server, e chan<- error) {
-	err := server.ListenAndServe()
+func run(server *http.Server, l net.Listener, e chan<- error) {
+	err := server.Serve(l)
 	if err != nil {
 		e <- errs.Wrap(err, "failed to start the server")
 	}
 }
 
-func runTLS(server *http.Server, cert, key string, e chan<- error) {
-	err := validateTLS(cert, key)
-	if err != nil {
-		e <- errs.Wrap(err, "failed to start the server")
+func runTLS(server *http.Server, l net.Listener, cert, key string, e chan<- error) {
+	// the certificate is served by the tls config, so it can be reloaded without a restart.
+	if server.TLSConfig != nil && server.TLSConfig.GetCertificate != nil {
+		cert, key = "", ""
+	} else {
+		err := validateTLS(cert, key)
+		if err != nil {
+			e <- errs.Wrap(err, "failed to start the server")
 
-		return
+			return
+		}
 	}
 
-	err = server.ListenAndServeTLS(cert, key)
+	err := server.ServeTLS(l, cert, key)
 	if err != nil {
 		e <- errs.Wrap(err, "failed to start the server")
 	}
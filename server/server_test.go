@@ -16,14 +16,27 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"git.zabbix.com/ZT/kafka-connector/auth"
+	"git.zabbix.com/ZT/kafka-connector/correlation"
+	"git.zabbix.com/ZT/kafka-connector/health"
 	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/sink"
 	"git.zabbix.com/ap/plugin-support/errs"
 	"git.zabbix.com/ap/plugin-support/zbxnet"
 	"github.com/google/go-cmp/cmp"
@@ -33,9 +46,15 @@ var _ kafka.Producer = &mockProducer{}
 var _ http.ResponseWriter = &mockWriter{}
 
 type mockProducer struct {
-	called   int
-	ids      []string
-	messages []string
+	called      int
+	ids         []string
+	messages    []string
+	headers     [][]kafka.Header
+	problemIDs  []string
+	problems    []string
+	depth       int
+	err         error
+	deliveryErr error
 }
 type mockWriter struct {
 	code     int
@@ -62,16 +81,68 @@ func (w *mockWriter) WriteHeader(statusCode int) {
 	w.code = statusCode
 }
 
-func (mp *mockProducer) ProduceItem(key, message string) {
+// settled produces records with the state of a new request and settles them like the request handlers do.
+func settled(h handler, produce func(ctx context.Context, in *ingest) error) error {
+	ctx := context.Background()
+	in := h.newIngest(nil)
+
+	err := produce(ctx, in)
+	if err != nil {
+		return h.fail(ctx, in, err)
+	}
+
+	_, err = h.settle(ctx, in)
+
+	return err
+}
+
+func settledItem(h handler, i *item) error {
+	return settled(h, func(ctx context.Context, in *ingest) error { return h.produceItem(ctx, in, i) })
+}
+
+func settledEvent(h handler, e *event) error {
+	return settled(h, func(ctx context.Context, in *ingest) error { return h.produceEvent(ctx, in, e) })
+}
+
+func (mp *mockProducer) QueueDepth() int {
+	return mp.depth
+}
+
+func (mp *mockProducer) ProduceItem(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	if mp.err != nil {
+		return nil, mp.err
+	}
+
 	mp.called++
-	mp.ids = append(mp.ids, key)
-	mp.messages = append(mp.messages, message)
+	mp.ids = append(mp.ids, r.Key)
+	mp.messages = append(mp.messages, string(r.Value))
+	mp.headers = append(mp.headers, r.Headers)
+
+	return kafka.Delivered(mp.deliveryErr), nil
 }
 
-func (mp *mockProducer) ProduceEvent(key, message string) {
+func (mp *mockProducer) ProduceEvent(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	if mp.err != nil {
+		return nil, mp.err
+	}
+
 	mp.called++
-	mp.ids = append(mp.ids, key)
-	mp.messages = append(mp.messages, message)
+	mp.ids = append(mp.ids, r.Key)
+	mp.messages = append(mp.messages, string(r.Value))
+	mp.headers = append(mp.headers, r.Headers)
+
+	return kafka.Delivered(mp.deliveryErr), nil
+}
+
+func (mp *mockProducer) ProduceProblem(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	if mp.err != nil {
+		return nil, mp.err
+	}
+
+	mp.problemIDs = append(mp.problemIDs, r.Key)
+	mp.problems = append(mp.problems, string(r.Value))
+
+	return kafka.Delivered(nil), nil
 }
 
 func (mp *mockProducer) Close() error {
@@ -447,7 +518,7 @@ func Test_handler_accessMW(t *testing.T) {
 				handlerCalled = true
 			}
 
-			h.accessMW(handlerFunc)(w, r)
+			h.accessMW(auth.PermissionItems, handlerFunc)(w, r)
 
 			if w.Code != tt.wantCode {
 				t.Fatalf(
@@ -622,7 +693,7 @@ func Test_handler_validateBearerToken(t *testing.T) {
 			h := &handler{
 				authToken: tt.fields.authToken,
 			}
-			got, err := h.validateBearerToken(r)
+			_, got, err := h.validateBearerToken(r)
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("handler.validateBearerToken() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -946,6 +1017,27 @@ func Test_handler_items(t *testing.T) {
 	}
 }
 
+func Test_notFoundMW_headers(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+
+	notFoundMW(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		write(w, http.StatusTooManyRequests, "{}")
+	})).ServeHTTP(w, r)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("notFoundMW() expected status code: %d, but got: %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// the recorder keeps the headers as they were when the status code was written.
+	if got := w.Result().Header.Get("Retry-After"); got != "5" {
+		t.Fatalf("notFoundMW() expected handler headers to be sent, but got Retry-After: %q", got)
+	}
+}
+
 func Test_notFoundMW(t *testing.T) {
 	t.Parallel()
 
@@ -1114,29 +1206,40 @@ func Test_errorHandlingMW(t *testing.T) {
 	t.Parallel()
 
 	type args struct {
-		returnErr bool
+		err error
 	}
 
 	tests := []struct {
-		name          string
-		args          args
-		wantResponse  string
-		wantErrString string
-		wantCode      int
+		name           string
+		args           args
+		wantResponse   string
+		wantErrString  string
+		wantCode       int
+		wantRetryAfter string
 	}{
 		{
 			"+valid",
-			args{false},
+			args{nil},
 			"",
 			"",
 			http.StatusOK,
+			"",
 		},
 		{
 			"-errorResponse",
-			args{true},
+			args{errs.New("handler error")},
 			"fail",
 			"Handler error.",
 			http.StatusInternalServerError,
+			"",
+		},
+		{
+			"-circuitOpen",
+			args{errs.Wrap(&kafka.CircuitOpenError{RetryAfter: 2500 * time.Millisecond}, "failed to produce")},
+			"fail",
+			"Kafka circuit breaker is open",
+			http.StatusServiceUnavailable,
+			"3",
 		},
 	}
 
@@ -1148,11 +1251,7 @@ func Test_errorHandlingMW(t *testing.T) {
 			r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
 
 			handlerFunc := func(http.ResponseWriter, *http.Request) error {
-				if tt.args.returnErr {
-					return errs.New("handler error")
-				}
-
-				return nil
+				return tt.args.err
 			}
 
 			errorHandlingMW(handlerFunc)(w, r)
@@ -1181,6 +1280,10 @@ func Test_errorHandlingMW(t *testing.T) {
 					w.Body.String(),
 				)
 			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Fatalf("errorHandlingMW()() expected Retry-After: %q, but got: %q", tt.wantRetryAfter, got)
+			}
 		})
 	}
 }
@@ -1329,7 +1432,13 @@ func Test_decodeEvents(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := decodeEvents(strings.NewReader(tt.args.events))
+			var got []event
+
+			_, err := decodeEvents(strings.NewReader(tt.args.events), 0, false, func(e event) error {
+				got = append(got, e)
+
+				return nil
+			})
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("decodeEvents() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -1402,6 +1511,14 @@ func Test_decodeItems(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"+largeUnsigned",
+			args{"{\"value\":18446744073709551615,\"itemid\":1,\"type\":3}\n"},
+			[]item{
+				{1, `{"itemid":1,"type":3,"value":18446744073709551615}`},
+			},
+			false,
+		},
 		{
 			"-malformedBody",
 			args{"\"malformed"},
@@ -1427,7 +1544,13 @@ func Test_decodeItems(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			got, err := decodeItems(strings.NewReader(tt.args.items))
+			var got []item
+
+			_, err := decodeItems(strings.NewReader(tt.args.items), 0, false, func(i item) error {
+				got = append(got, i)
+
+				return nil
+			})
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("decodeItems() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -1439,6 +1562,101 @@ func Test_decodeItems(t *testing.T) {
 	}
 }
 
+func Test_decodeItems_passThrough(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		in      string
+		want    []item
+		wantErr bool
+	}{
+		{
+			"+keepsBytes",
+			"{\"value\":18446744073709551615,\"itemid\":1,\"clock\":1.50}\n{\"itemid\":2}\n",
+			[]item{
+				{1, `{"value":18446744073709551615,"itemid":1,"clock":1.50}`},
+				{2, `{"itemid":2}`},
+			},
+			false,
+		},
+		{
+			"-malformed",
+			`{"itemid":`,
+			nil,
+			true,
+		},
+		{
+			"-unmarshalErr",
+			`"invalid":21`,
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got []item
+
+			_, err := decodeItems(strings.NewReader(tt.in), 0, true, func(i item) error {
+				got = append(got, i)
+
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeItems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("decodeItems() = %s", diff)
+			}
+		})
+	}
+}
+
+func Benchmark_decodeItems(b *testing.B) {
+	records := make([]map[string]any, 1000)
+	for i := range records {
+		records[i] = map[string]any{
+			"host":      map[string]any{"host": "Zabbix server", "name": "Zabbix server"},
+			"groups":    []string{"Zabbix servers"},
+			"item_tags": []map[string]any{{"tag": "component", "value": "cpu"}},
+			"itemid":    44457 + i,
+			"name":      "CPU utilization",
+			"clock":     1700000000,
+			"ns":        123456789,
+			"value":     12.5,
+			"type":      0,
+		}
+	}
+
+	body := getRequestString(records)
+
+	for _, bb := range []struct {
+		name        string
+		passThrough bool
+	}{
+		{"reencode", false},
+		{"passThrough", true},
+	} {
+		bb := bb
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+
+			for i := 0; i < b.N; i++ {
+				_, err := decodeItems(strings.NewReader(body), 0, bb.passThrough, func(item) error { return nil })
+				if err != nil {
+					b.Fatalf("decodeItems() unexpected error: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
 func Test_validateTLS(t *testing.T) {
 	t.Parallel()
 
@@ -1551,6 +1769,736 @@ func Test_jsonResponse(t *testing.T) {
 	}
 }
 
+func Test_handler_produceEvent_correlation(t *testing.T) {
+	t.Parallel()
+
+	c, err := correlation.New(&correlation.Configuration{Enable: true})
+	if err != nil {
+		t.Fatalf("failed to create correlator: %s", err.Error())
+	}
+
+	p := &mockProducer{}
+	h := handler{producer: p, correlator: c}
+
+	events := []event{
+		{1, `{"eventid":1,"value":1,"clock":10,"ns":0}`},
+		{2, `{"eventid":2,"value":0,"p_eventid":1,"clock":15,"ns":0}`},
+	}
+
+	err = settled(h, func(ctx context.Context, in *ingest) error {
+		for i := range events {
+			err := h.produceEvent(ctx, in, &events[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("handler.produceEvent() unexpected error: %s", err.Error())
+	}
+
+	if diff := cmp.Diff([]string{"1", "1"}, p.problemIDs); diff != "" {
+		t.Fatalf("handler.produceEvent() problem keys = %s", diff)
+	}
+
+	if p.problems[1] != "" {
+		t.Fatalf("handler.produceEvent() expected tombstone for resolved problem, but got: %s", p.problems[1])
+	}
+
+	if !strings.Contains(events[1].Data, `"problem_duration":5`) {
+		t.Fatalf("handler.produceEvent() expected recovery event with problem duration, but got: %s", events[1].Data)
+	}
+}
+
+func Test_handler_produceEvent_correlationRetry(t *testing.T) {
+	t.Parallel()
+
+	c, err := correlation.New(&correlation.Configuration{Enable: true})
+	if err != nil {
+		t.Fatalf("failed to create correlator: %s", err.Error())
+	}
+
+	p := &mockProducer{}
+	h := handler{producer: p, correlator: c}
+
+	problem := event{1, `{"eventid":1,"value":1,"clock":10,"ns":0}`}
+
+	err = settledEvent(h, &problem)
+	if err != nil {
+		t.Fatalf("handler.produceEvent() unexpected error: %s", err.Error())
+	}
+
+	p.err = errors.New("queue full")
+
+	recovery := event{2, `{"eventid":2,"value":0,"p_eventid":1,"clock":15,"ns":0}`}
+
+	err = settledEvent(h, &recovery)
+	if err == nil {
+		t.Fatalf("handler.produceEvent() expected error")
+	}
+
+	if c.Open() != 1 {
+		t.Fatalf("handler.produceEvent() expected failed recovery to keep the problem open, but got: %d", c.Open())
+	}
+
+	p.err = nil
+	recovery = event{2, `{"eventid":2,"value":0,"p_eventid":1,"clock":15,"ns":0}`}
+
+	err = settledEvent(h, &recovery)
+	if err != nil {
+		t.Fatalf("handler.produceEvent() unexpected error: %s", err.Error())
+	}
+
+	if !strings.Contains(recovery.Data, `"problem_duration":5`) || c.Open() != 0 {
+		t.Fatalf("handler.produceEvent() expected retried recovery to be enriched, but got: %s", recovery.Data)
+	}
+}
+
+func Test_handler_produceEvent_correlationNotDelivered(t *testing.T) {
+	t.Parallel()
+
+	c, err := correlation.New(&correlation.Configuration{Enable: true})
+	if err != nil {
+		t.Fatalf("failed to create correlator: %s", err.Error())
+	}
+
+	p := &mockProducer{deliveryErr: errors.New("broker not available")}
+	h := handler{producer: p, correlator: c}
+
+	problem := event{1, `{"eventid":1,"value":1,"clock":10,"ns":0}`}
+
+	err = settledEvent(h, &problem)
+	if err == nil {
+		t.Fatalf("handler.produceEvent() expected error")
+	}
+
+	if c.Open() != 0 || len(p.problemIDs) != 0 {
+		t.Fatalf("handler.produceEvent() expected undelivered problem not to be tracked, but got: %d", c.Open())
+	}
+
+	p.deliveryErr = nil
+
+	err = settledEvent(h, &problem)
+	if err != nil {
+		t.Fatalf("handler.produceEvent() unexpected error: %s", err.Error())
+	}
+
+	if c.Open() != 1 {
+		t.Fatalf("handler.produceEvent() expected delivered problem to be tracked, but got: %d", c.Open())
+	}
+}
+
+func Test_handler_produceOTLPEvents_correlation(t *testing.T) {
+	t.Parallel()
+
+	c, err := correlation.New(&correlation.Configuration{Enable: true})
+	if err != nil {
+		t.Fatalf("failed to create correlator: %s", err.Error())
+	}
+
+	p := &mockProducer{}
+	h := handler{producer: p, correlator: c, encoding: EncodingOTLP}
+
+	events := []event{
+		{1, `{"eventid":1,"value":1,"clock":10,"ns":0}`},
+		{2, `{"eventid":2,"value":0,"p_eventid":1,"clock":15,"ns":0}`},
+	}
+
+	err = settled(h, func(ctx context.Context, in *ingest) error {
+		return h.produceOTLPEvents(ctx, in, events)
+	})
+	if err != nil {
+		t.Fatalf("handler.produceOTLPEvents() unexpected error: %s", err.Error())
+	}
+
+	if c.Open() != 0 {
+		t.Fatalf("handler.produceOTLPEvents() expected problem resolved in the same request, but got: %d", c.Open())
+	}
+
+	if diff := cmp.Diff([]string{"1", "1"}, p.problemIDs); diff != "" {
+		t.Fatalf("handler.produceOTLPEvents() problem keys = %s", diff)
+	}
+
+	if p.problems[1] != "" {
+		t.Fatalf("handler.produceOTLPEvents() expected tombstone for resolved problem, but got: %s", p.problems[1])
+	}
+
+	if !strings.Contains(events[1].Data, `"problem_duration":5`) {
+		t.Fatalf("handler.produceOTLPEvents() expected recovery event with problem duration, but got: %s", events[1].Data)
+	}
+}
+
+func Test_handler_bodyLimitMW(t *testing.T) {
+	t.Parallel()
+
+	body := getRequestString([]map[string]any{{"itemid": 1}, {"itemid": 2}})
+
+	tests := []struct {
+		name          string
+		maxBody       int64
+		contentLength int64
+		wantCode      int
+		wantCalls     int
+	}{
+		{"+noLimit", 0, int64(len(body)), http.StatusCreated, 2},
+		{"+withinLimit", int64(len(body)), int64(len(body)), http.StatusCreated, 2},
+		{"-contentLength", 10, int64(len(body)), http.StatusRequestEntityTooLarge, 0},
+		{"-chunked", 16, -1, http.StatusRequestEntityTooLarge, 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &mockProducer{}
+			h := &handler{producer: p, maxBody: tt.maxBody, streaming: true}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", strings.NewReader(body))
+			r.ContentLength = tt.contentLength
+
+			h.bodyLimitMW(errorHandlingMW(h.items))(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf(
+					"handler.bodyLimitMW() expected status code: %d, but got: %d\nresponse body: %s",
+					tt.wantCode,
+					w.Code,
+					w.Body,
+				)
+			}
+
+			if p.called != tt.wantCalls {
+				t.Fatalf("handler.bodyLimitMW() expected %d produce calls, but got: %d", tt.wantCalls, p.called)
+			}
+		})
+	}
+}
+
+func Test_handler_items_maxRecords(t *testing.T) {
+	t.Parallel()
+
+	body := getRequestString([]map[string]any{{"itemid": 1}, {"itemid": 2}, {"itemid": 3}})
+
+	tests := []struct {
+		name         string
+		maxRecords   int
+		streaming    bool
+		wantCode     int
+		wantCalls    int
+		wantAccepted string
+	}{
+		{"+unlimited", 0, false, http.StatusCreated, 3, ""},
+		{"+atLimit", 3, false, http.StatusCreated, 3, ""},
+		{"-overLimit", 2, false, http.StatusRequestEntityTooLarge, 0, ""},
+		{"-overLimitStreaming", 2, true, http.StatusRequestEntityTooLarge, 2, "2"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &mockProducer{}
+			h := handler{producer: p, maxRecords: tt.maxRecords, streaming: tt.streaming}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", strings.NewReader(body))
+
+			errorHandlingMW(h.items)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("handler.items() expected status code: %d, but got: %d", tt.wantCode, w.Code)
+			}
+
+			if p.called != tt.wantCalls {
+				t.Fatalf("handler.items() expected %d produce calls, but got: %d", tt.wantCalls, p.called)
+			}
+
+			if got := unmarshalResponse(w.Body)["accepted"]; got != tt.wantAccepted {
+				t.Fatalf("handler.items() expected accepted records: %q, but got: %q", tt.wantAccepted, got)
+			}
+		})
+	}
+}
+
+func Test_handler_items_produceError(t *testing.T) {
+	t.Parallel()
+
+	body := getRequestString([]map[string]any{{"itemid": 1}, {"itemid": 2}})
+
+	timeout := fmt.Errorf("send: %w", context.DeadlineExceeded)
+	broker := errors.New("broker not available")
+
+	tests := []struct {
+		name        string
+		err         error
+		deliveryErr error
+		streaming   bool
+		wantCode    int
+	}{
+		{"+produced", nil, nil, false, http.StatusCreated},
+		{"-timeout", timeout, nil, false, http.StatusServiceUnavailable},
+		{"-timeoutStreaming", timeout, nil, true, http.StatusServiceUnavailable},
+		{"-canceled", context.Canceled, nil, false, http.StatusServiceUnavailable},
+		{"-failed", errors.New("fail"), nil, false, http.StatusInternalServerError},
+		{"-notDelivered", nil, broker, false, http.StatusServiceUnavailable},
+		{"-notDeliveredStreaming", nil, broker, true, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := handler{producer: &mockProducer{err: tt.err, deliveryErr: tt.deliveryErr}, streaming: tt.streaming}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", strings.NewReader(body))
+
+			errorHandlingMW(h.items)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("handler.items() expected status code: %d, but got: %d", tt.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func Test_handler_produceContext(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+
+	ctx, cancel := handler{}.produceContext(r)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatalf("handler.produceContext() expected no deadline without produce timeout")
+	}
+
+	ctx, cancel = handler{produceTimeout: time.Minute}.produceContext(r)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatalf("handler.produceContext() expected deadline with produce timeout")
+	}
+
+	parent, cancelParent := context.WithCancel(context.Background())
+
+	ctx, cancel = handler{produceTimeout: time.Minute}.produceContext(r.WithContext(parent))
+	defer cancel()
+
+	cancelParent()
+
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("handler.produceContext() expected cancellation when the request context is canceled")
+	}
+}
+
+func TestNewRouter_sink(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+
+	sinks := sink.NewRegistry()
+	sinks.Register(sink.NameStdout, func() (sink.Sink, error) { return sink.NewWriter("test", &out), nil })
+
+	p, err := sink.NewProducer(sinks, &sink.Configuration{Events: sink.NameStdout, Items: sink.NameStdout})
+	if err != nil {
+		t.Fatalf("sink.NewProducer() unexpected error: %s", err.Error())
+	}
+
+	ips, err := zbxnet.GetAllowedPeers("192.0.2.1")
+	if err != nil {
+		t.Fatalf("failed to parse allowed peers: %s", err.Error())
+	}
+
+	router := NewRouter(p, "", ips, &Options{MaxBodySize: 1024, MaxDecompressedSize: 1024})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/api/v1/items",
+		strings.NewReader(getRequestString([]map[string]any{{"itemid": 1}, {"itemid": 2}})),
+	)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("NewRouter() expected status code: %d, but got: %d, %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var keys []string
+
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var got struct {
+			Kind  string `json:"kind"`
+			Key   string `json:"key"`
+			Value struct {
+				ItemID int `json:"itemid"`
+			} `json:"value"`
+		}
+
+		err = json.Unmarshal([]byte(l), &got)
+		if err != nil {
+			t.Fatalf("NewRouter() expected NDJSON sink output, but got: %s", l)
+		}
+
+		if got.Kind != "items" || got.Key != strconv.Itoa(got.Value.ItemID) {
+			t.Fatalf("NewRouter() unexpected sink record: %s", l)
+		}
+
+		keys = append(keys, got.Key)
+	}
+
+	if diff := cmp.Diff([]string{"1", "2"}, keys); diff != "" {
+		t.Fatalf("NewRouter() sink record keys = %s", diff)
+	}
+}
+
+//nolint:paralleltest // uses the default health registry
+func Test_handler_health(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		wantCode int
+	}{
+		{"+ok", health.StatusOK, http.StatusOK},
+		{"+degraded", health.StatusDegraded, http.StatusOK},
+		{"-down", health.StatusDown, http.StatusServiceUnavailable},
+	}
+
+	ips, err := zbxnet.GetAllowedPeers("192.0.2.1")
+	if err != nil {
+		t.Fatalf("failed to parse allowed peers: %s", err.Error())
+	}
+
+	router := NewRouter(&mockProducer{}, "token", ips, &Options{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health.Register("test", func() health.Report { return health.Report{Status: tt.status} })
+			defer health.Unregister("test")
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/health", nil)
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("health() expected status code: %d, but got: %d", tt.wantCode, w.Code)
+			}
+
+			var got struct {
+				Status     string                   `json:"status"`
+				Components map[string]health.Report `json:"components"`
+			}
+
+			err := json.Unmarshal(w.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatalf("health() unexpected response: %s", w.Body.String())
+			}
+
+			if got.Status != tt.status || got.Components["test"].Status != tt.status {
+				t.Fatalf("health() expected status: %s, but got: %s", tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAlive(t *testing.T) {
+	t.Parallel()
+
+	for _, allowed := range []string{"127.0.0.1", "192.0.2.1"} {
+		ips, err := zbxnet.GetAllowedPeers(allowed)
+		if err != nil {
+			t.Fatalf("failed to parse allowed peers: %s", err.Error())
+		}
+
+		if !Alive(NewRouter(&mockProducer{}, "token", ips, &Options{})) {
+			t.Fatalf("Alive() expected router allowing %s to be alive", allowed)
+		}
+	}
+}
+
+func Test_handler_authorize(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "tokens")
+
+	err := os.WriteFile(file, []byte("zabbix-eu eutoken items\nops opstoken admin\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write token file: %s", err.Error())
+	}
+
+	tokens, err := auth.NewTokens(file)
+	if err != nil {
+		t.Fatalf("failed to load tokens: %s", err.Error())
+	}
+
+	tests := []struct {
+		name        string
+		authToken   string
+		token       string
+		permission  string
+		wantClient  string
+		wantCode    int
+		wantHandler bool
+	}{
+		{"+namedClient", "", "eutoken", auth.PermissionItems, "zabbix-eu", http.StatusOK, true},
+		{"+sharedToken", "shared", "shared", auth.PermissionEvents, defaultClient, http.StatusOK, true},
+		{"+namedWithShared", "shared", "eutoken", auth.PermissionItems, "zabbix-eu", http.StatusOK, true},
+		{"-notPermitted", "", "eutoken", auth.PermissionEvents, "", http.StatusForbidden, false},
+		{"-adminOnly", "", "opstoken", auth.PermissionItems, "", http.StatusForbidden, false},
+		{"-unknownToken", "", "foobar", auth.PermissionItems, "", http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ips, err := zbxnet.GetAllowedPeers("127.0.0.1")
+			if err != nil {
+				t.Fatalf("failed to get allowed peers: %s", err.Error())
+			}
+
+			h := &handler{authToken: tt.authToken, tokens: tokens, allowedPeers: ips}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+			r.RemoteAddr = "127.0.0.1:80"
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			var gotClient string
+
+			h.accessMW(tt.permission, func(_ http.ResponseWriter, r *http.Request) {
+				gotClient = auth.FromContext(r.Context()).Name
+			})(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("handler.accessMW() expected status code: %d, but got: %d", tt.wantCode, w.Code)
+			}
+
+			if (gotClient != "") != tt.wantHandler || gotClient != tt.wantClient {
+				t.Fatalf("handler.accessMW() expected client: %q, but got: %q", tt.wantClient, gotClient)
+			}
+		})
+	}
+}
+
+func Test_handler_accessMW_certificate(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "certs")
+	writeFile(t, file, []byte("zabbix-eu CN=zabbix-eu items\n"))
+
+	certs, err := auth.NewCertificates(file)
+	if err != nil {
+		t.Fatalf("failed to load certificate allow-list: %s", err.Error())
+	}
+
+	tests := []struct {
+		name       string
+		authToken  string
+		cn         string
+		token      string
+		permission string
+		wantClient string
+		wantCode   int
+	}{
+		{"+allowed", "", "zabbix-eu", "", auth.PermissionItems, "zabbix-eu", http.StatusOK},
+		{"+certificatePrecedence", "shared", "zabbix-eu", "foobar", auth.PermissionItems, "zabbix-eu", http.StatusOK},
+		{"+tokenWithoutCertificate", "shared", "", "shared", auth.PermissionItems, defaultClient, http.StatusOK},
+		{"-notAllowed", "", "zabbix-us", "", auth.PermissionItems, "", http.StatusForbidden},
+		{"-notAllowedWithToken", "shared", "zabbix-us", "shared", auth.PermissionItems, "", http.StatusForbidden},
+		{"-notPermitted", "", "zabbix-eu", "", auth.PermissionEvents, "", http.StatusForbidden},
+		{"-noCertificate", "", "", "", auth.PermissionItems, "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ips, err := zbxnet.GetAllowedPeers("127.0.0.1")
+			if err != nil {
+				t.Fatalf("failed to get allowed peers: %s", err.Error())
+			}
+
+			h := &handler{authToken: tt.authToken, certs: certs, allowedPeers: ips}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+			r.RemoteAddr = "127.0.0.1:80"
+
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			if tt.cn != "" {
+				r.TLS = &tls.ConnectionState{
+					VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: tt.cn}}}},
+				}
+			}
+
+			var gotClient string
+
+			h.accessMW(tt.permission, func(_ http.ResponseWriter, r *http.Request) {
+				gotClient = auth.FromContext(r.Context()).Name
+			})(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf(
+					"handler.accessMW() expected status code: %d, but got: %d\nresponse body: %s",
+					tt.wantCode, w.Code, w.Body.String(),
+				)
+			}
+
+			if gotClient != tt.wantClient {
+				t.Fatalf("handler.accessMW() expected client: %q, but got: %q", tt.wantClient, gotClient)
+			}
+		})
+	}
+}
+
+func Test_handler_items_clientHeader(t *testing.T) {
+	t.Parallel()
+
+	p := &mockProducer{}
+	h := handler{producer: p}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/some/path",
+		strings.NewReader(getRequestString([]map[string]any{{"itemid": 1}})),
+	)
+	r = r.WithContext(auth.WithClient(r.Context(), auth.NewClient("zabbix-eu", auth.PermissionItems)))
+
+	err := h.items(w, r)
+	if err != nil {
+		t.Fatalf("handler.items() unexpected error: %s", err.Error())
+	}
+
+	want := [][]kafka.Header{{{Key: clientHeader, Value: "zabbix-eu"}}}
+	if diff := cmp.Diff(want, p.headers); diff != "" {
+		t.Fatalf("handler.items() headers = %s", diff)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		in      string
+		want    Encoding
+		wantErr bool
+	}{
+		{"+default", "", EncodingRaw, false},
+		{"+raw", "raw", EncodingRaw, false},
+		{"+otlp", "otlp", EncodingOTLP, false},
+		{"-unknown", "avro", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseEncoding(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEncoding() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("ParseEncoding() = %s", diff)
+			}
+		})
+	}
+}
+
+func Test_handler_otlp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		path          string
+		body          []map[string]any
+		wantCallTimes int
+		wantErr       bool
+	}{
+		{
+			"+items",
+			"items",
+			[]map[string]any{
+				{"itemid": 1, "name": "CPU load", "type": 0, "value": 0.5, "host": map[string]any{"host": "foo"}},
+				{"itemid": 2, "name": "Uptime", "type": 3, "value": 42, "host": map[string]any{"host": "foo"}},
+			},
+			1,
+			false,
+		},
+		{
+			"+itemsNonNumeric",
+			"items",
+			[]map[string]any{
+				{"itemid": 1, "name": "Log", "type": 2, "value": "text"},
+			},
+			0,
+			false,
+		},
+		{
+			"+events",
+			"events",
+			[]map[string]any{
+				{"eventid": 1, "value": 1, "severity": 3, "name": "Problem"},
+				{"eventid": 2, "value": 0, "p_eventid": 1},
+			},
+			1,
+			false,
+		},
+		{
+			"-eventsInvalidField",
+			"events",
+			[]map[string]any{
+				{"eventid": 1, "severity": "high"},
+			},
+			0,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &mockProducer{}
+			h := handler{producer: p, encoding: EncodingOTLP}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/some/path", strings.NewReader(getRequestString(tt.body)))
+
+			handle := h.items
+			if tt.path == "events" {
+				handle = h.events
+			}
+
+			if err := handle(w, r); (err != nil) != tt.wantErr {
+				t.Fatalf("handler.%s() error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+
+			if p.called != tt.wantCallTimes {
+				t.Fatalf("handler.%s() expected %d produce calls, but got: %d", tt.path, tt.wantCallTimes, p.called)
+			}
+
+			for _, id := range p.ids {
+				if id != "" {
+					t.Fatalf("handler.%s() expected otlp messages without key, but got: %q", tt.path, id)
+				}
+			}
+		})
+	}
+}
+
 func getRequestString(data []map[string]any) string {
 	ndjson := new(bytes.Buffer)
 
@@ -0,0 +1,234 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// PauseShutdown is the source of the pause while the connector shuts down.
+const PauseShutdown = "shutdown"
+
+const (
+	readyHealth           = "ready"
+	flushProgressInterval = 5 * time.Second
+	// cancelWait is how long canceled in-flight requests are waited for, they return as soon as they notice.
+	cancelWait = 5 * time.Second
+)
+
+// DrainConfiguration holds the timeouts of a graceful shutdown.
+type DrainConfiguration struct {
+	// Delay is how long the server keeps answering while not ready, so load balancers stop sending requests.
+	Delay time.Duration
+	// Timeout is how long in-flight requests are waited for.
+	Timeout time.Duration
+}
+
+// Inflight tracks the ingest requests being handled, so shutdown can cancel their producing and wait for them
+// before the producer is closed.
+type Inflight struct {
+	mu       sync.Mutex
+	requests int
+	idle     chan struct{}
+	next     uint64
+	cancels  map[uint64]context.CancelFunc
+	canceled bool
+}
+
+// NewInflight returns a tracker of in-flight ingest requests.
+func NewInflight() *Inflight {
+	return &Inflight{cancels: make(map[uint64]context.CancelFunc)}
+}
+
+// Cancel cancels producing of the in-flight requests, and of requests started afterwards.
+func (f *Inflight) Cancel() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.canceled = true
+
+	for _, cancel := range f.cancels {
+		cancel()
+	}
+}
+
+// Wait waits until no ingest request is handled or the context is done.
+func (f *Inflight) Wait(ctx context.Context) error {
+	f.mu.Lock()
+
+	if f.requests == 0 {
+		f.mu.Unlock()
+
+		return nil
+	}
+
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+
+	idle := f.idle
+
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return errs.Wrapf(ctx.Err(), "%d requests still in flight", f.count())
+	}
+}
+
+func (f *Inflight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests
+}
+
+func (f *Inflight) start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+}
+
+func (f *Inflight) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests--
+
+	if f.requests == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+// track registers the cancel function of a produce context, the returned function unregisters it.
+// It does nothing on nil.
+func (f *Inflight) track(cancel context.CancelFunc) func() {
+	if f == nil {
+		return func() {}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.canceled {
+		cancel()
+
+		return func() {}
+	}
+
+	id := f.next
+	f.next++
+	f.cancels[id] = cancel
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		delete(f.cancels, id)
+	}
+}
+
+// inflightMW tracks ingest requests until they are handled.
+func (h handler) inflightMW(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.inflight.start()
+		defer h.inflight.done()
+
+		next(w, r)
+	}
+}
+
+// Drain stops the server gracefully. The health status goes down and new ingest requests are rejected,
+// then after the delay the listeners are closed and in-flight requests are waited for, at most for the timeout.
+// Requests still in flight after the timeout have their producing canceled and their connections closed, Drain
+// returns once their handlers returned, so nothing is produced after it.
+func Drain(s *http.Server, pause *Pause, inflight *Inflight, c *DrainConfiguration) error {
+	health.Register(readyHealth, func() health.Report {
+		return health.Report{Status: health.StatusDown, Details: map[string]any{"shutting_down": true}}
+	})
+
+	pause.Pause(PauseShutdown, time.Time{})
+
+	if c.Delay > 0 {
+		log.Infof("not ready, waiting %s before closing the listeners", c.Delay)
+		time.Sleep(c.Delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if err == nil {
+		return nil
+	}
+
+	log.Warningf("in-flight requests not completed in %s, canceling them", c.Timeout)
+
+	inflight.Cancel()
+	s.Close() //nolint:errcheck,gosec // the listeners are already closed by Shutdown
+
+	wctx, wcancel := context.WithTimeout(context.Background(), cancelWait)
+	defer wcancel()
+
+	werr := inflight.Wait(wctx)
+	if werr != nil {
+		return errs.Wrap(werr, "failed to cancel in-flight requests")
+	}
+
+	return errs.Wrap(err, "failed to drain in-flight requests")
+}
+
+// Flush waits until all queued records are delivered or fail, at most for the timeout, logging the progress.
+func Flush(producer kafka.Producer, timeout time.Duration) error {
+	return flush(context.Background(), producer, timeout)
+}
+
+// flush is Flush that also stops once the context is done.
+func flush(ctx context.Context, producer kafka.Producer, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() { done <- waitFlushed(ctx, producer.QueueDepth) }()
+
+	progress := time.NewTicker(flushProgressInterval)
+	defer progress.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return errs.Wrapf(err, "%d records still queued after %s", producer.QueueDepth(), timeout)
+			}
+
+			return nil
+		case <-progress.C:
+			log.Infof("waiting for %d queued records to be delivered", producer.QueueDepth())
+		}
+	}
+}
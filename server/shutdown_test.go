@@ -0,0 +1,193 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ap/plugin-support/zbxnet"
+)
+
+// blockingProducer blocks producing items until the context is done.
+type blockingProducer struct {
+	mockProducer
+	started  chan struct{}
+	canceled atomic.Bool
+}
+
+func (bp *blockingProducer) ProduceItem(ctx context.Context, _ *kafka.Record) (*kafka.Delivery, error) {
+	close(bp.started)
+
+	<-ctx.Done()
+	bp.canceled.Store(true)
+
+	return nil, ctx.Err()
+}
+
+//nolint:paralleltest // changes the global health status
+func TestDrain(t *testing.T) {
+	defer health.Unregister(readyHealth)
+
+	p := newPause()
+
+	err := Drain(&http.Server{}, p, NewInflight(), &DrainConfiguration{Delay: time.Millisecond, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Drain() unexpected error: %s", err.Error())
+	}
+
+	if s := p.Status(); !s.Paused || s.Source != PauseShutdown {
+		t.Fatalf("Drain() expected ingestion paused by shutdown, but got: %+v", s)
+	}
+
+	status, reports := health.Snapshot()
+	if status != health.StatusDown || reports[readyHealth].Status != health.StatusDown {
+		t.Fatalf("Drain() expected health status down, but got: %s", status)
+	}
+}
+
+//nolint:paralleltest // changes the global health status
+func TestDrain_cancel(t *testing.T) {
+	defer health.Unregister(readyHealth)
+
+	ips, err := zbxnet.GetAllowedPeers("127.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to parse allowed peers: %s", err.Error())
+	}
+
+	bp := &blockingProducer{started: make(chan struct{})}
+	p := newPause()
+	inflight := NewInflight()
+
+	s := &http.Server{
+		Handler:           NewRouter(bp, "", ips, &Options{Pause: p, Inflight: inflight}),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+
+	go s.Serve(l) //nolint:errcheck // fails once the server is closed
+
+	go func() {
+		resp, err := http.Post( //nolint:noctx // canceled by closing the server
+			"http://"+l.Addr().String()+"/api/v1/items",
+			"application/x-ndjson",
+			strings.NewReader(getRequestString([]map[string]any{{"itemid": 1}})),
+		)
+		if err == nil {
+			resp.Body.Close() //nolint:errcheck,gosec // the response is not checked
+		}
+	}()
+
+	<-bp.started
+
+	err = Drain(s, p, inflight, &DrainConfiguration{Timeout: 20 * time.Millisecond})
+	if err == nil {
+		t.Fatalf("Drain() expected error for a request still in flight")
+	}
+
+	if !bp.canceled.Load() {
+		t.Fatalf("Drain() expected producing of the in-flight request canceled before returning")
+	}
+
+	if n := inflight.count(); n != 0 {
+		t.Fatalf("Drain() expected no request in flight, but got: %d", n)
+	}
+}
+
+func TestInflight_Wait(t *testing.T) {
+	t.Parallel()
+
+	f := NewInflight()
+
+	err := f.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Inflight.Wait() unexpected error without requests: %s", err.Error())
+	}
+
+	f.start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = f.Wait(ctx)
+	if err == nil {
+		t.Fatalf("Inflight.Wait() expected error for a request in flight")
+	}
+
+	pctx, pcancel := context.WithCancel(context.Background())
+	untrack := f.track(pcancel)
+
+	f.Cancel()
+
+	if pctx.Err() == nil {
+		t.Fatalf("Inflight.Cancel() expected produce context canceled")
+	}
+
+	untrack()
+
+	lctx, lcancel := context.WithCancel(context.Background())
+	f.track(lcancel)()
+
+	if lctx.Err() == nil {
+		t.Fatalf("Inflight.track() expected produce context canceled after Cancel()")
+	}
+
+	go f.done()
+
+	err = f.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Inflight.Wait() unexpected error once requests are done: %s", err.Error())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		depth   int
+		wantErr string
+	}{
+		{"+empty", 0, ""},
+		{"-queued", 2, "2 records still queued"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := Flush(&mockProducer{depth: tt.depth}, 20*time.Millisecond)
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("Flush() error = %v, wantErr %q", err, tt.wantErr)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Flush() expected error to contain: %s, but got: %s", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
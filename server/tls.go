@@ -0,0 +1,151 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+
+	"git.zabbix.com/ZT/kafka-connector/certs"
+	"git.zabbix.com/ap/plugin-support/errs"
+)
+
+// TLSConfiguration holds the incoming TLS connection settings.
+type TLSConfiguration struct {
+	// KeyPair is optional, if set the server certificate is served from it, so it can be reloaded at runtime.
+	KeyPair *certs.KeyPair
+	// MinVersion is the minimum accepted TLS version, 1.2 or 1.3, defaults to 1.2.
+	MinVersion string
+	// CipherSuites is an optional comma-separated list of TLS 1.2 cipher suite names, defaults to Go defaults.
+	CipherSuites string
+	// ClientCAFile is the CA bundle used to verify client certificates, required if ClientVerify is not none.
+	ClientCAFile string
+	// ClientVerify is the client certificate verification mode, one of none, request or require.
+	ClientVerify string
+}
+
+// ParseClientVerify returns the client authentication type matching the configuration value.
+// With request, certificates are verified only if the client presents one.
+func ParseClientVerify(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return 0, errs.Errorf("unsupported client verify mode %q", s)
+}
+
+// NewTLSConfig creates the TLS configuration of the server.
+func NewTLSConfig(c *TLSConfiguration) (*tls.Config, error) {
+	verify, err := ParseClientVerify(c.ClientVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := ParseTLSVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers, err := ParseCipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		MinVersion:   version,
+		CipherSuites: ciphers,
+		ClientAuth:   verify,
+	}
+
+	if c.KeyPair != nil {
+		conf.GetCertificate = c.KeyPair.GetCertificate
+	}
+
+	if verify == tls.NoClientCert {
+		return conf, nil
+	}
+
+	if c.ClientCAFile == "" {
+		return nil, errs.New("client CA file must be set to verify client certificates")
+	}
+
+	conf.ClientCAs, err = loadCertPool(c.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// ParseTLSVersion returns the TLS version matching the configuration value.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, errs.Errorf("unsupported tls version %q, expected 1.2 or 1.3", s)
+}
+
+// ParseCipherSuites returns the IDs of a comma-separated list of cipher suite names.
+// Only cipher suites without known security issues are accepted, nil is returned for an empty list.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	supported := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		supported[c.Name] = c.ID
+	}
+
+	var out []uint16
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+
+		id, ok := supported[name]
+		if !ok {
+			return nil, errs.Errorf("unsupported cipher suite %q", name)
+		}
+
+		out = append(out, id)
+	}
+
+	return out, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to read CA file")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errs.Errorf("no certificates found in CA file %s", file)
+	}
+
+	return pool, nil
+}
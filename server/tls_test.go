@@ -0,0 +1,178 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	invalid := filepath.Join(dir, "invalid.pem")
+
+	writeFile(t, ca, pemCertificate(t, "test ca"))
+	writeFile(t, invalid, []byte("foobar"))
+
+	tests := []struct {
+		name           string
+		conf           TLSConfiguration
+		wantClientAuth tls.ClientAuthType
+		wantCAs        bool
+		wantErr        bool
+	}{
+		{"+default", TLSConfiguration{}, tls.NoClientCert, false, false},
+		{
+			"+versionAndCiphers",
+			TLSConfiguration{MinVersion: "1.3", CipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			tls.NoClientCert,
+			false,
+			false,
+		},
+		{"+none", TLSConfiguration{ClientVerify: "none", ClientCAFile: ca}, tls.NoClientCert, false, false},
+		{"+request", TLSConfiguration{ClientVerify: "request", ClientCAFile: ca}, tls.VerifyClientCertIfGiven, true, false},
+		{
+			"+require",
+			TLSConfiguration{ClientVerify: "require", ClientCAFile: ca},
+			tls.RequireAndVerifyClientCert,
+			true,
+			false,
+		},
+		{"-unknownMode", TLSConfiguration{ClientVerify: "always", ClientCAFile: ca}, 0, false, true},
+		{"-unknownVersion", TLSConfiguration{MinVersion: "1.1"}, 0, false, true},
+		{"-unknownCipher", TLSConfiguration{CipherSuites: "foobar"}, 0, false, true},
+		{"-missingCA", TLSConfiguration{ClientVerify: "require"}, 0, false, true},
+		{
+			"-missingCAFile",
+			TLSConfiguration{ClientVerify: "require", ClientCAFile: filepath.Join(dir, "foo")},
+			0,
+			false,
+			true,
+		},
+		{"-invalidCAFile", TLSConfiguration{ClientVerify: "require", ClientCAFile: invalid}, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := NewTLSConfig(&tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got.ClientAuth != tt.wantClientAuth {
+				t.Fatalf("NewTLSConfig() expected client auth: %s, but got: %s", tt.wantClientAuth, got.ClientAuth)
+			}
+
+			if (got.ClientCAs != nil) != tt.wantCAs {
+				t.Fatalf("NewTLSConfig() expected client CAs: %t, but got: %t", tt.wantCAs, got.ClientCAs != nil)
+			}
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		s       string
+		want    []uint16
+		wantErr bool
+	}{
+		{"+empty", "", nil, false},
+		{
+			"+valid",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			[]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			false,
+		},
+		{"-insecure", "TLS_RSA_WITH_RC4_128_SHA", nil, true},
+		{"-unknown", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,foobar", nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseCipherSuites(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCipherSuites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Fatalf("ParseCipherSuites() = %s", diff)
+			}
+		})
+	}
+}
+
+// pemCertificate returns a PEM encoded self-signed certificate.
+func pemCertificate(t *testing.T, cn string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err.Error())
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+
+	err := os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", path, err.Error())
+	}
+}
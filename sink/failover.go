@@ -0,0 +1,247 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+const failoverHealth = "kafka.failover"
+
+var _ Sink = &Failover{}
+
+// Cluster is a Kafka producer that can be monitored for failover.
+type Cluster interface {
+	kafka.Producer
+	Ping() error
+	Counts() kafka.Counts
+}
+
+// FailoverConfiguration holds the settings of failover from the cluster of the Kafka section to a standby cluster.
+type FailoverConfiguration struct {
+	Secondary      string `conf:"optional"`
+	CheckInterval  int    `conf:"range=1:3600,default=10"`
+	ErrorRate      int    `conf:"range=1:100,default=50"`
+	MinRecords     int    `conf:"range=1:1000000,default=10"`
+	RecoveryPeriod int    `conf:"range=0:86400,default=300"`
+}
+
+// Failover produces to the primary cluster and switches to the secondary cluster while the primary is unhealthy.
+// Clusters are checked by a single goroutine, the mutex only guards the state reported in health.
+type Failover struct {
+	mu           sync.Mutex
+	primary      *failoverCluster
+	secondary    *failoverCluster
+	active       atomic.Pointer[failoverCluster]
+	since        time.Time
+	reason       string
+	healthySince time.Time
+	errorRate    int
+	minRecords   uint64
+	recovery     time.Duration
+	now          func() time.Time
+	switches     *metrics.Counter
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+type failoverCluster struct {
+	name    string
+	cluster Cluster
+	sink    *Kafka
+	last    kafka.Counts
+}
+
+// NewFailover returns a sink producing to the primary cluster and starts monitoring the clusters.
+func NewFailover(c *FailoverConfiguration, primaryName string, primary, secondary Cluster) *Failover {
+	f := &Failover{
+		primary:    &failoverCluster{name: primaryName, cluster: primary, sink: NewKafka(primary)},
+		secondary:  &failoverCluster{name: c.Secondary, cluster: secondary, sink: NewKafka(secondary)},
+		errorRate:  c.ErrorRate,
+		minRecords: uint64(c.MinRecords),
+		recovery:   time.Duration(c.RecoveryPeriod) * time.Second,
+		now:        time.Now,
+		switches:   metrics.GetCounter("failover.switches"),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	f.primary.last = primary.Counts()
+	f.secondary.last = secondary.Counts()
+	f.active.Store(f.primary)
+	f.since = f.now()
+
+	metrics.SetFunc("failover.active", func() any { return f.active.Load().name })
+	health.Register(failoverHealth, f.health)
+
+	go f.run(time.Duration(c.CheckInterval) * time.Second)
+
+	return f
+}
+
+// Produce produces the record to the active cluster.
+func (f *Failover) Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error) {
+	return f.active.Load().sink.Produce(ctx, kind, r)
+}
+
+// QueueDepth returns the queue depth of the active cluster, records queued for the inactive cluster
+// are delivered or fail on their own.
+func (f *Failover) QueueDepth() int {
+	return f.active.Load().sink.QueueDepth()
+}
+
+// Close stops monitoring and closes both clusters.
+func (f *Failover) Close() error {
+	close(f.stop)
+	<-f.done
+
+	health.Unregister(failoverHealth)
+
+	return errors.Join(f.primary.sink.Close(), f.secondary.sink.Close())
+}
+
+func (f *Failover) run(interval time.Duration) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.check()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// check switches to the secondary cluster if the primary is unhealthy and the secondary is reachable,
+// and back to the primary once it has been healthy for the recovery period or the secondary fails.
+func (f *Failover) check() {
+	primaryErr := f.evaluate(f.primary)
+
+	if f.active.Load() == f.primary {
+		if primaryErr == nil {
+			return
+		}
+
+		err := f.secondary.cluster.Ping()
+		if err != nil {
+			log.Warningf(
+				"kafka cluster %s is unhealthy, %s, staying on it as cluster %s is unhealthy too, %s",
+				f.primary.name, primaryErr.Error(), f.secondary.name, err.Error(),
+			)
+
+			return
+		}
+
+		f.switchTo(f.secondary, primaryErr.Error())
+
+		return
+	}
+
+	secondaryErr := f.evaluate(f.secondary)
+
+	if primaryErr != nil {
+		f.healthySince = time.Time{}
+
+		return
+	}
+
+	now := f.now()
+	if f.healthySince.IsZero() {
+		f.healthySince = now
+	}
+
+	switch {
+	case secondaryErr != nil:
+		f.switchTo(f.primary, "cluster "+f.secondary.name+" is unhealthy, "+secondaryErr.Error())
+	case now.Sub(f.healthySince) >= f.recovery:
+		f.switchTo(f.primary, "cluster "+f.primary.name+" recovered")
+	}
+}
+
+// evaluate returns an error if the cluster is unreachable or too many records failed since the last check.
+func (f *Failover) evaluate(c *failoverCluster) error {
+	counts := c.cluster.Counts()
+	failed := (counts.Failed - c.last.Failed) + (counts.Dropped - c.last.Dropped)
+	total := failed + (counts.Produced - c.last.Produced)
+	c.last = counts
+
+	err := c.cluster.Ping()
+	if err != nil {
+		return err
+	}
+
+	if total >= f.minRecords && failed*100 >= total*uint64(f.errorRate) {
+		return errs.Errorf("%d of %d records failed", failed, total)
+	}
+
+	return nil
+}
+
+func (f *Failover) switchTo(c *failoverCluster, reason string) {
+	from := f.active.Load()
+
+	f.mu.Lock()
+	f.active.Store(c)
+	f.since = f.now()
+	f.reason = reason
+	f.mu.Unlock()
+
+	f.healthySince = time.Time{}
+	f.switches.Inc()
+
+	log.Warningf("switched from kafka cluster %s to %s, %s", from.name, c.name, reason)
+}
+
+// health reports the active cluster, the connector is degraded while producing to the secondary cluster.
+func (f *Failover) health() health.Report {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.active.Load()
+
+	r := health.Report{
+		Status: health.StatusOK,
+		Details: map[string]any{
+			"active":    active.name,
+			"primary":   f.primary.name,
+			"secondary": f.secondary.name,
+			"since":     f.since,
+		},
+	}
+
+	if f.reason != "" {
+		r.Details["reason"] = f.reason
+	}
+
+	if active != f.primary {
+		r.Status = health.StatusDegraded
+	}
+
+	return r
+}
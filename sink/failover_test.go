@@ -0,0 +1,201 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/health"
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+)
+
+var _ Cluster = &mockCluster{}
+
+type mockCluster struct {
+	pingErr  error
+	counts   kafka.Counts
+	produced int
+}
+
+func (m *mockCluster) ProduceItem(context.Context, *kafka.Record) (*kafka.Delivery, error) {
+	m.produced++
+
+	return kafka.Delivered(nil), nil
+}
+
+func (m *mockCluster) ProduceEvent(context.Context, *kafka.Record) (*kafka.Delivery, error) {
+	m.produced++
+
+	return kafka.Delivered(nil), nil
+}
+
+func (m *mockCluster) ProduceProblem(context.Context, *kafka.Record) (*kafka.Delivery, error) {
+	m.produced++
+
+	return kafka.Delivered(nil), nil
+}
+
+func (m *mockCluster) QueueDepth() int {
+	return 0
+}
+
+func (m *mockCluster) Close() error {
+	return nil
+}
+
+func (m *mockCluster) Ping() error {
+	return m.pingErr
+}
+
+func (m *mockCluster) Counts() kafka.Counts {
+	return m.counts
+}
+
+func TestFailover_check(t *testing.T) {
+	t.Parallel()
+
+	down := errors.New("out of brokers")
+
+	type step struct {
+		primaryPing   error
+		secondaryPing error
+		primaryFailed uint64
+		primaryOK     uint64
+		advance       time.Duration
+		wantActive    string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"+healthy", []step{{nil, nil, 0, 100, 0, "primary"}}},
+		{"+unreachable", []step{{down, nil, 0, 0, 0, "secondary"}}},
+		{"+bothUnreachable", []step{{down, down, 0, 0, 0, "primary"}}},
+		{"+errorRate", []step{{nil, nil, 10, 10, 0, "secondary"}}},
+		{"+errorRateBelowThreshold", []step{{nil, nil, 4, 16, 0, "primary"}}},
+		{"+tooFewRecords", []step{{nil, nil, 5, 0, 0, "primary"}}},
+		{
+			"+recovery",
+			[]step{
+				{down, nil, 0, 0, 0, "secondary"},
+				{nil, nil, 0, 0, time.Minute, "secondary"},
+				{nil, nil, 0, 0, 4 * time.Minute, "secondary"},
+				{nil, nil, 0, 0, time.Minute, "primary"},
+			},
+		},
+		{
+			"+recoveryInterrupted",
+			[]step{
+				{down, nil, 0, 0, 0, "secondary"},
+				{nil, nil, 0, 0, time.Minute, "secondary"},
+				{down, nil, 0, 0, 4 * time.Minute, "secondary"},
+				{nil, nil, 0, 0, time.Minute, "secondary"},
+			},
+		},
+		{
+			"+secondaryUnhealthy",
+			[]step{
+				{down, nil, 0, 0, 0, "secondary"},
+				{nil, down, 0, 0, time.Minute, "primary"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Unix(1700000000, 0)
+			primary, secondary := &mockCluster{}, &mockCluster{}
+
+			f := NewFailover(
+				&FailoverConfiguration{
+					Secondary:      "secondary",
+					CheckInterval:  3600,
+					ErrorRate:      50,
+					MinRecords:     10,
+					RecoveryPeriod: 300,
+				},
+				"primary",
+				primary,
+				secondary,
+			)
+			f.now = func() time.Time { return now }
+
+			defer f.Close() //nolint:errcheck // mock clusters do not fail to close
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				primary.pingErr, secondary.pingErr = s.primaryPing, s.secondaryPing
+				primary.counts.Failed += s.primaryFailed
+				primary.counts.Produced += s.primaryOK
+
+				f.check()
+
+				if got := f.active.Load().name; got != s.wantActive {
+					t.Fatalf("Failover.check() step %d expected active cluster: %s, but got: %s", i, s.wantActive, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFailover_Produce(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := &mockCluster{}, &mockCluster{}
+
+	f := NewFailover(
+		&FailoverConfiguration{Secondary: "secondary", CheckInterval: 3600, ErrorRate: 50, MinRecords: 1},
+		"primary",
+		primary,
+		secondary,
+	)
+
+	defer f.Close() //nolint:errcheck // mock clusters do not fail to close
+
+	_, err := f.Produce(context.Background(), KindItems, &kafka.Record{})
+	if err != nil {
+		t.Fatalf("Failover.Produce() unexpected error: %s", err.Error())
+	}
+
+	if r := f.health(); r.Status != health.StatusOK || r.Details["active"] != "primary" {
+		t.Fatalf("Failover.health() expected ok on primary, but got: %v", r)
+	}
+
+	primary.pingErr = errors.New("down")
+
+	f.check()
+
+	_, err = f.Produce(context.Background(), KindEvents, &kafka.Record{})
+	if err != nil {
+		t.Fatalf("Failover.Produce() unexpected error: %s", err.Error())
+	}
+
+	if primary.produced != 1 || secondary.produced != 1 {
+		t.Fatalf(
+			"Failover.Produce() expected one record per cluster, but got: %d and %d", primary.produced, secondary.produced,
+		)
+	}
+
+	if r := f.health(); r.Status != health.StatusDegraded || r.Details["active"] != "secondary" {
+		t.Fatalf("Failover.health() expected degraded on secondary, but got: %v", r)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Delivery policies of fan-out members.
+const (
+	// DeliveryAll requires the member to acknowledge every record.
+	DeliveryAll = "all"
+	// DeliveryAny requires at least one of the members with this policy to acknowledge every record.
+	DeliveryAny = "any"
+	// DeliveryBestEffort sends records to the member from a queue of its own, failures are only counted.
+	DeliveryBestEffort = "besteffort"
+)
+
+// bestEffortQueueSize is the number of records waiting for a best effort member before new records are dropped.
+const bestEffortQueueSize = 10000
+
+var _ Sink = &FanOut{}
+
+// Member is a sink receiving every record of a fan-out.
+type Member struct {
+	Name     string
+	Sink     Sink
+	Delivery string
+}
+
+// FanOut sends every record to all of its members.
+type FanOut struct {
+	all        []Member
+	anyOf      []Member
+	bestEffort []*bestEffortMember
+	members    []Member
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+type bestEffortMember struct {
+	Member
+	queue   chan queuedRecord
+	dropped *metrics.Counter
+}
+
+type queuedRecord struct {
+	kind   Kind
+	record *kafka.Record
+}
+
+type memberDelivery struct {
+	name     string
+	delivery *kafka.Delivery
+}
+
+// NewFanOut returns a sink sending records to all members, at least one member must not be best effort.
+func NewFanOut(members []Member) (*FanOut, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	f := &FanOut{members: members, cancel: cancel}
+
+	for _, m := range members {
+		switch m.Delivery {
+		case DeliveryAll:
+			f.all = append(f.all, m)
+		case DeliveryAny:
+			f.anyOf = append(f.anyOf, m)
+		case DeliveryBestEffort:
+			f.bestEffort = append(f.bestEffort, &bestEffortMember{
+				Member:  m,
+				queue:   make(chan queuedRecord, bestEffortQueueSize),
+				dropped: metrics.GetCounter("fanout." + m.Name + ".dropped"),
+			})
+		default:
+			cancel()
+
+			return nil, errs.Errorf(
+				"unknown delivery policy %q of %s, expected one of: %s, %s, %s",
+				m.Delivery, m.Name, DeliveryAll, DeliveryAny, DeliveryBestEffort,
+			)
+		}
+	}
+
+	if len(f.all) == 0 && len(f.anyOf) == 0 {
+		cancel()
+
+		return nil, errs.New("at least one member must have a delivery policy other than best effort")
+	}
+
+	for _, b := range f.bestEffort {
+		f.wg.Add(1)
+
+		go func(b *bestEffortMember) {
+			defer f.wg.Done()
+
+			b.run(ctx)
+		}(b)
+	}
+
+	return f, nil
+}
+
+// Produce sends the record to all members. An error is returned if a member with the all policy, or every member
+// with the any policy, does not accept the record into its queue; records already accepted by other members
+// are not withdrawn. The returned delivery succeeds once every member with the all policy, and at least one member
+// with the any policy, acknowledges the record, best effort members are not waited for.
+func (f *FanOut) Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error) {
+	all := make([]memberDelivery, 0, len(f.all))
+
+	for _, m := range f.all {
+		d, err := m.Sink.Produce(ctx, kind, r)
+		if err != nil {
+			return nil, errs.Wrapf(err, "%s did not accept the record", m.Name)
+		}
+
+		all = append(all, memberDelivery{name: m.Name, delivery: d})
+	}
+
+	var (
+		anyOf  = make([]memberDelivery, 0, len(f.anyOf))
+		anyErr error
+	)
+
+	for _, m := range f.anyOf {
+		d, err := m.Sink.Produce(ctx, kind, r)
+		if err != nil {
+			anyErr = errs.Wrapf(err, "%s did not accept the record", m.Name)
+
+			continue
+		}
+
+		anyOf = append(anyOf, memberDelivery{name: m.Name, delivery: d})
+	}
+
+	if len(f.anyOf) > 0 && len(anyOf) == 0 {
+		return nil, errs.Wrap(anyErr, "no member with the any delivery policy accepted the record")
+	}
+
+	for _, b := range f.bestEffort {
+		b.enqueue(kind, r)
+	}
+
+	return combine(all, anyOf), nil
+}
+
+// QueueDepth returns the largest queue depth of the members that are not best effort,
+// as every record is queued in each of them.
+func (f *FanOut) QueueDepth() int {
+	var depth int
+
+	for _, members := range [][]Member{f.all, f.anyOf} {
+		for _, m := range members {
+			if d := m.Sink.QueueDepth(); d > depth {
+				depth = d
+			}
+		}
+	}
+
+	return depth
+}
+
+// Close stops sending queued best effort records and closes all members.
+func (f *FanOut) Close() error {
+	f.cancel()
+	f.wg.Wait()
+
+	var errsList []error
+
+	for _, m := range f.members {
+		err := m.Sink.Close()
+		if err != nil {
+			errsList = append(errsList, errs.Wrapf(err, "failed to close %s", m.Name))
+		}
+	}
+
+	if len(errsList) > 0 {
+		return errors.Join(errsList...)
+	}
+
+	return nil
+}
+
+// combine returns a delivery resolved once every delivery of all and the first successful delivery of anyOf
+// are acknowledged, or with the error of the first member that makes this impossible.
+func combine(all, anyOf []memberDelivery) *kafka.Delivery {
+	if len(all)+len(anyOf) == 1 {
+		return append(all, anyOf...)[0].delivery
+	}
+
+	d := kafka.NewDelivery()
+
+	go func() {
+		d.Resolve(settle(all, anyOf))
+	}()
+
+	return d
+}
+
+func settle(all, anyOf []memberDelivery) error {
+	for _, m := range all {
+		<-m.delivery.Done()
+
+		err := m.delivery.Err()
+		if err != nil {
+			return errs.Wrapf(err, "%s did not deliver the record", m.name)
+		}
+	}
+
+	if len(anyOf) == 0 {
+		return nil
+	}
+
+	results := make(chan error, len(anyOf))
+
+	for _, m := range anyOf {
+		go func(m memberDelivery) {
+			<-m.delivery.Done()
+
+			err := m.delivery.Err()
+			if err != nil {
+				err = errs.Wrapf(err, "%s did not deliver the record", m.name)
+			}
+
+			results <- err
+		}(m)
+	}
+
+	var err error
+
+	for range anyOf {
+		err = <-results
+		if err == nil {
+			return nil
+		}
+	}
+
+	return errs.Wrap(err, "no member with the any delivery policy delivered the record")
+}
+
+func (b *bestEffortMember) enqueue(kind Kind, r *kafka.Record) {
+	select {
+	case b.queue <- queuedRecord{kind: kind, record: r}:
+	default:
+		b.dropped.Inc()
+	}
+}
+
+func (b *bestEffortMember) run(ctx context.Context) {
+	for {
+		select {
+		case q := <-b.queue:
+			_, err := b.Sink.Produce(ctx, q.kind, q.record)
+			if err != nil {
+				b.dropped.Inc()
+
+				log.Debugf("best effort record dropped by %s, %s", b.Name, err.Error())
+			}
+		case <-ctx.Done():
+			if n := len(b.queue); n > 0 {
+				log.Warningf("dropping %d queued best effort records of %s", n, b.Name)
+			}
+
+			return
+		}
+	}
+}
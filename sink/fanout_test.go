@@ -0,0 +1,207 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+)
+
+func TestFanOut_Produce(t *testing.T) {
+	t.Parallel()
+
+	fail := errors.New("fail")
+
+	type member struct {
+		delivery    string
+		err         error
+		deliveryErr error
+	}
+
+	tests := []struct {
+		name            string
+		members         []member
+		wantErr         bool
+		wantDeliveryErr bool
+	}{
+		{"+all", []member{{DeliveryAll, nil, nil}, {DeliveryAll, nil, nil}}, false, false},
+		{"-allNotAccepted", []member{{DeliveryAll, nil, nil}, {DeliveryAll, fail, nil}}, true, false},
+		{"-allNotDelivered", []member{{DeliveryAll, nil, nil}, {DeliveryAll, nil, fail}}, false, true},
+		{"+anyOneAccepted", []member{{DeliveryAny, fail, nil}, {DeliveryAny, nil, nil}}, false, false},
+		{"-anyNoneAccepted", []member{{DeliveryAny, fail, nil}, {DeliveryAny, fail, nil}}, true, false},
+		{"+anyOneDelivered", []member{{DeliveryAny, nil, fail}, {DeliveryAny, nil, nil}}, false, false},
+		{"-anyNoneDelivered", []member{{DeliveryAny, nil, fail}, {DeliveryAny, nil, fail}}, false, true},
+		{"+allAndAny", []member{{DeliveryAll, nil, nil}, {DeliveryAny, nil, nil}}, false, false},
+		{"-allAndAnyNoneAccepted", []member{{DeliveryAll, nil, nil}, {DeliveryAny, fail, nil}}, true, false},
+		{"-allAndAnyNoneDelivered", []member{{DeliveryAll, nil, nil}, {DeliveryAny, nil, fail}}, false, true},
+		{"+bestEffortFailureIgnored", []member{{DeliveryAll, nil, nil}, {DeliveryBestEffort, fail, nil}}, false, false},
+		{"+bestEffortNotDelivered", []member{{DeliveryAll, nil, nil}, {DeliveryBestEffort, nil, fail}}, false, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			members := make([]Member, 0, len(tt.members))
+			for i, m := range tt.members {
+				members = append(members, Member{
+					Name:     string(rune('a' + i)),
+					Sink:     &mockSink{err: m.err, delivery: kafka.Delivered(m.deliveryErr)},
+					Delivery: m.delivery,
+				})
+			}
+
+			f, err := NewFanOut(members)
+			if err != nil {
+				t.Fatalf("NewFanOut() unexpected error: %s", err.Error())
+			}
+
+			defer f.Close() //nolint:errcheck // mock sinks do not fail to close
+
+			d, err := f.Produce(context.Background(), KindItems, &kafka.Record{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FanOut.Produce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			err = d.Wait(context.Background())
+			if (err != nil) != tt.wantDeliveryErr {
+				t.Fatalf("FanOut.Produce() delivery error = %v, wantDeliveryErr %v", err, tt.wantDeliveryErr)
+			}
+		})
+	}
+}
+
+func TestFanOut_Produce_waitsForMembers(t *testing.T) {
+	t.Parallel()
+
+	slow := kafka.NewDelivery()
+	f, err := NewFanOut([]Member{
+		{Name: "fast", Sink: &mockSink{}, Delivery: DeliveryAll},
+		{Name: "slow", Sink: &mockSink{delivery: slow}, Delivery: DeliveryAll},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOut() unexpected error: %s", err.Error())
+	}
+
+	defer f.Close() //nolint:errcheck // mock sinks do not fail to close
+
+	d, err := f.Produce(context.Background(), KindItems, &kafka.Record{})
+	if err != nil {
+		t.Fatalf("FanOut.Produce() unexpected error: %s", err.Error())
+	}
+
+	select {
+	case <-d.Done():
+		t.Fatalf("FanOut.Produce() expected delivery to wait for the slow member")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	slow.Resolve(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.Wait(ctx); err != nil {
+		t.Fatalf("FanOut.Produce() unexpected delivery error: %s", err.Error())
+	}
+}
+
+func TestFanOut_bestEffort(t *testing.T) {
+	t.Parallel()
+
+	required := &mockSink{depth: 1}
+	slow := &mockSink{depth: 100}
+
+	f, err := NewFanOut([]Member{
+		{Name: "required", Sink: required, Delivery: DeliveryAll},
+		{Name: "slow", Sink: slow, Delivery: DeliveryBestEffort},
+	})
+	if err != nil {
+		t.Fatalf("NewFanOut() unexpected error: %s", err.Error())
+	}
+
+	if got := f.QueueDepth(); got != 1 {
+		t.Fatalf("FanOut.QueueDepth() expected best effort members to be excluded, but got: %d", got)
+	}
+
+	_, err = f.Produce(context.Background(), KindEvents, &kafka.Record{})
+	if err != nil {
+		t.Fatalf("FanOut.Produce() unexpected error: %s", err.Error())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for slow.produced() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("FanOut.Produce() expected record to reach best effort member")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("FanOut.Close() unexpected error: %s", err.Error())
+	}
+
+	if required.closed != 1 || slow.closed != 1 {
+		t.Fatalf("FanOut.Close() expected all members to be closed")
+	}
+}
+
+func TestNewFanOut(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		policies []string
+		wantErr  bool
+	}{
+		{"+all", []string{DeliveryAll}, false},
+		{"+mixed", []string{DeliveryAll, DeliveryAny, DeliveryBestEffort}, false},
+		{"-unknownPolicy", []string{DeliveryAll, "some"}, true},
+		{"-onlyBestEffort", []string{DeliveryBestEffort, DeliveryBestEffort}, true},
+		{"-empty", nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			members := make([]Member, 0, len(tt.policies))
+			for _, p := range tt.policies {
+				members = append(members, Member{Name: p, Sink: &mockSink{}, Delivery: p})
+			}
+
+			f, err := NewFanOut(members)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFanOut() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if f != nil {
+				f.Close() //nolint:errcheck // mock sinks do not fail to close
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ZT/kafka-connector/metrics"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// fileSuffix is the suffix of the files of a file sink.
+const fileSuffix = ".ndjson"
+
+var (
+	_ Sink = &Writer{}
+	_ Sink = &File{}
+)
+
+// Writer writes records as NDJSON lines to a writer, such as the standard output.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	records *metrics.Counter
+}
+
+// File writes records as NDJSON lines to a file per kind of record in a directory,
+// files are rotated once they reach the maximum size.
+type File struct {
+	mu         sync.Mutex
+	dir        string
+	maxSize    int64
+	maxBackups int
+	files      map[Kind]*rotatingFile
+	now        func() time.Time
+	records    *metrics.Counter
+	rotations  *metrics.Counter
+}
+
+type rotatingFile struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+// line is the NDJSON representation of a record, values that are not JSON, such as OTLP protobuf messages,
+// are base64 encoded. A tombstone has a null value.
+type line struct {
+	Kind        Kind              `json:"kind"`
+	Topic       string            `json:"topic,omitempty"`
+	Key         string            `json:"key,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Value       json.RawMessage   `json:"value,omitempty"`
+	ValueBase64 []byte            `json:"value_base64,omitempty"` //nolint:tagliatelle // snake case as Zabbix export
+}
+
+// NewWriter returns a sink writing records to w, written records are counted in the sink.<name>.records metric.
+func NewWriter(name string, w io.Writer) *Writer {
+	return &Writer{w: w, records: metrics.GetCounter("sink." + name + ".records")}
+}
+
+// NewFile returns a sink writing records to files in the directory of the configuration.
+func NewFile(c *Configuration) (*File, error) {
+	if c.FileDir == "" {
+		return nil, errs.New("file sink directory must be set")
+	}
+
+	err := os.MkdirAll(c.FileDir, 0o750)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to create file sink directory")
+	}
+
+	return &File{
+		dir:        c.FileDir,
+		maxSize:    int64(c.FileMaxSize) * 1024 * 1024,
+		maxBackups: c.FileMaxBackups,
+		files:      map[Kind]*rotatingFile{},
+		now:        time.Now,
+		records:    metrics.GetCounter("sink.file.records"),
+		rotations:  metrics.GetCounter("sink.file.rotations"),
+	}, nil
+}
+
+// Produce writes the record, the returned delivery is already resolved.
+func (w *Writer) Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, errs.Wrap(err, "record write canceled")
+	}
+
+	b, err := encodeLine(kind, r, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.w.Write(b)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to write record")
+	}
+
+	w.records.Inc()
+
+	return kafka.Delivered(nil), nil
+}
+
+// QueueDepth returns zero, records are written synchronously.
+func (w *Writer) QueueDepth() int {
+	return 0
+}
+
+// Close does nothing, the writer is owned by the caller.
+func (w *Writer) Close() error {
+	return nil
+}
+
+// Produce writes the record to the file of its kind, the returned delivery is already resolved.
+func (f *File) Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, errs.Wrap(err, "record write canceled")
+	}
+
+	b, err := encodeLine(kind, r, f.now())
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rf, err := f.file(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if rf.size > 0 && rf.size+int64(len(b)) > f.maxSize {
+		// records are kept in the current file if it can not be rotated.
+		err = f.rotate(rf)
+		if err != nil {
+			log.Errf("failed to rotate file sink file, %s", err.Error())
+		}
+	}
+
+	if rf.f == nil {
+		err = rf.open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to write record to %s", rf.path)
+	}
+
+	f.records.Inc()
+
+	return kafka.Delivered(nil), nil
+}
+
+// QueueDepth returns zero, records are written synchronously.
+func (f *File) QueueDepth() int {
+	return 0
+}
+
+// Close syncs and closes all open files.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errsList []error
+
+	for kind, rf := range f.files {
+		err := rf.close()
+		if err != nil {
+			errsList = append(errsList, err)
+		}
+
+		delete(f.files, kind)
+	}
+
+	if len(errsList) > 0 {
+		return errs.Wrap(errors.Join(errsList...), "failed to close file sink")
+	}
+
+	return nil
+}
+
+// file returns the open file of the kind, opening it for appending on first use.
+func (f *File) file(kind Kind) (*rotatingFile, error) {
+	if rf, ok := f.files[kind]; ok {
+		return rf, nil
+	}
+
+	rf := &rotatingFile{path: filepath.Join(f.dir, string(kind)+fileSuffix)}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	f.files[kind] = rf
+
+	return rf, nil
+}
+
+// rotate renames the file to the first backup, shifting older backups and removing the oldest one.
+func (f *File) rotate(rf *rotatingFile) error {
+	err := rf.close()
+	if err != nil {
+		return err
+	}
+
+	if f.maxBackups == 0 {
+		err = os.Remove(rf.path)
+		if err != nil {
+			return errs.Wrapf(err, "failed to remove %s", rf.path)
+		}
+	} else {
+		err = shiftBackups(rf.path, f.maxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.rotations.Inc()
+
+	log.Debugf("rotated file sink file %s", rf.path)
+
+	return rf.open()
+}
+
+func shiftBackups(path string, maxBackups int) error {
+	err := os.Remove(backupPath(path, maxBackups))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errs.Wrap(err, "failed to remove oldest backup")
+	}
+
+	for i := maxBackups - 1; i >= 1; i-- {
+		err = os.Rename(backupPath(path, i), backupPath(path, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errs.Wrapf(err, "failed to rename backup %d", i)
+		}
+	}
+
+	err = os.Rename(path, backupPath(path, 1))
+	if err != nil {
+		return errs.Wrapf(err, "failed to rename %s", path)
+	}
+
+	return nil
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return errs.Wrapf(err, "failed to open %s", rf.path)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() //nolint:errcheck // the stat error is returned
+
+		return errs.Wrapf(err, "failed to stat %s", rf.path)
+	}
+
+	rf.f = f
+	rf.size = info.Size()
+
+	return nil
+}
+
+func (rf *rotatingFile) close() error {
+	if rf.f == nil {
+		return nil
+	}
+
+	err := rf.f.Sync()
+	if err != nil {
+		return errs.Wrapf(err, "failed to sync %s", rf.path)
+	}
+
+	err = rf.f.Close()
+	rf.f = nil
+
+	if err != nil {
+		return errs.Wrapf(err, "failed to close %s", rf.path)
+	}
+
+	return nil
+}
+
+// encodeLine returns the NDJSON line of the record, records without a timestamp are stamped with now.
+func encodeLine(kind Kind, r *kafka.Record, now time.Time) ([]byte, error) {
+	l := line{
+		Kind:      kind,
+		Topic:     r.Topic,
+		Key:       r.Key,
+		Timestamp: r.Timestamp,
+	}
+
+	if l.Timestamp.IsZero() {
+		l.Timestamp = now
+	}
+
+	if len(r.Headers) > 0 {
+		l.Headers = make(map[string]string, len(r.Headers))
+		for _, h := range r.Headers {
+			l.Headers[h.Key] = h.Value
+		}
+	}
+
+	switch {
+	case r.Value == nil:
+		l.Value = json.RawMessage("null")
+	case json.Valid(r.Value):
+		l.Value = r.Value
+	default:
+		l.ValueBase64 = r.Value
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to encode record")
+	}
+
+	return append(b, '\n'), nil
+}
+
+// decodeLine returns the kind and the record of an NDJSON line written by encodeLine,
+// headers are sorted by key as their order is not kept.
+func decodeLine(b []byte) (Kind, *kafka.Record, error) {
+	var l line
+
+	err := json.Unmarshal(b, &l)
+	if err != nil {
+		return "", nil, errs.Wrap(err, "failed to decode record")
+	}
+
+	r := &kafka.Record{Topic: l.Topic, Key: l.Key, Timestamp: l.Timestamp}
+
+	keys := make([]string, 0, len(l.Headers))
+	for k := range l.Headers {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		r.Headers = append(r.Headers, kafka.Header{Key: k, Value: l.Headers[k]})
+	}
+
+	switch {
+	case l.ValueBase64 != nil:
+		r.Value = l.ValueBase64
+	case len(l.Value) > 0 && string(l.Value) != "null":
+		r.Value = l.Value
+	}
+
+	return l.Kind, r, nil
+}
@@ -0,0 +1,192 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+)
+
+func Test_encodeLine(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		kind   Kind
+		record kafka.Record
+		want   string
+	}{
+		{
+			"+json",
+			KindItems,
+			kafka.Record{Key: "1", Value: []byte(`{"itemid": 1}`)},
+			`{"kind":"items","key":"1","timestamp":"2025-01-02T03:04:05Z","value":{"itemid":1}}`,
+		},
+		{
+			"+headersAndTopic",
+			KindEvents,
+			kafka.Record{Value: []byte(`{}`), Topic: "other", Headers: []kafka.Header{{Key: "a", Value: "b"}}},
+			`{"kind":"events","topic":"other","timestamp":"2025-01-02T03:04:05Z","headers":{"a":"b"},"value":{}}`,
+		},
+		{
+			"+binary",
+			KindItems,
+			kafka.Record{Value: []byte{0x0a, 0x01}},
+			`{"kind":"items","timestamp":"2025-01-02T03:04:05Z","value_base64":"CgE="}`,
+		},
+		{
+			"+tombstone",
+			KindProblems,
+			kafka.Record{Key: "5"},
+			`{"kind":"problems","key":"5","timestamp":"2025-01-02T03:04:05Z","value":null}`,
+		},
+		{
+			"+recordTimestamp",
+			KindItems,
+			kafka.Record{Value: []byte(`1`), Timestamp: now.Add(time.Hour)},
+			`{"kind":"items","timestamp":"2025-01-02T04:04:05Z","value":1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := encodeLine(tt.kind, &tt.record, now)
+			if err != nil {
+				t.Fatalf("encodeLine() unexpected error: %s", err.Error())
+			}
+
+			if string(got) != tt.want+"\n" {
+				t.Fatalf("encodeLine() expected: %s, but got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriter_Produce(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	w := NewWriter("test", &buf)
+
+	d, err := w.Produce(context.Background(), KindItems, &kafka.Record{Value: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("Writer.Produce() unexpected error: %s", err.Error())
+	}
+
+	if d.Err() != nil {
+		t.Fatalf("Writer.Produce() expected successful delivery, but got: %s", d.Err().Error())
+	}
+
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("Writer.Produce() expected one line, but got: %q", buf.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = w.Produce(ctx, KindItems, &kafka.Record{}); err == nil {
+		t.Fatalf("Writer.Produce() expected error for canceled context")
+	}
+}
+
+func TestFile_Produce(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		maxBackups  int
+		records     int
+		wantFiles   []string
+		wantCurrent int
+	}{
+		{"+noRotation", 2, 1, []string{"items.ndjson"}, 1},
+		{"+rotated", 2, 3, []string{"items.ndjson", "items.ndjson.1", "items.ndjson.2"}, 1},
+		{"+oldestRemoved", 2, 5, []string{"items.ndjson", "items.ndjson.1", "items.ndjson.2"}, 1},
+		{"+noBackups", 0, 3, []string{"items.ndjson"}, 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := filepath.Join(t.TempDir(), "sink")
+
+			f, err := NewFile(&Configuration{FileDir: dir, FileMaxSize: 1, FileMaxBackups: tt.maxBackups})
+			if err != nil {
+				t.Fatalf("NewFile() unexpected error: %s", err.Error())
+			}
+
+			// each record is large enough to fill a file on its own.
+			value := []byte(`"` + strings.Repeat("a", 600*1024) + `"`)
+
+			for i := 0; i < tt.records; i++ {
+				_, err = f.Produce(context.Background(), KindItems, &kafka.Record{Value: value})
+				if err != nil {
+					t.Fatalf("File.Produce() unexpected error: %s", err.Error())
+				}
+			}
+
+			err = f.Close()
+			if err != nil {
+				t.Fatalf("File.Close() unexpected error: %s", err.Error())
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read sink directory: %s", err.Error())
+			}
+
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Fatalf("File.Produce() expected files: %v, but got: %v", tt.wantFiles, got)
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, "items.ndjson"))
+			if err != nil {
+				t.Fatalf("failed to read sink file: %s", err.Error())
+			}
+
+			if n := bytes.Count(b, []byte("\n")); n != tt.wantCurrent {
+				t.Fatalf("File.Produce() expected %d lines in current file, but got: %d", tt.wantCurrent, n)
+			}
+		})
+	}
+}
+
+func TestNewFile(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewFile(&Configuration{}); err == nil {
+		t.Fatalf("NewFile() expected error for empty directory")
+	}
+}
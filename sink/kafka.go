@@ -0,0 +1,58 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ap/plugin-support/errs"
+)
+
+var _ Sink = &Kafka{}
+
+// Kafka produces records to the topics of a Kafka producer.
+type Kafka struct {
+	producer kafka.Producer
+}
+
+// NewKafka returns a sink producing to the Kafka producer.
+func NewKafka(p kafka.Producer) *Kafka {
+	return &Kafka{producer: p}
+}
+
+// Produce produces the record to the topic of its kind.
+func (k *Kafka) Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error) {
+	switch kind {
+	case KindEvents:
+		return k.producer.ProduceEvent(ctx, r)
+	case KindItems:
+		return k.producer.ProduceItem(ctx, r)
+	case KindProblems:
+		return k.producer.ProduceProblem(ctx, r)
+	default:
+		return nil, errs.Errorf("unknown record kind %q", kind)
+	}
+}
+
+// QueueDepth returns the queue depth of the Kafka producer.
+func (k *Kafka) QueueDepth() int {
+	return k.producer.QueueDepth()
+}
+
+// Close closes the Kafka producer.
+func (k *Kafka) Close() error {
+	return k.producer.Close()
+}
@@ -0,0 +1,190 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// spoolFile is a file written by a file sink, with the number of its backup, 0 for the current file.
+type spoolFile struct {
+	path   string
+	base   string
+	backup int
+}
+
+// Replay produces the records of the files a file sink wrote to dir, such as the records spooled on shutdown,
+// and returns the number of delivered records. Backups are replayed before the current file of their kind, so
+// records are sent in the order they were written. A file is removed once all of its records are delivered,
+// replaying stops at the first file with a record that is not delivered, which is kept to be replayed again.
+func Replay(ctx context.Context, dir string, p kafka.Producer) (int, error) {
+	files, err := spoolFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int
+
+	for _, f := range files {
+		n, err := replayFile(ctx, f.path, p)
+		if err != nil {
+			return delivered, errs.Wrapf(err, "failed to replay %s", f.path)
+		}
+
+		delivered += n
+
+		err = os.Remove(f.path)
+		if err != nil {
+			return delivered, errs.Wrapf(err, "failed to remove replayed %s", f.path)
+		}
+
+		log.Infof("replayed %d records of %s", n, f.path)
+	}
+
+	return delivered, nil
+}
+
+// spoolFiles returns the files written by a file sink in dir, ordered by kind and from the oldest backup.
+func spoolFiles(dir string) ([]spoolFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errs.Wrapf(err, "failed to read %s", dir)
+	}
+
+	var files []spoolFile
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+
+		name := e.Name()
+
+		base, suffix, _ := strings.Cut(name, fileSuffix)
+		if base == name {
+			continue
+		}
+
+		f := spoolFile{path: filepath.Join(dir, name), base: base}
+
+		// backups are named as the file with the number of the backup appended.
+		if suffix != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(suffix, "."))
+			if err != nil || !strings.HasPrefix(suffix, ".") {
+				continue
+			}
+
+			f.backup = n
+		}
+
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].base != files[j].base {
+			return files[i].base < files[j].base
+		}
+
+		return files[i].backup > files[j].backup
+	})
+
+	return files, nil
+}
+
+// replayFile produces every record of the file and waits until all of them are delivered.
+func replayFile(ctx context.Context, path string, p kafka.Producer) (int, error) {
+	f, err := os.Open(path) //nolint:gosec // the path is read from the configured directory
+	if err != nil {
+		return 0, errs.Wrap(err, "failed to open file")
+	}
+
+	defer f.Close() //nolint:errcheck // the file is only read
+
+	var deliveries []*kafka.Delivery
+
+	r := bufio.NewReader(f)
+
+	for {
+		b, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, errs.Wrap(err, "failed to read file")
+		}
+
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			d, perr := replayLine(ctx, b, p)
+			if perr != nil {
+				return 0, perr
+			}
+
+			deliveries = append(deliveries, d)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	for _, d := range deliveries {
+		err = d.Wait(ctx)
+		if err != nil {
+			return 0, errs.Wrap(err, "record not delivered")
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func replayLine(ctx context.Context, b []byte, p kafka.Producer) (*kafka.Delivery, error) {
+	kind, r, err := decodeLine(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var d *kafka.Delivery
+
+	// records spilled from other topics keep their topic, so they are produced as events to it.
+	switch kind {
+	case KindItems:
+		d, err = p.ProduceItem(ctx, r)
+	case KindProblems:
+		d, err = p.ProduceProblem(ctx, r)
+	default:
+		d, err = p.ProduceEvent(ctx, r)
+	}
+
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to produce record")
+	}
+
+	return d, nil
+}
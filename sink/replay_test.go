@@ -0,0 +1,203 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ kafka.Producer = &replayProducer{}
+
+// replayProducer records the keys of produced records by kind.
+type replayProducer struct {
+	produced    []string
+	deliveryErr error
+}
+
+func (p *replayProducer) ProduceItem(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	p.produced = append(p.produced, "items:"+r.Key)
+
+	return kafka.Delivered(p.deliveryErr), nil
+}
+
+func (p *replayProducer) ProduceEvent(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	p.produced = append(p.produced, "events:"+r.Key)
+
+	return kafka.Delivered(p.deliveryErr), nil
+}
+
+func (p *replayProducer) ProduceProblem(_ context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	p.produced = append(p.produced, "problems:"+r.Key)
+
+	return kafka.Delivered(p.deliveryErr), nil
+}
+
+func (p *replayProducer) QueueDepth() int {
+	return 0
+}
+
+func (p *replayProducer) Close() error {
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writeLines(t, filepath.Join(dir, "items.ndjson.2"), KindItems, "1")
+	writeLines(t, filepath.Join(dir, "items.ndjson.1"), KindItems, "2", "3")
+	writeLines(t, filepath.Join(dir, "items.ndjson"), KindItems, "4")
+	writeLines(t, filepath.Join(dir, "problems.ndjson"), KindProblems, "5")
+	writeLines(t, filepath.Join(dir, "other.ndjson"), Kind(kafka.SpillOther), "6")
+
+	err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("kept"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+
+	p := &replayProducer{}
+
+	n, err := Replay(context.Background(), dir, p)
+	if err != nil {
+		t.Fatalf("Replay() unexpected error: %s", err.Error())
+	}
+
+	want := []string{"items:1", "items:2", "items:3", "items:4", "events:6", "problems:5"}
+	if diff := cmp.Diff(want, p.produced); diff != "" {
+		t.Fatalf("Replay() produced = %s", diff)
+	}
+
+	if n != len(want) {
+		t.Fatalf("Replay() expected %d delivered records, but got: %d", len(want), n)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %s", err.Error())
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "notes.txt" {
+		t.Fatalf("Replay() expected replayed files to be removed, but got: %v", entries)
+	}
+}
+
+func TestReplay_notDelivered(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "items.ndjson")
+
+	writeLines(t, path, KindItems, "1")
+
+	n, err := Replay(context.Background(), dir, &replayProducer{deliveryErr: errors.New("fail")})
+	if err == nil {
+		t.Fatalf("Replay() expected error")
+	}
+
+	if n != 0 {
+		t.Fatalf("Replay() expected no delivered records, but got: %d", n)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Replay() expected file with undelivered records to be kept, but got: %s", err.Error())
+	}
+}
+
+func TestReplay_missingDir(t *testing.T) {
+	t.Parallel()
+
+	n, err := Replay(context.Background(), filepath.Join(t.TempDir(), "missing"), &replayProducer{})
+	if err != nil || n != 0 {
+		t.Fatalf("Replay() expected nothing to replay, but got: %d, %v", n, err)
+	}
+}
+
+func Test_decodeLine(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record kafka.Record
+	}{
+		{"+json", kafka.Record{Key: "1", Value: []byte(`{"itemid":1}`), Timestamp: now}},
+		{"+binary", kafka.Record{Value: []byte{0x0a, 0x01}, Timestamp: now}},
+		{"+tombstone", kafka.Record{Key: "1", Timestamp: now}},
+		{
+			"+headersAndTopic",
+			kafka.Record{
+				Value:     []byte(`{}`),
+				Topic:     "other",
+				Timestamp: now,
+				Headers:   []kafka.Header{{Key: "a", Value: "b"}, {Key: "c", Value: "d"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b, err := encodeLine(KindItems, &tt.record, now)
+			if err != nil {
+				t.Fatalf("encodeLine() unexpected error: %s", err.Error())
+			}
+
+			kind, got, err := decodeLine(b)
+			if err != nil {
+				t.Fatalf("decodeLine() unexpected error: %s", err.Error())
+			}
+
+			if kind != KindItems {
+				t.Fatalf("decodeLine() expected kind: %s, but got: %s", KindItems, kind)
+			}
+
+			if diff := cmp.Diff(&tt.record, got); diff != "" {
+				t.Fatalf("decodeLine() = %s", diff)
+			}
+		})
+	}
+}
+
+func writeLines(t *testing.T, path string, kind Kind, keys ...string) {
+	t.Helper()
+
+	var data []byte
+
+	for _, k := range keys {
+		b, err := encodeLine(kind, &kafka.Record{Key: k, Value: []byte(`{}`)}, time.Now())
+		if err != nil {
+			t.Fatalf("encodeLine() unexpected error: %s", err.Error())
+		}
+
+		data = append(data, b...)
+	}
+
+	err := os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+}
@@ -0,0 +1,207 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+// Package sink routes produced records to the configured destinations, such as Kafka or files.
+package sink
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"git.zabbix.com/ap/plugin-support/errs"
+)
+
+// Names of the built-in sinks.
+const (
+	NameKafka  = "kafka"
+	NameFile   = "file"
+	NameStdout = "stdout"
+)
+
+// Kinds of records.
+const (
+	KindEvents   Kind = "events"
+	KindItems    Kind = "items"
+	KindProblems Kind = "problems"
+)
+
+var _ kafka.Producer = &Producer{}
+
+// Kind is the kind of a record, it selects the topic or file the record is written to.
+type Kind string
+
+// Sink is a destination for records of all kinds.
+type Sink interface {
+	Produce(ctx context.Context, kind Kind, r *kafka.Record) (*kafka.Delivery, error)
+	// QueueDepth returns the number of records accepted and not yet delivered.
+	QueueDepth() int
+	Close() error
+}
+
+// Factory creates a sink.
+type Factory func() (Sink, error)
+
+// Configuration holds the sink selection per endpoint and the file sink settings.
+type Configuration struct {
+	Events         string `conf:"default=kafka"`
+	Items          string `conf:"default=kafka"`
+	FileDir        string `conf:"optional"`
+	FileMaxSize    int    `conf:"range=1:10240,default=100"`
+	FileMaxBackups int    `conf:"range=0:1000,default=5"`
+}
+
+// Registry creates sinks by name, a sink is created on first use and shared by all endpoints selecting it.
+type Registry struct {
+	mu        sync.Mutex
+	factories map[string]Factory
+	sinks     map[string]Sink
+	order     []string
+}
+
+// Producer routes records to the sinks selected for their kind, problems are routed with events.
+type Producer struct {
+	registry *Registry
+	events   Sink
+	items    Sink
+	sinks    []Sink
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: map[string]Factory{},
+		sinks:     map[string]Sink{},
+	}
+}
+
+// Register adds a sink factory, a factory registered under the same name is replaced.
+func (r *Registry) Register(name string, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[name] = f
+}
+
+// Get returns the sink registered under the name, creating it on first use.
+func (r *Registry) Get(name string) (Sink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sinks[name]; ok {
+		return s, nil
+	}
+
+	f, ok := r.factories[name]
+	if !ok {
+		return nil, errs.Errorf("unknown sink %q, expected one of: %s", name, strings.Join(r.names(), ", "))
+	}
+
+	s, err := f()
+	if err != nil {
+		return nil, errs.Wrapf(err, "failed to create %s sink", name)
+	}
+
+	r.sinks[name] = s
+	r.order = append(r.order, name)
+
+	return s, nil
+}
+
+// Close closes all created sinks, in the order they were created.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var failed []string
+
+	for _, name := range r.order {
+		err := r.sinks[name].Close()
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	r.sinks = map[string]Sink{}
+	r.order = nil
+
+	if len(failed) > 0 {
+		return errs.Errorf("failed to close sinks: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (r *Registry) names() []string {
+	out := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		out = append(out, name)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// NewProducer creates the sinks selected in the configuration, closing the producer closes the registry.
+func NewProducer(r *Registry, c *Configuration) (*Producer, error) {
+	events, err := r.Get(c.Events)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to initialize events sink")
+	}
+
+	items, err := r.Get(c.Items)
+	if err != nil {
+		return nil, errs.Wrap(err, "failed to initialize items sink")
+	}
+
+	p := &Producer{registry: r, events: events, items: items, sinks: []Sink{events}}
+	if items != events {
+		p.sinks = append(p.sinks, items)
+	}
+
+	return p, nil
+}
+
+// ProduceItem produces the record to the items sink.
+func (p *Producer) ProduceItem(ctx context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	return p.items.Produce(ctx, KindItems, r)
+}
+
+// ProduceEvent produces the record to the events sink.
+func (p *Producer) ProduceEvent(ctx context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	return p.events.Produce(ctx, KindEvents, r)
+}
+
+// ProduceProblem produces the record to the events sink.
+func (p *Producer) ProduceProblem(ctx context.Context, r *kafka.Record) (*kafka.Delivery, error) {
+	return p.events.Produce(ctx, KindProblems, r)
+}
+
+// QueueDepth returns the number of records waiting for delivery in all sinks.
+func (p *Producer) QueueDepth() int {
+	var depth int
+	for _, s := range p.sinks {
+		depth += s.QueueDepth()
+	}
+
+	return depth
+}
+
+// Close closes all sinks of the registry.
+func (p *Producer) Close() error {
+	return p.registry.Close()
+}
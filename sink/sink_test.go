@@ -0,0 +1,204 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"git.zabbix.com/ZT/kafka-connector/kafka"
+	"github.com/google/go-cmp/cmp"
+)
+
+var _ Sink = &mockSink{}
+
+type mockSink struct {
+	mu       sync.Mutex
+	kinds    []Kind
+	depth    int
+	closed   int
+	err      error
+	delivery *kafka.Delivery
+}
+
+func (m *mockSink) Produce(_ context.Context, kind Kind, _ *kafka.Record) (*kafka.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.kinds = append(m.kinds, kind)
+
+	if m.delivery != nil {
+		return m.delivery, nil
+	}
+
+	return kafka.Delivered(nil), nil
+}
+
+func (m *mockSink) produced() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.kinds)
+}
+
+func (m *mockSink) QueueDepth() int {
+	return m.depth
+}
+
+func (m *mockSink) Close() error {
+	m.closed++
+
+	return nil
+}
+
+func TestRegistry_Get(t *testing.T) {
+	t.Parallel()
+
+	var created int
+
+	r := NewRegistry()
+	r.Register("mock", func() (Sink, error) {
+		created++
+
+		return &mockSink{}, nil
+	})
+	r.Register("broken", func() (Sink, error) { return nil, errors.New("fail") })
+
+	a, err := r.Get("mock")
+	if err != nil {
+		t.Fatalf("Registry.Get() unexpected error: %s", err.Error())
+	}
+
+	b, err := r.Get("mock")
+	if err != nil {
+		t.Fatalf("Registry.Get() unexpected error: %s", err.Error())
+	}
+
+	if a != b || created != 1 {
+		t.Fatalf("Registry.Get() expected sink to be created once and shared, but got %d sinks", created)
+	}
+
+	if _, err = r.Get("unknown"); err == nil {
+		t.Fatalf("Registry.Get() expected error for unknown sink")
+	}
+
+	if _, err = r.Get("broken"); err == nil {
+		t.Fatalf("Registry.Get() expected factory error")
+	}
+
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("Registry.Close() unexpected error: %s", err.Error())
+	}
+
+	if a.(*mockSink).closed != 1 {
+		t.Fatalf("Registry.Close() expected created sink to be closed once, but got: %d", a.(*mockSink).closed)
+	}
+}
+
+func TestProducer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		conf       Configuration
+		wantA      []Kind
+		wantB      []Kind
+		wantDepth  int
+		wantClosed int
+		wantErr    bool
+	}{
+		{
+			"+sameSink",
+			Configuration{Events: "a", Items: "a"},
+			[]Kind{KindEvents, KindItems, KindProblems},
+			nil,
+			1,
+			1,
+			false,
+		},
+		{
+			"+perEndpoint",
+			Configuration{Events: "a", Items: "b"},
+			[]Kind{KindEvents, KindProblems},
+			[]Kind{KindItems},
+			3,
+			1,
+			false,
+		},
+		{"-unknownEvents", Configuration{Events: "c", Items: "a"}, nil, nil, 0, 0, true},
+		{"-unknownItems", Configuration{Events: "a", Items: "c"}, nil, nil, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := &mockSink{depth: 1}, &mockSink{depth: 2}
+
+			r := NewRegistry()
+			r.Register("a", func() (Sink, error) { return a, nil })
+			r.Register("b", func() (Sink, error) { return b, nil })
+
+			p, err := NewProducer(r, &tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewProducer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			ctx := context.Background()
+
+			for _, produce := range []func(context.Context, *kafka.Record) (*kafka.Delivery, error){
+				p.ProduceEvent, p.ProduceItem, p.ProduceProblem,
+			} {
+				_, err = produce(ctx, &kafka.Record{})
+				if err != nil {
+					t.Fatalf("Producer produce unexpected error: %s", err.Error())
+				}
+			}
+
+			if diff := cmp.Diff(tt.wantA, a.kinds); diff != "" {
+				t.Fatalf("Producer sink a kinds = %s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantB, b.kinds); diff != "" {
+				t.Fatalf("Producer sink b kinds = %s", diff)
+			}
+
+			if got := p.QueueDepth(); got != tt.wantDepth {
+				t.Fatalf("Producer.QueueDepth() expected: %d, but got: %d", tt.wantDepth, got)
+			}
+
+			err = p.Close()
+			if err != nil {
+				t.Fatalf("Producer.Close() unexpected error: %s", err.Error())
+			}
+
+			if a.closed != tt.wantClosed || b.closed > 1 {
+				t.Fatalf("Producer.Close() expected each created sink to be closed once")
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+// Package systemd implements the systemd service notification, watchdog and socket activation protocols.
+// Without systemd, when the environment variables are not set, all of them do nothing.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.zabbix.com/ap/plugin-support/errs"
+	"git.zabbix.com/ap/plugin-support/log"
+)
+
+// Service states sent with Notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Watchdogger pings the systemd watchdog while the service is healthy.
+type Watchdogger struct {
+	stop chan struct{}
+	once sync.Once
+	// pending is the result of the health check still running, only used by run.
+	pending chan bool
+}
+
+// Status returns the state describing the service status in free form.
+func Status(s string) string {
+	return "STATUS=" + s
+}
+
+// Notify sends the states to the service manager, it does nothing if the service is not started by systemd
+// with Type=notify.
+func Notify(states ...string) error {
+	return notify(os.Getenv("NOTIFY_SOCKET"), states)
+}
+
+// Listeners returns the listening sockets passed by socket activation, nil if there are none.
+// The environment variables are unset, so child processes do not use the sockets.
+func Listeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")     //nolint:errcheck // unsetting can not fail for a valid name
+		os.Unsetenv("LISTEN_FDS")     //nolint:errcheck // unsetting can not fail for a valid name
+		os.Unsetenv("LISTEN_FDNAMES") //nolint:errcheck // unsetting can not fail for a valid name
+	}()
+
+	n, err := listenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]net.Listener, 0, n)
+
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		l, err := net.FileListener(f)
+
+		f.Close() //nolint:errcheck,gosec // the listener holds its own copy of the descriptor
+
+		if err != nil {
+			closeListeners(listeners)
+
+			return nil, errs.Wrapf(err, "failed to use passed socket %d", fd)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// WatchdogInterval returns the interval at which the watchdog expects pings, zero if it is not enabled.
+func WatchdogInterval() time.Duration {
+	return watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"), os.Getpid())
+}
+
+// StartWatchdog pings the watchdog at half of its interval while healthy returns true, so systemd restarts
+// the service once it is unhealthy for longer than the interval. A health check not returning within a quarter
+// of the interval counts as unhealthy. It returns nil if the watchdog is not enabled.
+func StartWatchdog(healthy func() bool) *Watchdogger {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return nil
+	}
+
+	w := &Watchdogger{stop: make(chan struct{})}
+
+	go w.run(interval/2, interval/4, healthy)
+
+	log.Debugf("systemd watchdog enabled, interval %s", interval)
+
+	return w
+}
+
+// Stop stops pinging the watchdog, it is safe to call multiple times and on nil.
+func (w *Watchdogger) Stop() {
+	if w == nil {
+		return
+	}
+
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *Watchdogger) run(interval, timeout time.Duration, healthy func() bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if !w.check(healthy, timeout) {
+				log.Warningf("service is unhealthy, not pinging the systemd watchdog")
+
+				continue
+			}
+
+			err := Notify(Watchdog)
+			if err != nil {
+				log.Errf("failed to ping the systemd watchdog, %s", err.Error())
+			}
+		}
+	}
+}
+
+// check runs the health check, at most for the timeout. A check still running after the timeout is not started
+// again, its result is awaited at the next tick instead.
+func (w *Watchdogger) check(healthy func() bool, timeout time.Duration) bool {
+	if w.pending == nil {
+		w.pending = make(chan bool, 1)
+
+		go func(result chan<- bool) { result <- healthy() }(w.pending)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case ok := <-w.pending:
+		w.pending = nil
+
+		return ok
+	case <-timer.C:
+		log.Warningf("service health check did not complete in %s", timeout)
+
+		return false
+	case <-w.stop:
+		return false
+	}
+}
+
+func notify(socket string, states []string) error {
+	if socket == "" || len(states) == 0 {
+		return nil
+	}
+
+	// abstract socket names start with a null byte.
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errs.Wrap(err, "failed to connect to the notify socket")
+	}
+
+	defer conn.Close() //nolint:errcheck // nothing is left to flush on a datagram socket
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	if err != nil {
+		return errs.Wrap(err, "failed to notify")
+	}
+
+	return nil
+}
+
+// listenFDs returns the number of passed sockets, zero if they are not passed to this process.
+func listenFDs(pid, fds string, self int) (int, error) {
+	if pid == "" || fds == "" {
+		return 0, nil
+	}
+
+	p, err := strconv.Atoi(pid)
+	if err != nil || p != self {
+		return 0, nil //nolint:nilerr // the sockets are meant for another process
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, errs.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	return n, nil
+}
+
+func watchdogInterval(usec, pid string, self int) time.Duration {
+	if usec == "" {
+		return 0
+	}
+
+	if pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil || p != self {
+			return 0
+		}
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Microsecond
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close() //nolint:errcheck,gosec // the listeners are unused
+	}
+}
@@ -0,0 +1,164 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_notify(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "notify")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on notify socket: %s", err.Error())
+	}
+
+	defer conn.Close()
+
+	err = notify(path, []string{Ready, Status("serving")})
+	if err != nil {
+		t.Fatalf("notify() unexpected error: %s", err.Error())
+	}
+
+	buf := make([]byte, 64)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read notification: %s", err.Error())
+	}
+
+	if got, want := string(buf[:n]), "READY=1\nSTATUS=serving"; got != want {
+		t.Fatalf("notify() expected: %q, but got: %q", want, got)
+	}
+
+	err = notify("", []string{Ready})
+	if err != nil {
+		t.Fatalf("notify() expected no error without a socket, but got: %s", err.Error())
+	}
+
+	err = notify(filepath.Join(t.TempDir(), "missing"), []string{Ready})
+	if err == nil {
+		t.Fatalf("notify() expected error for a missing socket")
+	}
+}
+
+func Test_listenFDs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		pid     string
+		fds     string
+		want    int
+		wantErr bool
+	}{
+		{"+passed", "42", "2", 2, false},
+		{"+unset", "", "", 0, false},
+		{"+otherProcess", "7", "2", 0, false},
+		{"-invalid", "42", "two", 0, true},
+		{"-negative", "42", "-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := listenFDs(tt.pid, tt.fds, 42)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenFDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Fatalf("listenFDs() expected: %d, but got: %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_watchdogInterval(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"+enabled", "30000000", "", 30 * time.Second},
+		{"+ownPID", "1000", "42", time.Millisecond},
+		{"-otherPID", "1000", "7", 0},
+		{"-unset", "", "", 0},
+		{"-invalid", "soon", "", 0},
+		{"-zero", "0", "", 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := watchdogInterval(tt.usec, tt.pid, 42); got != tt.want {
+				t.Fatalf("watchdogInterval() expected: %s, but got: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWatchdogger_check(t *testing.T) {
+	t.Parallel()
+
+	w := &Watchdogger{stop: make(chan struct{})}
+
+	if !w.check(func() bool { return true }, time.Second) {
+		t.Fatalf("Watchdogger.check() expected healthy")
+	}
+
+	if w.check(func() bool { return false }, time.Second) {
+		t.Fatalf("Watchdogger.check() expected unhealthy")
+	}
+
+	release := make(chan struct{})
+
+	var calls atomic.Int32
+
+	slow := func() bool {
+		calls.Add(1)
+		<-release
+
+		return true
+	}
+
+	if w.check(slow, 10*time.Millisecond) {
+		t.Fatalf("Watchdogger.check() expected unhealthy for a check not completing in time")
+	}
+
+	close(release)
+
+	if !w.check(slow, time.Second) {
+		t.Fatalf("Watchdogger.check() expected the result of the pending check")
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("Watchdogger.check() expected the pending check not started again, but got %d calls", n)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package watch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Watcher polls files and calls a function when any of them changes.
+type Watcher struct {
+	paths    []string
+	onChange func()
+	states   []state
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// state of a file, missing files have a zero state.
+type state struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// New starts polling the files every interval, onChange is called once per poll in which
+// any file was modified, created or removed.
+func New(paths []string, interval time.Duration, onChange func()) *Watcher {
+	w := &Watcher{
+		paths:    paths,
+		onChange: onChange,
+		states:   make([]state, len(paths)),
+		stop:     make(chan struct{}),
+	}
+
+	w.poll()
+
+	go w.run(interval)
+
+	return w
+}
+
+// Stop stops polling, it is safe to call multiple times.
+func (w *Watcher) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.poll() {
+				w.onChange()
+			}
+		}
+	}
+}
+
+// poll updates file states, returns true if any of them changed.
+func (w *Watcher) poll() bool {
+	var changed bool
+
+	for i, p := range w.paths {
+		var s state
+
+		info, err := os.Stat(p)
+		if err == nil {
+			s = state{info.ModTime(), info.Size(), true}
+		}
+
+		if s != w.states[i] {
+			changed = true
+			w.states[i] = s
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,93 @@
+/*
+** Copyright (C) 2001-2025 Zabbix SIA
+**
+** This program is free software: you can redistribute it and/or modify it under the terms of
+** the GNU Affero General Public License as published by the Free Software Foundation, version 3.
+**
+** This program is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+** without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+** See the GNU Affero General Public License for more details.
+**
+** You should have received a copy of the GNU Affero General Public License along with this program.
+** If not, see <https://www.gnu.org/licenses/>.
+**/
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher_poll(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "watched")
+
+	w := &Watcher{paths: []string{file}, states: make([]state, 1)}
+
+	if w.poll() {
+		t.Fatalf("Watcher.poll() expected no change for missing file")
+	}
+
+	err := os.WriteFile(file, []byte("foo"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write watched file: %s", err.Error())
+	}
+
+	if !w.poll() {
+		t.Fatalf("Watcher.poll() expected change for created file")
+	}
+
+	if w.poll() {
+		t.Fatalf("Watcher.poll() expected no change for unmodified file")
+	}
+
+	err = os.WriteFile(file, []byte("foobar"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write watched file: %s", err.Error())
+	}
+
+	if !w.poll() {
+		t.Fatalf("Watcher.poll() expected change for modified file")
+	}
+
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatalf("failed to remove watched file: %s", err.Error())
+	}
+
+	if !w.poll() {
+		t.Fatalf("Watcher.poll() expected change for removed file")
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "watched")
+	changed := make(chan struct{}, 1)
+
+	w := New([]string{file}, 10*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer w.Stop()
+
+	err := os.WriteFile(file, []byte("foo"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write watched file: %s", err.Error())
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("New() expected change callback")
+	}
+
+	w.Stop()
+}